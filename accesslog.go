@@ -0,0 +1,484 @@
+package fsck
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Centny/gwf/log"
+)
+
+//DefaultRedactHeader is the default header to redact in HAR capture.
+var DefaultRedactHeader = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+//DefaultRedactBody is the default form/json field to redact in HAR request body.
+var DefaultRedactBody = []string{"password", "passwd", "pwd", "secret", "token", "access_token"}
+
+//AccessLog is the access log and HAR capture for web/ws forward, it is configured by the local uri query of mapping.
+//
+//	log=/path/access.log     append the access log in combined log format
+//	har=1                    enable HAR capture
+//	har_max=100              the max entries to keep in HAR capture
+//	har_body=65536           the max body bytes to keep for each request/response
+//	redact=Key1,Key2         the header to redact in HAR capture, default is Authorization,Proxy-Authorization,Cookie,Set-Cookie
+//	redact_body=Key1,Key2    the form/json field to redact in HAR request body, default is password,passwd,pwd,secret,token,access_token,
+//	                         the form/json body which can't be parsed (compressed or truncated by har_body) is redacted entirely.
+//
+//the body which is compressed or not utf8 text is kept by base64 encoding in HAR capture.
+type AccessLog struct {
+	Mapping    *Mapping
+	HarMax     int
+	HarBody    int
+	Redact     map[string]bool
+	RedactBody map[string]bool
+	out        *os.File
+	har        bool
+	entries    []*HarEntry
+	lck        sync.RWMutex
+}
+
+//NewAccessLog will create the access log by mapping option, it return nil when access log and HAR capture are both disabled.
+func NewAccessLog(m *Mapping) (alog *AccessLog, err error) {
+	var logPath, har, redact, redactBody string
+	var harMax, harBody = 0, -1
+	err = m.LocalValidF(`log,O|S,L:0;har,O|S,O:0~1;har_max,O|I,R:-1;har_body,O|I,R:-1;redact,O|S,L:0;redact_body,O|S,L:0`,
+		&logPath, &har, &harMax, &harBody, &redact, &redactBody)
+	if err != nil || (len(logPath) < 1 && har != "1") {
+		return
+	}
+	alog = &AccessLog{
+		Mapping:    m,
+		HarMax:     harMax,
+		HarBody:    harBody,
+		Redact:     map[string]bool{},
+		RedactBody: map[string]bool{},
+		har:        har == "1",
+		lck:        sync.RWMutex{},
+	}
+	if alog.HarMax < 1 {
+		alog.HarMax = 100
+	}
+	if alog.HarBody < 0 {
+		alog.HarBody = 65536
+	}
+	redacts := DefaultRedactHeader
+	if len(redact) > 0 {
+		redacts = strings.Split(redact, ",")
+	}
+	for _, key := range redacts {
+		alog.Redact[http.CanonicalHeaderKey(strings.TrimSpace(key))] = true
+	}
+	redactBodys := DefaultRedactBody
+	if len(redactBody) > 0 {
+		redactBodys = strings.Split(redactBody, ",")
+	}
+	for _, key := range redactBodys {
+		alog.RedactBody[strings.ToLower(strings.TrimSpace(key))] = true
+	}
+	if len(logPath) > 0 {
+		alog.out, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	return
+}
+
+//Capture return whether HAR capture is enabled.
+func (a *AccessLog) Capture() bool {
+	return a.har
+}
+
+//Log will write one access log line in combined log format.
+func (a *AccessLog) Log(req *http.Request, status int, size int64, begin time.Time) {
+	if a.out == nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	user := "-"
+	if username, _, ok := req.BasicAuth(); ok && len(username) > 0 {
+		user = username
+	}
+	line := fmt.Sprintf("%v - %v [%v] \"%v %v %v\" %v %v \"%v\" \"%v\"\n",
+		host, user, begin.Format("02/Jan/2006:15:04:05 -0700"), req.Method, req.RequestURI, req.Proto,
+		status, size, orDash(req.Referer()), orDash(req.UserAgent()))
+	a.lck.Lock()
+	_, err = a.out.WriteString(line)
+	a.lck.Unlock()
+	if err != nil {
+		log.W("AccessLog(%v) write access log fail with %v", a.Mapping.Name, err)
+	}
+}
+
+//Record will record the request/response to access log and HAR capture.
+func (a *AccessLog) Record(req *http.Request, reqBody *LimitBuffer, res *AccessWriter, begin time.Time) {
+	a.Log(req, res.Status(), res.Size, begin)
+	if !a.har {
+		return
+	}
+	entry := &HarEntry{
+		StartedDateTime: begin.Format(time.RFC3339Nano),
+		Time:            float64(time.Since(begin)) / float64(time.Millisecond),
+		Request: &HarRequest{
+			Method:      req.Method,
+			URL:         requestURL(req),
+			HTTPVersion: req.Proto,
+			Headers:     a.harHeaders(req.Header),
+			QueryString: []*HarPair{},
+			Cookies:     []*HarPair{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: &HarResponse{
+			Status:      res.Status(),
+			StatusText:  http.StatusText(res.Status()),
+			HTTPVersion: req.Proto,
+			Headers:     a.harHeaders(res.Header()),
+			Cookies:     []*HarPair{},
+			Content: &HarContent{
+				Size:     res.Size,
+				MimeType: res.Header().Get("Content-Type"),
+			},
+			RedirectURL: res.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    res.Size,
+		},
+		Cache: map[string]interface{}{},
+	}
+	entry.Timings.Wait = entry.Time
+	entry.Response.Content.Text, entry.Response.Content.Encoding = harText(res.Body.Bytes(), res.Header())
+	for key, vals := range req.URL.Query() {
+		for _, val := range vals {
+			entry.Request.QueryString = append(entry.Request.QueryString, &HarPair{Name: key, Value: val})
+		}
+	}
+	if reqBody != nil && reqBody.Size > 0 {
+		entry.Request.BodySize = reqBody.Size
+		entry.Request.PostData = &HarPostData{
+			MimeType: req.Header.Get("Content-Type"),
+		}
+		entry.Request.PostData.Text, entry.Request.PostData.Encoding = a.harPostText(reqBody.Bytes(), req.Header)
+	}
+	a.lck.Lock()
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > a.HarMax {
+		a.entries = a.entries[len(a.entries)-a.HarMax:]
+	}
+	a.lck.Unlock()
+}
+
+func (a *AccessLog) harHeaders(header http.Header) (pairs []*HarPair) {
+	pairs = []*HarPair{}
+	for key, vals := range header {
+		for _, val := range vals {
+			if a.Redact[key] {
+				val = "<redacted>"
+			}
+			pairs = append(pairs, &HarPair{Name: key, Value: val})
+		}
+	}
+	return
+}
+
+//harPostText return the text of request body in HAR with the field of form/json is redacted,
+//the form/json body is redacted entirely when it can't be parsed.
+func (a *AccessLog) harPostText(body []byte, header http.Header) (text, encoding string) {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	isForm := mediaType == "application/x-www-form-urlencoded"
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if !isForm && !isJSON {
+		text, encoding = harText(body, header)
+		return
+	}
+	text = "<redacted>"
+	if contentEncoding := header.Get("Content-Encoding"); len(contentEncoding) > 0 && contentEncoding != "identity" {
+		return
+	}
+	redacted := false
+	if isForm {
+		vals, err := url.ParseQuery(string(body))
+		if err != nil {
+			return
+		}
+		for key := range vals {
+			if a.RedactBody[strings.ToLower(key)] {
+				vals[key] = []string{"<redacted>"}
+				redacted = true
+			}
+		}
+		if redacted {
+			text = vals.Encode()
+			return
+		}
+	} else {
+		var val interface{}
+		if err := json.Unmarshal(body, &val); err != nil {
+			return
+		}
+		if redacted = a.redactJSON(val); redacted {
+			buf := bytes.NewBuffer(nil)
+			encoder := json.NewEncoder(buf)
+			encoder.SetEscapeHTML(false)
+			encoder.Encode(val)
+			text = strings.TrimSpace(buf.String())
+			return
+		}
+	}
+	text, encoding = harText(body, header)
+	return
+}
+
+func (a *AccessLog) redactJSON(val interface{}) (redacted bool) {
+	switch val := val.(type) {
+	case map[string]interface{}:
+		for key, sub := range val {
+			if a.RedactBody[strings.ToLower(key)] {
+				val[key] = "<redacted>"
+				redacted = true
+			} else if a.redactJSON(sub) {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for _, sub := range val {
+			if a.redactJSON(sub) {
+				redacted = true
+			}
+		}
+	}
+	return
+}
+
+//harText return the text of body in HAR, the body is encoded by base64 when it is compressed or not utf8 text.
+func harText(body []byte, header http.Header) (text, encoding string) {
+	if contentEncoding := header.Get("Content-Encoding"); (len(contentEncoding) < 1 || contentEncoding == "identity") && utf8.Valid(body) {
+		text = string(body)
+		return
+	}
+	text, encoding = base64.StdEncoding.EncodeToString(body), "base64"
+	return
+}
+
+//HAR return the captured entries as HAR.
+func (a *AccessLog) HAR() (har *HAR) {
+	har = &HAR{}
+	har.Log.Version = "1.2"
+	har.Log.Creator.Name = "sctrl"
+	har.Log.Creator.Version = "1.0"
+	a.lck.RLock()
+	har.Log.Entries = append([]*HarEntry{}, a.entries...)
+	a.lck.RUnlock()
+	return
+}
+
+//Close will close the access log file.
+func (a *AccessLog) Close() (err error) {
+	if a.out != nil {
+		err = a.out.Close()
+	}
+	return
+}
+
+func orDash(val string) string {
+	if len(val) < 1 {
+		return "-"
+	}
+	return val
+}
+
+func requestURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%v://%v%v", scheme, req.Host, req.URL.RequestURI())
+}
+
+//HAR is the http archive format.
+type HAR struct {
+	Log struct {
+		Version string `json:"version"`
+		Creator struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"creator"`
+		Entries []*HarEntry `json:"entries"`
+	} `json:"log"`
+}
+
+//HarEntry is the entry of HAR.
+type HarEntry struct {
+	StartedDateTime string                 `json:"startedDateTime"`
+	Time            float64                `json:"time"`
+	Request         *HarRequest            `json:"request"`
+	Response        *HarResponse           `json:"response"`
+	Cache           map[string]interface{} `json:"cache"`
+	Timings         struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+}
+
+//HarPair is the name/value pair of HAR.
+type HarPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+//HarPostData is the request body of HAR.
+type HarPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+//HarRequest is the request of HAR.
+type HarRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []*HarPair   `json:"headers"`
+	QueryString []*HarPair   `json:"queryString"`
+	Cookies     []*HarPair   `json:"cookies"`
+	PostData    *HarPostData `json:"postData,omitempty"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+//HarContent is the response body of HAR.
+type HarContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+//HarResponse is the response of HAR.
+type HarResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []*HarPair  `json:"headers"`
+	Cookies     []*HarPair  `json:"cookies"`
+	Content     *HarContent `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+//WriteTo will write the HAR in json to w.
+func (h *HAR) WriteTo(w io.Writer) (n int64, err error) {
+	bys, err := json.MarshalIndent(h, "", "  ")
+	if err == nil {
+		var written int
+		written, err = w.Write(bys)
+		n = int64(written)
+	}
+	return
+}
+
+//LimitBuffer is the buffer to keep the first Limit bytes and count all written bytes.
+type LimitBuffer struct {
+	bytes.Buffer
+	Limit int
+	Size  int64
+}
+
+//NewLimitBuffer will create the limit buffer.
+func NewLimitBuffer(limit int) *LimitBuffer {
+	return &LimitBuffer{Limit: limit}
+}
+
+func (l *LimitBuffer) Write(p []byte) (n int, err error) {
+	n = len(p)
+	l.Size += int64(n)
+	if remain := l.Limit - l.Buffer.Len(); remain > 0 {
+		if remain > n {
+			remain = n
+		}
+		l.Buffer.Write(p[:remain])
+	}
+	return
+}
+
+//AccessWriter is the http.ResponseWriter to record the status/size/body of response.
+type AccessWriter struct {
+	http.ResponseWriter
+	Code int
+	Size int64
+	Body *LimitBuffer
+}
+
+//NewAccessWriter will create the access writer, the limit is the max body bytes to keep.
+func NewAccessWriter(w http.ResponseWriter, limit int) *AccessWriter {
+	return &AccessWriter{ResponseWriter: w, Body: NewLimitBuffer(limit)}
+}
+
+//Status return the response status code.
+func (a *AccessWriter) Status() int {
+	if a.Code == 0 {
+		return http.StatusOK
+	}
+	return a.Code
+}
+
+func (a *AccessWriter) WriteHeader(code int) {
+	if a.Code == 0 {
+		a.Code = code
+	}
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *AccessWriter) Write(p []byte) (n int, err error) {
+	n, err = a.ResponseWriter.Write(p)
+	a.Size += int64(n)
+	a.Body.Write(p[:n])
+	return
+}
+
+//Flush is the http.Flusher implementation.
+func (a *AccessWriter) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+//Hijack is the http.Hijacker implementation, it is used by websocket upgrade.
+func (a *AccessWriter) Hijack() (conn net.Conn, rw *bufio.ReadWriter, err error) {
+	hijacker, ok := a.ResponseWriter.(http.Hijacker)
+	if !ok {
+		err = fmt.Errorf("the response writer is not http.Hijacker")
+		return
+	}
+	conn, rw, err = hijacker.Hijack()
+	if err == nil && a.Code == 0 {
+		a.Code = http.StatusSwitchingProtocols
+	}
+	return
+}
+
+type accessBody struct {
+	io.ReadCloser
+	out io.Writer
+}
+
+func (a *accessBody) Read(p []byte) (n int, err error) {
+	n, err = a.ReadCloser.Read(p)
+	if n > 0 {
+		a.out.Write(p[:n])
+	}
+	return
+}
@@ -0,0 +1,222 @@
+package fsck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/routing"
+	"github.com/Centny/gwf/routing/httptest"
+)
+
+func TestAccessLog(t *testing.T) {
+	os.Remove("/tmp/test_access.log")
+	ts := httptest.NewServer(func(hs *routing.HTTPSession) routing.HResult {
+		body, _ := ioutil.ReadAll(hs.R.Body)
+		hs.W.Header().Set("Set-Cookie", "a=1")
+		return hs.Printf("ok-%s", body)
+	})
+	forward := NewForward(func(channel, uri string, raw io.WriteCloser) (session Session, err error) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+		if err == nil {
+			session = &connSession{Conn: conn}
+		}
+		return
+	})
+	forward.WebSuffix = ".loc"
+	_, err := forward.AddUriForward("x", "web://x?log=/tmp/test_access.log&har=1&har_max=2&har_body=4<m>"+ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = forward.AddUriForward("y", "web://y<m>"+ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	webts := httptest.NewMuxServer()
+	webts.Mux.HFunc("^.*$", func(hs *routing.HTTPSession) routing.HResult {
+		hs.R.Host = hs.R.URL.Query().Get("host")
+		return forward.HostForwardF(hs)
+	})
+	for i := 0; i < 3; i++ {
+		data, err := webts.PostN("/abc?host=x.loc", "text/plain", bytes.NewBufferString("123456"))
+		if err != nil || data != "ok-123456" {
+			t.Error(err)
+			return
+		}
+	}
+	webts.G("/abc?host=y.loc")
+	har, err := forward.HAR("x")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(har.Log.Entries) != 2 {
+		t.Error("error")
+		return
+	}
+	entry := har.Log.Entries[0]
+	if entry.Request.PostData.Text != "1234" || entry.Request.BodySize != 6 || entry.Response.Content.Text != "ok-1" || entry.Response.Status != 200 {
+		t.Error("error")
+		return
+	}
+	for _, header := range entry.Response.Headers {
+		if header.Name == "Set-Cookie" && header.Value != "<redacted>" {
+			t.Error("error")
+			return
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	har.WriteTo(buf)
+	if err = json.Unmarshal(buf.Bytes(), &HAR{}); err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := ioutil.ReadFile("/tmp/test_access.log")
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"POST /abc?host=x.loc HTTP/1.1" 200 9`) {
+		t.Error(string(data))
+		return
+	}
+	//
+	//not enabled
+	_, err = forward.HAR("y")
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	_, err = forward.HAR("none")
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	forward.RemoveForward("web://x")
+	if len(forward.accessLogs) != 1 {
+		t.Error("error")
+		return
+	}
+	forward.Close()
+}
+
+func TestAccessWriterHijack(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	writers := make(chan *AccessWriter, 1)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := NewAccessWriter(w, 1024)
+		writer.Flush()
+		conn, rw, err := writer.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\nhijacked")
+		rw.Flush()
+		conn.Close()
+		writers <- writer
+	}))
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	data, _ := ioutil.ReadAll(conn)
+	conn.Close()
+	if !strings.HasSuffix(string(data), "hijacked") || (<-writers).Status() != http.StatusSwitchingProtocols {
+		t.Error(string(data))
+		return
+	}
+	//not hijacker
+	if _, _, err = NewAccessWriter(&testResponseWriter{}, 10).Hijack(); err == nil {
+		t.Error("error")
+		return
+	}
+}
+
+type testResponseWriter struct {
+	header http.Header
+}
+
+func (t *testResponseWriter) Header() http.Header         { return t.header }
+func (t *testResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (t *testResponseWriter) WriteHeader(code int)        {}
+
+func TestAccessLogBody(t *testing.T) {
+	mapping, err := NewMapping("x", "web://x?har=1&har_body=64&redact_body=pass,Token<m>http://127.0.0.1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	alog, err := NewAccessLog(mapping)
+	if err != nil || alog == nil {
+		t.Error(err)
+		return
+	}
+	record := func(header http.Header, body string, resHeader http.Header, resBody []byte) *HarEntry {
+		req, _ := http.NewRequest("POST", "http://x.loc/abc", nil)
+		req.Header = header
+		reqBody := NewLimitBuffer(alog.HarBody)
+		reqBody.Write([]byte(body))
+		res := NewAccessWriter(&testResponseWriter{header: resHeader}, alog.HarBody)
+		res.Write(resBody)
+		alog.Record(req, reqBody, res, time.Now())
+		har := alog.HAR()
+		return har.Log.Entries[len(har.Log.Entries)-1]
+	}
+	form := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	text := http.Header{"Content-Type": {"text/plain"}}
+	//form and json field is redacted
+	entry := record(form, "user=a&pass=123", http.Header{}, []byte("ok"))
+	if entry.Request.PostData.Text != "pass=%3Credacted%3E&user=a" || entry.Response.Content.Text != "ok" || entry.Response.Content.Encoding != "" {
+		t.Errorf("%v", entry.Request.PostData)
+		return
+	}
+	entry = record(http.Header{"Content-Type": {"application/json; charset=utf-8"}}, `{"user":"a","data":[{"token":"x"}]}`, http.Header{}, nil)
+	if entry.Request.PostData.Text != `{"data":[{"token":"<redacted>"}],"user":"a"}` {
+		t.Errorf("%v", entry.Request.PostData)
+		return
+	}
+	entry = record(form, "user=a", http.Header{}, nil)
+	if entry.Request.PostData.Text != "user=a" {
+		t.Errorf("%v", entry.Request.PostData)
+		return
+	}
+	//not parsed body is redacted entirely
+	entry = record(http.Header{"Content-Type": {"application/json"}}, `{"user":"a","pass":"`+strings.Repeat("x", 64)+`"}`, http.Header{}, nil)
+	if entry.Request.PostData.Text != "<redacted>" || entry.Request.PostData.Encoding != "" {
+		t.Errorf("%v", entry.Request.PostData)
+		return
+	}
+	entry = record(http.Header{"Content-Type": form["Content-Type"], "Content-Encoding": {"gzip"}}, "\x1f\x8b\x08", http.Header{}, nil)
+	if entry.Request.PostData.Text != "<redacted>" {
+		t.Errorf("%v", entry.Request.PostData)
+		return
+	}
+	//compressed or binary body is encoded by base64
+	gzipped := []byte{0x1f, 0x8b, 0x08, 0x00}
+	entry = record(text, "\xff\xfe", http.Header{"Content-Encoding": {"gzip"}}, gzipped)
+	if entry.Request.PostData.Text != "//4=" || entry.Request.PostData.Encoding != "base64" ||
+		entry.Response.Content.Text != "H4sIAA==" || entry.Response.Content.Encoding != "base64" {
+		t.Errorf("%v,%v", entry.Request.PostData, entry.Response.Content)
+		return
+	}
+	entry = record(text, "abc", http.Header{"Content-Type": {"text/plain"}}, []byte("日本"))
+	if entry.Request.PostData.Text != "abc" || entry.Response.Content.Text != "日本" || entry.Response.Content.Encoding != "" {
+		t.Errorf("%v,%v", entry.Request.PostData, entry.Response.Content)
+		return
+	}
+}
@@ -0,0 +1,150 @@
+package fsck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+	"golang.org/x/text/encoding"
+)
+
+//AgentResult is the structured result of command which is executed by slaver agent.
+type AgentResult struct {
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Code      int    `json:"code"`
+	Signal    string `json:"signal,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Used      int64  `json:"used"`
+}
+
+//Success return whether the command is exited with zero code.
+func (a *AgentResult) Success() bool {
+	return a.Code == 0 && len(a.Signal) < 1 && len(a.Error) < 1
+}
+
+func (a *AgentResult) String() string {
+	if len(a.Error) > 0 {
+		return fmt.Sprintf("error %v", a.Error)
+	}
+	return (&ExitStatus{Code: a.Code, Signal: a.Signal}).String()
+}
+
+//limitBuffer is the buffer to keep max bytes, the bytes.Buffer is not embedded to avoid io.Copy bypass Write by ReadFrom.
+type limitBuffer struct {
+	buf       bytes.Buffer
+	Max       int
+	Truncated bool
+}
+
+func (l *limitBuffer) Write(p []byte) (n int, err error) {
+	n = len(p)
+	if l.Max > 0 && l.buf.Len()+len(p) > l.Max {
+		p = p[:l.Max-l.buf.Len()]
+		l.Truncated = true
+	}
+	l.buf.Write(p)
+	return
+}
+
+func (l *limitBuffer) Bytes() []byte {
+	return l.buf.Bytes()
+}
+
+func decodeCharset(data []byte, enc encoding.Encoding) string {
+	if enc == nil {
+		return string(data)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+//dialResult will run the command and send the AgentResult as json after command exited,
+//the first stdin bytes received from connection is sent to command stdin.
+func (c *CmdDialer) dialResult(cid uint16, cmd *exec.Cmd, option *CmdOption, stdin int, enc encoding.Encoding) (raw io.ReadWriteCloser, err error) {
+	stdout, stderr := &limitBuffer{Max: c.ResultMax}, &limitBuffer{Max: c.ResultMax}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	writer, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	beg := util.Now()
+	err = cmd.Start()
+	if err != nil {
+		return
+	}
+	local, raw := net.Pipe()
+	go func() {
+		if stdin > 0 {
+			io.CopyN(writer, local, int64(stdin))
+		}
+		writer.Close()
+		//the connection is closed by remote before command exited, kill it with the children.
+		if _, rerr := local.Read(make([]byte, 1)); rerr != nil {
+			killCmdGroup(cmd)
+		}
+	}()
+	go func() {
+		timer := option.Watch(cid, cmd)
+		werr := cmd.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+		exit := NewExitStatus(cmd.ProcessState, werr)
+		log.D("CmdDialer the cmd(%v) is done with %v", cid, exit)
+		result := &AgentResult{
+			Stdout:    decodeCharset(stdout.Bytes(), enc),
+			Stderr:    decodeCharset(stderr.Bytes(), enc),
+			Code:      exit.Code,
+			Signal:    exit.Signal,
+			Truncated: stdout.Truncated || stderr.Truncated,
+			Used:      util.Now() - beg,
+		}
+		if cmd.ProcessState == nil && werr != nil {
+			result.Error = werr.Error()
+		}
+		json.NewEncoder(local).Encode(result)
+		local.Close()
+	}()
+	return
+}
+
+//AgentExec will execute the command on slaver agent by CmdDialer and wait the structured result,
+//the script is sent to command stdin when it is not empty, the query is the extra CmdOption like env/dir/timeout.
+func AgentExec(dial func(uri string) (raw io.ReadWriteCloser, err error), runnable string, script []byte, query url.Values) (result *AgentResult, err error) {
+	args := url.Values{}
+	for key, vals := range query {
+		args[key] = vals
+	}
+	args.Set("exec", runnable)
+	args.Set("result", "json")
+	args.Set("stdin", fmt.Sprintf("%v", len(script)))
+	conn, err := dial("tcp://cmd?" + args.Encode())
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if len(script) > 0 {
+		_, err = conn.Write(script)
+		if err != nil {
+			return
+		}
+	}
+	result = &AgentResult{}
+	err = json.NewDecoder(conn).Decode(result)
+	if err != nil {
+		result = nil
+		err = fmt.Errorf("read agent result fail with %v", err)
+	}
+	return
+}
@@ -0,0 +1,111 @@
+package fsck
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgentExec(t *testing.T) {
+	cmd := NewCmdDialer()
+	dial := func(uri string) (raw io.ReadWriteCloser, err error) {
+		return cmd.Dial(10, uri)
+	}
+	//stdout/stderr/code
+	result, err := AgentExec(dial, "echo out && echo err 1>&2 && exit 3", nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Stdout != "out\n" || result.Stderr != "err\n" || result.Code != 3 || result.Success() {
+		t.Error(result)
+		return
+	}
+	//success with env
+	query := url.Values{}
+	query.Add("env", "AGENT_A=123")
+	result, err = AgentExec(dial, "echo $AGENT_A", nil, query)
+	if err != nil || !result.Success() || result.Stdout != "123\n" {
+		t.Errorf("%v,%v", result, err)
+		return
+	}
+	//script by stdin
+	result, err = AgentExec(dial, "bash -s -- a b", []byte("echo \"$1-$2\"\nexit 0\n"), nil)
+	if err != nil || !result.Success() || result.Stdout != "a-b\n" {
+		t.Errorf("%v,%v", result, err)
+		return
+	}
+	//truncated
+	cmd.ResultMax = 4
+	result, err = AgentExec(dial, "echo 123456789", nil, nil)
+	if err != nil || !result.Truncated || result.Stdout != "1234" {
+		t.Errorf("%v,%v", result, err)
+		return
+	}
+	cmd.ResultMax = 0
+	//timeout
+	query = url.Values{}
+	query.Set("timeout", "1")
+	result, err = AgentExec(dial, "sleep 5", nil, query)
+	if err != nil || result.Success() || len(result.Signal) < 1 {
+		t.Errorf("%v,%v", result, err)
+		return
+	}
+	//kill by close
+	raw, err := cmd.Dial(10, "tcp://cmd?exec=sleep+5&result=json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	beg := time.Now()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		raw.Close()
+	}()
+	io.Copy(ioutil.Discard, raw)
+	if time.Since(beg) > 3*time.Second {
+		t.Error("not killed")
+		return
+	}
+	//the children is killed by close
+	os.Remove("/tmp/fsck_agent_child")
+	raw, err = cmd.Dial(10, "tcp://cmd?exec="+url.QueryEscape("(sleep 1 && touch /tmp/fsck_agent_child) & sleep 5")+"&result=json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	raw.Close()
+	time.Sleep(1500 * time.Millisecond)
+	if _, err = os.Stat("/tmp/fsck_agent_child"); err == nil {
+		t.Error("the children is not killed")
+		return
+	}
+	//error
+	_, err = AgentExec(func(uri string) (raw io.ReadWriteCloser, err error) {
+		return nil, fmt.Errorf("dial error")
+	}, "ls", nil, nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = AgentExec(func(uri string) (raw io.ReadWriteCloser, err error) {
+		return &CombinedReadWriterCloser{Reader: strings.NewReader("xx"), Writer: ioutil.Discard}, nil
+	}, "ls", []byte("xx"), nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = cmd.Dial(10, "tcp://cmd?exec=ls&result=json&stdin=xx")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//for cover
+	fmt.Printf("%v\n", &AgentResult{Error: "xx"})
+}
@@ -0,0 +1,481 @@
+package fsck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//AlertRule is the threshold alert rule, it is parsed from expression like
+//
+//	cpu > 90 for 1m          the realtime key of each host
+//	cpu avg > 90 for 2m      the realtime key aggregated by avg/sum/min/max/p99... of all host
+//	host offline for 30s     the realtime host is not updated in duration
+//	ping used > 500ms        the ping used time of slaver, the failed ping is matched by >/>=
+type AlertRule struct {
+	Expr  string
+	Kind  string //the rule kind in real/offline/ping
+	Key   string
+	Agg   string
+	Op    string
+	Value float64 //the threshold value, it is milliseconds for ping rule.
+	For   time.Duration
+}
+
+//ParseAlertRule will parse the alert rule from expression.
+func ParseAlertRule(expr string) (rule *AlertRule, err error) {
+	rule = &AlertRule{Expr: strings.TrimSpace(expr)}
+	fields := strings.Fields(expr)
+	if len(fields) > 2 && fields[len(fields)-2] == "for" {
+		rule.For, err = time.ParseDuration(fields[len(fields)-1])
+		if err != nil {
+			return
+		}
+		fields = fields[:len(fields)-2]
+	}
+	switch {
+	case len(fields) == 2 && fields[0] == "host" && fields[1] == "offline":
+		rule.Kind = "offline"
+		if rule.For < 1 {
+			err = fmt.Errorf("the offline duration is required by for, like host offline for 30s")
+		}
+		return
+	case len(fields) == 4 && fields[0] == "ping" && fields[1] == "used":
+		rule.Kind, rule.Key, rule.Op = "ping", "used", fields[2]
+		rule.Value, err = strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			var used time.Duration
+			used, err = time.ParseDuration(fields[3])
+			rule.Value = float64(used) / float64(time.Millisecond)
+		}
+	case len(fields) == 3:
+		rule.Kind, rule.Key, rule.Op = "real", fields[0], fields[1]
+		rule.Value, err = strconv.ParseFloat(fields[2], 64)
+	case len(fields) == 4:
+		rule.Kind, rule.Key, rule.Agg, rule.Op = "real", fields[0], fields[1], fields[2]
+		rule.Value, err = strconv.ParseFloat(fields[3], 64)
+		if err == nil && !ValidRealAgg(rule.Agg) {
+			err = fmt.Errorf("the aggregation(%v) is not supported", rule.Agg)
+		}
+	default:
+		err = fmt.Errorf("invalid alert rule(%v)", expr)
+	}
+	if err != nil {
+		return
+	}
+	switch rule.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		err = fmt.Errorf("the operator(%v) is not supported", rule.Op)
+	}
+	return
+}
+
+//Match return whether the value is matched by rule operator.
+func (a *AlertRule) Match(val float64) bool {
+	switch a.Op {
+	case ">":
+		return val > a.Value
+	case ">=":
+		return val >= a.Value
+	case "<":
+		return val < a.Value
+	case "<=":
+		return val <= a.Value
+	case "==":
+		return val == a.Value
+	case "!=":
+		return val != a.Value
+	}
+	return false
+}
+
+func (a *AlertRule) String() string {
+	return a.Expr
+}
+
+//Alert is the alert of rule on instance.
+type Alert struct {
+	Rule     string  `json:"rule"`
+	Instance string  `json:"instance"` //the host or slaver name, it is * for aggregated rule.
+	Value    float64 `json:"value"`    //the last matched value, it is -1 for failed ping.
+	Since    int64   `json:"since"`    //the time of rule matched in unix milliseconds.
+	Firing   bool    `json:"firing"`
+}
+
+func (a *Alert) String() string {
+	return fmt.Sprintf("%v@%v(%v)", a.Rule, a.Instance, a.Value)
+}
+
+//AlertSorter is the sorter to sort alert by since time.
+type AlertSorter []*Alert
+
+func (a AlertSorter) Len() int {
+	return len(a)
+}
+
+func (a AlertSorter) Less(i, j int) bool {
+	if a[i].Since == a[j].Since {
+		return a[i].String() < a[j].String()
+	}
+	return a[i].Since < a[j].Since
+}
+
+func (a AlertSorter) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+//AlertEvent is the alert status changed event which is sent to notifier.
+type AlertEvent struct {
+	Status string `json:"status"` //the status in firing/resolved/stale, the stale is sent when the host of firing alert is timeout.
+	Time   int64  `json:"time"`
+	*Alert
+}
+
+//AlertNotifier is the interface to notify alert event.
+type AlertNotifier interface {
+	Notify(event *AlertEvent) error
+}
+
+//WebhookNotifier will post the alert event as json to URL.
+type WebhookNotifier struct {
+	URL     string
+	Timeout time.Duration
+}
+
+func (w *WebhookNotifier) Notify(event *AlertEvent) (err error) {
+	client := &http.Client{Timeout: w.Timeout}
+	res, err := client.Post(w.URL, "application/json;charset=utf8", bytes.NewBufferString(util.S2Json(event)))
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		err = fmt.Errorf("webhook response status %v", res.StatusCode)
+	}
+	return
+}
+
+func (w *WebhookNotifier) String() string {
+	return "webhook:" + w.URL
+}
+
+//ExecNotifier will run the command by shell, the alert event is passed by environment ALERT_STATUS/ALERT_RULE/
+//ALERT_INSTANCE/ALERT_VALUE and the json on stdin.
+type ExecNotifier struct {
+	Shell   string
+	Command string
+}
+
+func (e *ExecNotifier) Notify(event *AlertEvent) (err error) {
+	cmd := exec.Command(e.Shell, "-c", e.Command)
+	cmd.Env = append(os.Environ(),
+		"ALERT_STATUS="+event.Status,
+		"ALERT_RULE="+event.Rule,
+		"ALERT_INSTANCE="+event.Instance,
+		fmt.Sprintf("ALERT_VALUE=%v", event.Value),
+	)
+	cmd.Stdin = bytes.NewBufferString(util.S2Json(event))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("%v(%v)", err, strings.TrimSpace(string(out)))
+	}
+	return
+}
+
+func (e *ExecNotifier) String() string {
+	return "exec:" + e.Command
+}
+
+//AlertConf is the alert configure.
+type AlertConf struct {
+	Rules    []string `json:"rules"`
+	Webhook  string   `json:"webhook"`  //the webhook url to post alert event.
+	Exec     string   `json:"exec"`     //the command to run on alert event.
+	Interval int      `json:"interval"` //the evaluate interval in seconds.
+	Timeout  int      `json:"timeout"`  //the host is not aggregated when it is not updated in timeout seconds.
+}
+
+//ReadAlertConf will read the alert configure from json file.
+func ReadAlertConf(path string) (conf *AlertConf, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	conf = &AlertConf{}
+	err = json.Unmarshal(data, conf)
+	return
+}
+
+//AlertQueue is the max pending alert events to notify, the event is dropped when the queue is full.
+var AlertQueue = 1024
+
+type alertPing struct {
+	Used   float64
+	Failed bool
+}
+
+//AlertManager will evaluate the alert rules on RealTime and ping by Interval, the event is sent to all notifiers
+//when alert is firing or resolved.
+type AlertManager struct {
+	Rules     []*AlertRule
+	Real      *RealTime
+	Notifiers []AlertNotifier
+	Interval  time.Duration
+	Timeout   time.Duration
+	alerts    map[string]*Alert
+	pings     map[string]*alertPing
+	queue     chan *AlertEvent
+	sender    sync.Once
+	running   bool
+	lck       sync.RWMutex
+}
+
+//NewAlertManager will return new alert manager on RealTime.
+func NewAlertManager(real *RealTime) *AlertManager {
+	return &AlertManager{
+		Real:     real,
+		Interval: 5 * time.Second,
+		Timeout:  30 * time.Second,
+		alerts:   map[string]*Alert{},
+		pings:    map[string]*alertPing{},
+		queue:    make(chan *AlertEvent, AlertQueue),
+	}
+}
+
+//Apply will add the rules and notifiers by configure.
+func (a *AlertManager) Apply(conf *AlertConf) (err error) {
+	for _, expr := range conf.Rules {
+		err = a.AddRule(expr)
+		if err != nil {
+			return
+		}
+	}
+	if len(conf.Webhook) > 0 {
+		a.Notifiers = append(a.Notifiers, &WebhookNotifier{URL: conf.Webhook, Timeout: 10 * time.Second})
+	}
+	if len(conf.Exec) > 0 {
+		a.Notifiers = append(a.Notifiers, &ExecNotifier{Shell: "bash", Command: conf.Exec})
+	}
+	if conf.Interval > 0 {
+		a.Interval = time.Duration(conf.Interval) * time.Second
+	}
+	if conf.Timeout > 0 {
+		a.Timeout = time.Duration(conf.Timeout) * time.Second
+	}
+	return
+}
+
+//AddRule will parse and add the rule.
+func (a *AlertManager) AddRule(expr string) (err error) {
+	rule, err := ParseAlertRule(expr)
+	if err == nil {
+		a.lck.Lock()
+		a.Rules = append(a.Rules, rule)
+		a.lck.Unlock()
+	}
+	return
+}
+
+//ObservePing will record the last ping used milliseconds of slaver, the err is not nil when ping is failed.
+func (a *AlertManager) ObservePing(name string, used int64, err error) {
+	a.lck.Lock()
+	a.pings[name] = &alertPing{Used: float64(used), Failed: err != nil}
+	a.lck.Unlock()
+}
+
+//RemovePing will remove the ping of slaver, it is called when slaver is not pinged.
+func (a *AlertManager) RemovePing(name string) {
+	a.lck.Lock()
+	delete(a.pings, name)
+	a.lck.Unlock()
+}
+
+//Evaluate will evaluate all rules once and notify the changed alerts, the alert event is sent to notifiers
+//in background by queue, so the slow notifier will not block the evaluation.
+func (a *AlertManager) Evaluate() {
+	now := util.Now()
+	a.lck.RLock()
+	rules := a.Rules
+	a.lck.RUnlock()
+	events := []*AlertEvent{}
+	matched := map[string]bool{}
+	stale := map[string]bool{}
+	for _, rule := range rules {
+		values, offline := a.evaluate(rule, now)
+		for instance := range offline {
+			stale[rule.Expr+"|"+instance] = true
+		}
+		for instance, val := range values {
+			key := rule.Expr + "|" + instance
+			matched[key] = true
+			a.lck.Lock()
+			alert := a.alerts[key]
+			if alert == nil {
+				alert = &Alert{Rule: rule.Expr, Instance: instance, Since: now}
+				a.alerts[key] = alert
+			}
+			alert.Value = val
+			if !alert.Firing && (rule.Kind == "offline" || now-alert.Since >= int64(rule.For/time.Millisecond)) {
+				alert.Firing = true
+				copied := *alert
+				events = append(events, &AlertEvent{Status: "firing", Time: now, Alert: &copied})
+			}
+			a.lck.Unlock()
+		}
+	}
+	a.lck.Lock()
+	for key, alert := range a.alerts {
+		if matched[key] {
+			continue
+		}
+		delete(a.alerts, key)
+		if alert.Firing {
+			status := "resolved"
+			if stale[key] {
+				status = "stale"
+			}
+			events = append(events, &AlertEvent{Status: status, Time: now, Alert: alert})
+		}
+	}
+	a.lck.Unlock()
+	if len(events) < 1 {
+		return
+	}
+	a.sender.Do(func() { go a.runNotify() })
+	for _, event := range events {
+		select {
+		case a.queue <- event:
+		default:
+			log.W("AlertManager notify queue is full, drop %v event of %v", event.Status, event.Alert)
+		}
+	}
+}
+
+//evaluate return the matched values by instance, and the timeout hosts which value is unknown for per host rule.
+func (a *AlertManager) evaluate(rule *AlertRule, now int64) (matched map[string]float64, stale map[string]bool) {
+	matched = map[string]float64{}
+	stale = map[string]bool{}
+	switch rule.Kind {
+	case "offline":
+		for name, last := range a.Real.Lasts() {
+			if now-last > int64(rule.For/time.Millisecond) {
+				matched[name] = float64((now - last) / 1000)
+			}
+		}
+	case "ping":
+		a.lck.RLock()
+		for name, ping := range a.pings {
+			if ping.Failed && (rule.Op == ">" || rule.Op == ">=") {
+				matched[name] = -1
+			} else if !ping.Failed && rule.Match(ping.Used) {
+				matched[name] = ping.Used
+			}
+		}
+		a.lck.RUnlock()
+	default:
+		ns := map[string]int64{"*": int64(a.Timeout / time.Millisecond)}
+		if len(rule.Agg) > 0 {
+			hosts, logs := a.Real.MergeLog(ns, map[string]string{rule.Key: rule.Agg})
+			online := 0
+			for name := range hosts {
+				if hosts.StrVal(name) == "ok" {
+					online++
+				}
+			}
+			if online > 0 && logs.Exist(rule.Key) && rule.Match(logs.FloatVal(rule.Key)) {
+				matched["*"] = logs.FloatVal(rule.Key)
+			}
+			return
+		}
+		for name, log := range a.Real.Values(rule.Key, ns["*"]) {
+			if rule.Match(log) {
+				matched[name] = log
+			}
+		}
+		for name, last := range a.Real.Lasts() {
+			if ns["*"] > 0 && now-last > ns["*"] {
+				stale[name] = true
+			}
+		}
+	}
+	return
+}
+
+func (a *AlertManager) runNotify() {
+	for event := range a.queue {
+		a.notify(event)
+	}
+}
+
+func (a *AlertManager) notify(event *AlertEvent) {
+	log.D("AlertManager alert %v is %v", event.Alert, event.Status)
+	for _, notifier := range a.Notifiers {
+		err := notifier.Notify(event)
+		if err != nil {
+			log.W("AlertManager notify %v to %v fail with %v", event.Alert, notifier, err)
+		}
+	}
+}
+
+//Alerts return all firing alerts which is sorted by since time.
+func (a *AlertManager) Alerts() (alerts []*Alert) {
+	alerts = []*Alert{}
+	a.lck.RLock()
+	for _, alert := range a.alerts {
+		if alert.Firing {
+			copied := *alert
+			alerts = append(alerts, &copied)
+		}
+	}
+	a.lck.RUnlock()
+	sort.Sort(AlertSorter(alerts))
+	return
+}
+
+//Start will start the evaluate loop.
+func (a *AlertManager) Start() (err error) {
+	a.lck.Lock()
+	defer a.lck.Unlock()
+	if a.running {
+		err = fmt.Errorf("alert manager is running")
+		return
+	}
+	a.running = true
+	go a.run()
+	return
+}
+
+func (a *AlertManager) run() {
+	log.D("AlertManager start evaluate %v rules by interval(%v)", len(a.Rules), a.Interval)
+	for {
+		a.lck.RLock()
+		running := a.running
+		a.lck.RUnlock()
+		if !running {
+			break
+		}
+		a.Evaluate()
+		time.Sleep(a.Interval)
+	}
+	log.D("AlertManager is stopped")
+}
+
+//Stop will stop the evaluate loop.
+func (a *AlertManager) Stop() {
+	a.lck.Lock()
+	a.running = false
+	a.lck.Unlock()
+}
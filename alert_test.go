@@ -0,0 +1,299 @@
+package fsck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	rule, err := ParseAlertRule("cpu avg > 90 for 2m")
+	if err != nil || rule.Kind != "real" || rule.Key != "cpu" || rule.Agg != "avg" || rule.Op != ">" || rule.Value != 90 || rule.For != 2*time.Minute {
+		t.Errorf("%#v,%v", rule, err)
+		return
+	}
+	rule, err = ParseAlertRule("mem_percent >= 80")
+	if err != nil || rule.Kind != "real" || rule.Key != "mem_percent" || rule.Agg != "" || rule.For != 0 || !rule.Match(80) || rule.Match(79) {
+		t.Errorf("%#v,%v", rule, err)
+		return
+	}
+	rule, err = ParseAlertRule("host offline for 30s")
+	if err != nil || rule.Kind != "offline" || rule.For != 30*time.Second {
+		t.Errorf("%#v,%v", rule, err)
+		return
+	}
+	rule, err = ParseAlertRule("ping used > 500ms")
+	if err != nil || rule.Kind != "ping" || rule.Value != 500 || rule.String() != "ping used > 500ms" {
+		t.Errorf("%#v,%v", rule, err)
+		return
+	}
+	rule, err = ParseAlertRule("ping used > 1s for 10s")
+	if err != nil || rule.Value != 1000 || rule.For != 10*time.Second {
+		t.Errorf("%#v,%v", rule, err)
+		return
+	}
+	for op, vals := range map[string][]float64{"<": {1, 2}, "<=": {2, 3}, "==": {2, 1}, "!=": {1, 2}} {
+		rule, _ = ParseAlertRule("x " + op + " 2")
+		if !rule.Match(vals[0]) || rule.Match(vals[1]) {
+			t.Error(op)
+			return
+		}
+	}
+	for _, expr := range []string{"", "host offline", "cpu xx > 1", "cpu avg > xx", "cpu > xx", "cpu ~ 1", "ping used > xx",
+		"cpu > 1 for xx", "a b c d e"} {
+		if _, err = ParseAlertRule(expr); err == nil {
+			t.Error(expr)
+			return
+		}
+	}
+}
+
+type testNotifier struct {
+	events []*AlertEvent
+	lck    sync.Mutex
+}
+
+func (t *testNotifier) Notify(event *AlertEvent) error {
+	t.lck.Lock()
+	defer t.lck.Unlock()
+	t.events = append(t.events, event)
+	if event.Instance == "error" {
+		return fmt.Errorf("error")
+	}
+	return nil
+}
+
+//Events will wait n events is notified in background and return all notified events.
+func (t *testNotifier) Events(n int) (events []string) {
+	for i := 0; i < 100; i++ {
+		t.lck.Lock()
+		count := len(t.events)
+		t.lck.Unlock()
+		if count >= n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.lck.Lock()
+	defer t.lck.Unlock()
+	for _, event := range t.events {
+		events = append(events, fmt.Sprintf("%v:%v@%v", event.Status, event.Rule, event.Instance))
+	}
+	t.events = nil
+	return
+}
+
+func TestAlertManager(t *testing.T) {
+	real := NewRealTime()
+	notifier := &testNotifier{}
+	alert := NewAlertManager(real)
+	alert.Notifiers = append(alert.Notifiers, notifier)
+	err := alert.Apply(&AlertConf{
+		Rules:    []string{"cpu avg > 90 for 100ms", "cpu > 95", "host offline for 1s", "ping used > 500ms"},
+		Interval: 1,
+		Timeout:  10,
+	})
+	if err != nil || alert.Interval != time.Second || alert.Timeout != 10*time.Second {
+		t.Error(err)
+		return
+	}
+	real.Update("h1", util.Map{"cpu": 99})
+	real.Update("h2", util.Map{"cpu": 90})
+	real.Update("error", util.Map{"cpu": 92})
+	alert.ObservePing("s1", 600, nil)
+	alert.ObservePing("s2", 100, nil)
+	alert.Evaluate()
+	//the avg rule is pending
+	if events := notifier.Events(2); len(events) != 2 || events[0] != "firing:cpu > 95@h1" || events[1] != "firing:ping used > 500ms@s1" {
+		t.Errorf("%v", events)
+		return
+	}
+	real.Update("error", util.Map{"cpu": 96})
+	time.Sleep(150 * time.Millisecond)
+	alert.Evaluate()
+	events := notifier.Events(2)
+	if len(events) != 2 || events[0] != "firing:cpu avg > 90 for 100ms@*" || events[1] != "firing:cpu > 95@error" {
+		t.Errorf("%v", events)
+		return
+	}
+	if alerts := alert.Alerts(); len(alerts) != 4 || alerts[0].Instance != "h1" || alerts[3].Instance != "error" {
+		t.Errorf("%v", alerts)
+		return
+	}
+	//resolved and offline
+	real.Update("h1", util.Map{"cpu": 10})
+	real.Update("error", util.Map{"cpu": 10})
+	real.ls["h2"].Last = util.Now() - 2000
+	alert.ObservePing("s1", 0, fmt.Errorf("error"))
+	alert.Evaluate()
+	events = notifier.Events(4)
+	if len(events) != 4 || fmt.Sprintf("%v", alert.Alerts()) != "[ping used > 500ms@s1(-1) host offline for 1s@h2(2)]" {
+		t.Errorf("%v,%v", events, alert.Alerts())
+		return
+	}
+	//stale
+	real.Update("h1", util.Map{"cpu": 99})
+	alert.Evaluate()
+	if events = notifier.Events(1); len(events) != 1 || events[0] != "firing:cpu > 95@h1" {
+		t.Errorf("%v", events)
+		return
+	}
+	real.ls["h1"].Last = util.Now() - 20000
+	alert.Evaluate()
+	if events = notifier.Events(2); len(events) != 2 || events[0] != "firing:host offline for 1s@h1" || events[1] != "stale:cpu > 95@h1" {
+		t.Errorf("%v", events)
+		return
+	}
+	delete(real.ls, "h1")
+	alert.Evaluate()
+	notifier.Events(1)
+	//remove ping
+	alert.RemovePing("s1")
+	alert.Evaluate()
+	if events = notifier.Events(1); len(events) != 1 || events[0] != "resolved:ping used > 500ms@s1" {
+		t.Errorf("%v", events)
+		return
+	}
+	//loop
+	alert.Interval = 10 * time.Millisecond
+	if err = alert.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = alert.Start(); err == nil {
+		t.Error(err)
+		return
+	}
+	real.Update("h2", util.Map{"cpu": 10})
+	time.Sleep(50 * time.Millisecond)
+	alert.Stop()
+	if events = notifier.Events(1); len(events) != 1 || events[0] != "resolved:host offline for 1s@h2" {
+		t.Errorf("%v", events)
+		return
+	}
+	//error
+	if err = alert.Apply(&AlertConf{Rules: []string{"xx"}}); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+type slowNotifier struct {
+	testNotifier
+	release chan bool
+}
+
+func (s *slowNotifier) Notify(event *AlertEvent) error {
+	<-s.release
+	return s.testNotifier.Notify(event)
+}
+
+func TestAlertSlowNotifier(t *testing.T) {
+	defer func(queue int) {
+		AlertQueue = queue
+	}(AlertQueue)
+	AlertQueue = 1
+	real := NewRealTime()
+	notifier := &slowNotifier{release: make(chan bool)}
+	alert := NewAlertManager(real)
+	alert.Notifiers = append(alert.Notifiers, notifier)
+	alert.AddRule("cpu > 95")
+	real.Update("h1", util.Map{"cpu": 99})
+	real.Update("h2", util.Map{"cpu": 99})
+	real.Update("h3", util.Map{"cpu": 99})
+	//evaluate is not blocked by notifier and the event is dropped when queue is full
+	done := make(chan bool, 1)
+	go func() {
+		alert.Evaluate()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("evaluate is blocked by notifier")
+		return
+	}
+	close(notifier.release)
+	if events := notifier.Events(3); len(events) < 1 || len(events) > 2 {
+		t.Errorf("%v", events)
+		return
+	}
+}
+
+func TestAlertNotifier(t *testing.T) {
+	received := make(chan *AlertEvent, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &AlertEvent{}
+		json.NewDecoder(r.Body).Decode(event)
+		if event.Instance == "bad" {
+			w.WriteHeader(500)
+			return
+		}
+		received <- event
+	}))
+	defer ts.Close()
+	alert := NewAlertManager(NewRealTime())
+	err := alert.Apply(&AlertConf{Webhook: ts.URL, Exec: "cat > /tmp/fsck_alert.json; echo $ALERT_STATUS $ALERT_INSTANCE >> /tmp/fsck_alert.json"})
+	if err != nil || len(alert.Notifiers) != 2 {
+		t.Error(err)
+		return
+	}
+	fmt.Println(alert.Notifiers[0], alert.Notifiers[1])
+	event := &AlertEvent{Status: "firing", Alert: &Alert{Rule: "cpu > 1", Instance: "h1", Value: 2}}
+	if err = alert.Notifiers[0].Notify(event); err != nil {
+		t.Error(err)
+		return
+	}
+	if back := <-received; back.Rule != "cpu > 1" || back.Value != 2 {
+		t.Errorf("%v", back)
+		return
+	}
+	if runtime.GOOS != "windows" {
+		defer os.Remove("/tmp/fsck_alert.json")
+		if err = alert.Notifiers[1].Notify(event); err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ := ioutil.ReadFile("/tmp/fsck_alert.json")
+		if string(data) != util.S2Json(event)+"firing h1\n" {
+			t.Errorf("%s", data)
+			return
+		}
+		if err = (&ExecNotifier{Shell: "bash", Command: "exit 1"}).Notify(event); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	//error
+	event.Instance = "bad"
+	if err = alert.Notifiers[0].Notify(event); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = (&WebhookNotifier{URL: "http://127.0.0.1:1"}).Notify(event); err == nil {
+		t.Error(err)
+		return
+	}
+	alert.notify(event)
+	//conf
+	ioutil.WriteFile("/tmp/fsck_alert_conf.json", []byte(`{"rules":["cpu > 1"],"webhook":"http://localhost"}`), os.ModePerm)
+	defer os.Remove("/tmp/fsck_alert_conf.json")
+	conf, err := ReadAlertConf("/tmp/fsck_alert_conf.json")
+	if err != nil || len(conf.Rules) != 1 || conf.Webhook != "http://localhost" {
+		t.Errorf("%v,%v", conf, err)
+		return
+	}
+	if _, err = ReadAlertConf("/tmp/fsck_alert_none.json"); err == nil {
+		t.Error(err)
+		return
+	}
+}
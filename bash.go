@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
 	"time"
 
@@ -25,6 +26,11 @@ type Cmd struct {
 	Rows   int
 	Cols   int
 	ctrlc  int
+	exit   *ExitStatus
+	lck    sync.RWMutex
+	waited sync.Once
+	resize WinSizeParser
+	timer  *time.Timer
 }
 
 func NewCmd(name, ps1, shell string) (cmd *Cmd) {
@@ -97,6 +103,21 @@ func (c *Cmd) Start() (err error) {
 }
 
 func (c *Cmd) Write(p []byte) (n int, err error) {
+	data, size := c.resize.Parse(p)
+	if size != nil {
+		c.Rows, c.Cols = int(size.Row), int(size.Col)
+		SetWindowRect(size, c.pipe.Fd())
+	}
+	if len(data) < 1 {
+		n = len(p)
+		return
+	}
+	defer func() {
+		if err == nil {
+			n = len(p)
+		}
+	}()
+	p = data
 	ctrlc := bytes.Count(p, CtrlC)
 	if ctrlc > 0 {
 		c.ctrlc += ctrlc
@@ -114,9 +135,43 @@ func (c *Cmd) Write(p []byte) (n int, err error) {
 
 func (c *Cmd) Read(p []byte) (n int, err error) {
 	n, err = c.pipe.Read(p)
+	if err != nil {
+		c.wait()
+	}
 	return
 }
 
+func (c *Cmd) wait() {
+	c.waited.Do(func() {
+		err := c.Raw.Wait()
+		c.lck.Lock()
+		c.exit = NewExitStatus(c.Raw.ProcessState, err)
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.lck.Unlock()
+	})
+}
+
+//Watch will set the timeout timer of command, it is stopped after command exited.
+func (c *Cmd) Watch(timer *time.Timer) {
+	c.lck.Lock()
+	c.timer = timer
+	c.lck.Unlock()
+}
+
+//ExitStatus return the exit status of command, it return nil when command is not exited.
+func (c *Cmd) ExitStatus() *ExitStatus {
+	c.lck.RLock()
+	defer c.lck.RUnlock()
+	return c.exit
+}
+
+//Signal will send signal to command.
+func (c *Cmd) Signal(sig string) error {
+	return SignalCmd(c.Raw, sig)
+}
+
 func (c *Cmd) Close() error {
 	return c.pipe.Close()
 }
@@ -206,3 +261,133 @@ type WinSize struct {
 	PixelX uint16
 	PixelY uint16
 }
+
+var winSizePrefix = []byte{255, 250, 31}
+
+//WinSizeCmd return the in-band window size command as telnet NAWS sequence: IAC SB NAWS <cols> <rows> IAC SE.
+func WinSizeCmd(cols, rows int) (cmd []byte) {
+	cmd = append([]byte{}, winSizePrefix...)
+	for _, val := range []int{cols >> 8, cols, rows >> 8, rows} {
+		cmd = append(cmd, byte(val))
+		if byte(val) == 255 {
+			cmd = append(cmd, 255)
+		}
+	}
+	cmd = append(cmd, 255, 240)
+	return
+}
+
+//ParseWinSizeCmd will parse and strip the window size command from p, the size is the last one or nil when not found.
+func ParseWinSizeCmd(p []byte) (data []byte, size *WinSize) {
+	idx := bytes.Index(p, winSizePrefix)
+	if idx < 0 {
+		data = p
+		return
+	}
+	data = make([]byte, 0, len(p))
+	for idx >= 0 {
+		data = append(data, p[:idx]...)
+		rest := p[idx+len(winSizePrefix):]
+		vals := []byte{}
+		i := 0
+		for ; i < len(rest) && len(vals) < 4; i++ {
+			if rest[i] == 255 {
+				if i+1 >= len(rest) || rest[i+1] != 255 {
+					break
+				}
+				i++
+			}
+			vals = append(vals, rest[i])
+		}
+		if len(vals) == 4 && i+1 < len(rest) && rest[i] == 255 && rest[i+1] == 240 {
+			size = &WinSize{
+				Col: uint16(vals[0])<<8 | uint16(vals[1]),
+				Row: uint16(vals[2])<<8 | uint16(vals[3]),
+			}
+			p = rest[i+2:]
+		} else {
+			data = append(data, winSizePrefix...)
+			p = rest
+		}
+		idx = bytes.Index(p, winSizePrefix)
+	}
+	data = append(data, p...)
+	return
+}
+
+//winSizeMax is the max length of window size command when all values is escaped.
+const winSizeMax = 13
+
+//WinSizeParser will parse and strip the window size command from stream,
+//the incomplete command at the end of data is kept and parsed with the next data.
+type WinSizeParser struct {
+	pending []byte
+}
+
+//Parse will parse and strip the window size command from p, see ParseWinSizeCmd.
+func (w *WinSizeParser) Parse(p []byte) (data []byte, size *WinSize) {
+	if len(w.pending) > 0 {
+		p = append(w.pending, p...)
+		w.pending = nil
+	}
+	data, size = ParseWinSizeCmd(p)
+	begin := len(data) - winSizeMax
+	if begin < 0 {
+		begin = 0
+	}
+	for i := begin; i < len(data); i++ {
+		if data[i] == 255 && isWinSizePrefix(data[i:]) {
+			w.pending = append([]byte{}, data[i:]...)
+			data = data[:i]
+			break
+		}
+	}
+	return
+}
+
+//isWinSizePrefix return whether p is the incomplete window size command.
+func isWinSizePrefix(p []byte) bool {
+	if len(p) <= len(winSizePrefix) {
+		return bytes.HasPrefix(winSizePrefix, p)
+	}
+	if !bytes.HasPrefix(p, winSizePrefix) {
+		return false
+	}
+	rest := p[len(winSizePrefix):]
+	vals, i := 0, 0
+	for ; i < len(rest) && vals < 4; i++ {
+		if rest[i] == 255 {
+			if i+1 >= len(rest) {
+				return true
+			}
+			if rest[i+1] != 255 {
+				return false
+			}
+			i++
+		}
+		vals++
+	}
+	if vals < 4 {
+		return true
+	}
+	rest = rest[i:]
+	return len(rest) < 2 && bytes.HasPrefix([]byte{255, 240}, rest)
+}
+
+//WinSizeStripWriter will strip the window size command from stream before writing, it is used on the session
+//which is not command session, so the resize command sent by shell client is not written to remote.
+type WinSizeStripWriter struct {
+	io.Writer
+	parser WinSizeParser
+}
+
+func (w *WinSizeStripWriter) Write(p []byte) (n int, err error) {
+	data, _ := w.parser.Parse(p)
+	if len(data) > 0 {
+		_, err = w.Writer.Write(data)
+	}
+	if err == nil {
+		n = len(p)
+	}
+	return
+}
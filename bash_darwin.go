@@ -11,6 +11,27 @@ func setCmdAttr(cmd *exec.Cmd) {
 	cmd.SysProcAttr.Setsid = true
 }
 
+//setCmdGroup will run the command in new process group, so the children can be killed by killCmdGroup.
+func setCmdGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+//killCmdGroup will kill the process group of command, the command must be started by setCmdGroup or Setsid.
+func killCmdGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func setCmdCred(cmd *exec.Cmd, uid, gid uint32) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+	return nil
+}
+
 func GetWindowRect(ws *WinSize, fd uintptr) error {
 	_, _, errno := syscall.Syscall(
 		syscall.SYS_IOCTL,
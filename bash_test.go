@@ -1,5 +1,84 @@
 package fsck
 
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWinSizeCmd(t *testing.T) {
+	cmd := WinSizeCmd(255, 60)
+	if !bytes.Equal(cmd, []byte{255, 250, 31, 0, 255, 255, 0, 60, 255, 240}) {
+		t.Error("error")
+		return
+	}
+	data, size := ParseWinSizeCmd(append(append([]byte("ab"), cmd...), 'c'))
+	if string(data) != "abc" || size == nil || size.Col != 255 || size.Row != 60 {
+		t.Error("error")
+		return
+	}
+	data, size = ParseWinSizeCmd(append(append(WinSizeCmd(80, 24), []byte("x")...), WinSizeCmd(120, 40)...))
+	if string(data) != "x" || size == nil || size.Col != 120 || size.Row != 40 {
+		t.Error("error")
+		return
+	}
+	//
+	//not full
+	data, size = ParseWinSizeCmd([]byte{'a', 255, 250, 31, 0, 80})
+	if !bytes.Equal(data, []byte{'a', 255, 250, 31, 0, 80}) || size != nil {
+		t.Error("error")
+		return
+	}
+	data, size = ParseWinSizeCmd([]byte("abc"))
+	if string(data) != "abc" || size != nil {
+		t.Error("error")
+		return
+	}
+}
+
+func TestWinSizeParser(t *testing.T) {
+	cmd := WinSizeCmd(255, 60)
+	//split on every byte
+	for split := 1; split < len(cmd); split++ {
+		parser := &WinSizeParser{}
+		data1, size1 := parser.Parse(append([]byte("ab"), cmd[:split]...))
+		data2, size2 := parser.Parse(append(append([]byte{}, cmd[split:]...), 'c'))
+		if string(data1) != "ab" || size1 != nil || string(data2) != "c" || size2 == nil || size2.Col != 255 || size2.Row != 60 {
+			t.Errorf("split %v: %v,%v,%v,%v", split, data1, size1, data2, size2)
+			return
+		}
+	}
+	//not command
+	parser := &WinSizeParser{}
+	data, size := parser.Parse([]byte{'a', 255})
+	if string(data) != "a" || size != nil {
+		t.Error("error")
+		return
+	}
+	data, size = parser.Parse([]byte{244, 'b'})
+	if !bytes.Equal(data, []byte{255, 244, 'b'}) || size != nil {
+		t.Error("error")
+		return
+	}
+	data, size = parser.Parse([]byte{255, 250, 31, 0, 80, 0, 24, 1, 2})
+	if !bytes.Equal(data, []byte{255, 250, 31, 0, 80, 0, 24, 1, 2}) || size != nil {
+		t.Error("error")
+		return
+	}
+	//strip writer
+	buf := bytes.NewBuffer(nil)
+	writer := &WinSizeStripWriter{Writer: buf}
+	for _, part := range [][]byte{[]byte("x"), cmd[:4], cmd[4:], []byte("y")} {
+		if n, err := writer.Write(part); err != nil || n != len(part) {
+			t.Error(err)
+			return
+		}
+	}
+	if buf.String() != "xy" {
+		t.Error(buf.String())
+		return
+	}
+}
+
 // func TestBash(t *testing.T) {
 // 	cmd := NewCmd("n1", "", "bash")
 // 	cback := make(chan []byte)
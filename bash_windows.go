@@ -1,6 +1,7 @@
 package fsck
 
 import (
+	"fmt"
 	"os/exec"
 )
 
@@ -8,6 +9,18 @@ func setCmdAttr(cmd *exec.Cmd) {
 
 }
 
+func setCmdGroup(cmd *exec.Cmd) {
+
+}
+
+func killCmdGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func setCmdCred(cmd *exec.Cmd, uid, gid uint32) error {
+	return fmt.Errorf("run command as other user is not supported on windows")
+}
+
 func GetWindowRect(ws *WinSize, fd uintptr) error {
 	ws.Col = 100
 	ws.Row = 80
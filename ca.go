@@ -0,0 +1,230 @@
+package fsck
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+)
+
+const (
+	CaCertFile = "ca.pem"
+	CaKeyFile  = "ca.key"
+)
+
+//CA is the local certificate authority to issue the leaf certificate for web forward host on demand.
+type CA struct {
+	Dir     string
+	Cert    *x509.Certificate
+	Key     *ecdsa.PrivateKey
+	Allowed func(host string) bool
+	certPEM []byte
+	leafs   map[string]*tls.Certificate
+	lck     sync.RWMutex
+}
+
+//LoadCA will load the ca cert/key from dir, it will generate new one when not exists.
+func LoadCA(dir string) (ca *CA, err error) {
+	ca = &CA{
+		Dir:   dir,
+		leafs: map[string]*tls.Certificate{},
+		lck:   sync.RWMutex{},
+	}
+	certPath, keyPath := filepath.Join(dir, CaCertFile), filepath.Join(dir, CaKeyFile)
+	_, err = os.Stat(certPath)
+	if os.IsNotExist(err) {
+		err = ca.generate(certPath, keyPath)
+		if err == nil {
+			log.D("CA generate new ca to %v success", dir)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		err = fmt.Errorf("decode ca cert on %v fail", certPath)
+		return
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		err = fmt.Errorf("decode ca key on %v fail", keyPath)
+		return
+	}
+	ca.Cert, err = x509.ParseCertificate(certBlock.Bytes)
+	if err == nil {
+		ca.Key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	}
+	ca.certPEM = certPEM
+	return
+}
+
+func (c *CA) generate(certPath, keyPath string) (err error) {
+	err = os.MkdirAll(c.Dir, 0700)
+	if err != nil {
+		return
+	}
+	c.Key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Sctrl"},
+			CommonName:   fmt.Sprintf("Sctrl Local CA(%v)", hostname),
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &c.Key.PublicKey, c.Key)
+	if err != nil {
+		return
+	}
+	c.Cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return
+	}
+	keyDer, err := x509.MarshalECPrivateKey(c.Key)
+	if err != nil {
+		return
+	}
+	c.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	err = ioutil.WriteFile(certPath, c.certPEM, 0644)
+	if err == nil {
+		err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	return
+}
+
+//Issue will return the leaf certificate for host, it will be cached after first issued.
+func (c *CA) Issue(host string) (cert *tls.Certificate, err error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if len(host) < 1 {
+		err = fmt.Errorf("the host is empty")
+		return
+	}
+	c.lck.RLock()
+	cert = c.leafs[host]
+	c.lck.RUnlock()
+	if cert != nil && time.Now().Before(cert.Leaf.NotAfter.Add(-24*time.Hour)) {
+		return
+	}
+	if c.Allowed != nil && !c.Allowed(host) {
+		err = fmt.Errorf("host(%v) is not allowed to issue", host)
+		return
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Sctrl"},
+			CommonName:   host,
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().AddDate(1, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.Cert, &key.PublicKey, c.Key)
+	if err != nil {
+		return
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return
+	}
+	cert = &tls.Certificate{
+		Certificate: [][]byte{der, c.Cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	c.lck.Lock()
+	c.leafs[host] = cert
+	c.lck.Unlock()
+	log.D("CA issue certificate for host(%v) success", host)
+	return
+}
+
+//GetCertificate is the tls.Config.GetCertificate to issue certificate by SNI.
+func (c *CA) GetCertificate(hello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
+	host := hello.ServerName
+	if len(host) < 1 {
+		if addr := hello.Conn.LocalAddr(); addr != nil {
+			host, _, _ = net.SplitHostPort(addr.String())
+		}
+	}
+	cert, err = c.Issue(host)
+	if err != nil {
+		log.W("CA issue certificate for host(%v) fail with %v", host, err)
+	}
+	return
+}
+
+//TLSConfig return the tls config which is using CA to issue certificate.
+func (c *CA) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+		NextProtos:     []string{"http/1.1"},
+	}
+}
+
+//CertPEM return the CA certificate in PEM format.
+func (c *CA) CertPEM() []byte {
+	return c.certPEM
+}
+
+//ExportCA will write the CA certificate in PEM format to w.
+func (c *CA) ExportCA(w io.Writer) (err error) {
+	_, err = w.Write(c.certPEM)
+	return
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,77 @@
+package fsck
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+)
+
+func TestCA(t *testing.T) {
+	os.RemoveAll("/tmp/test_ca")
+	ca, err := LoadCA("/tmp/test_ca")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ca.Allowed = func(host string) bool {
+		return host != "deny.loc"
+	}
+	cert, err := ca.Issue("test.loc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "test.loc", Roots: pool})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cert2, _ := ca.Issue("TEST.loc.")
+	if cert2 != cert {
+		t.Error("error")
+		return
+	}
+	cert, err = ca.Issue("127.0.0.1")
+	if err != nil || len(cert.Leaf.IPAddresses) != 1 {
+		t.Error(err)
+		return
+	}
+	_, err = ca.Issue("deny.loc")
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	_, err = ca.GetCertificate(&tls.ClientHelloInfo{ServerName: "test.loc"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//
+	//reload
+	ca2, err := LoadCA("/tmp/test_ca")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !ca2.Cert.Equal(ca.Cert) {
+		t.Error("error")
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	ca2.ExportCA(buf)
+	if !bytes.Equal(buf.Bytes(), ca.CertPEM()) {
+		t.Error("error")
+		return
+	}
+	//
+	//error
+	_, err = LoadCA("/dev/null/xx")
+	if err == nil {
+		t.Error("error")
+		return
+	}
+}
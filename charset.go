@@ -0,0 +1,101 @@
+package fsck
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+var localeName = regexp.MustCompile(`^([a-z]{2,3}(_[A-Z]{2})?|C|POSIX)(\.|$)`)
+
+var charsetAlias = map[string]string{
+	"eucjp": "euc-jp",
+	"euckr": "euc-kr",
+	"euctw": "euc-tw",
+	"cp932": "shift_jis",
+	"cp949": "euc-kr",
+	"cp950": "big5",
+}
+
+var charsetNative = map[string]bool{
+	"":               true,
+	"utf-8":          true,
+	"utf8":           true,
+	"ascii":          true,
+	"us-ascii":       true,
+	"ansi_x3.4-1968": true,
+	"c":              true,
+	"posix":          true,
+}
+
+//LookupCharset will find the encoding by charset or locale name, like GBK/zh_CN.GBK/ja_JP.SJIS/ru_RU.CP1251,
+//it return nil encoding when the charset is UTF-8/ASCII which is not needed to transcode,
+//the locale without codeset like en_US and the C/POSIX locale with any codeset is treated as UTF-8.
+func LookupCharset(name string) (enc encoding.Encoding, err error) {
+	charset := strings.TrimSpace(name)
+	if idx := strings.Index(charset, "@"); idx > -1 {
+		charset = charset[:idx]
+	}
+	if locale := localeName.FindStringSubmatch(charset); locale != nil {
+		if locale[1] == "C" || locale[1] == "POSIX" {
+			return
+		}
+		charset = charset[len(locale[0]):]
+	}
+	charset = strings.ToLower(charset)
+	if charsetNative[charset] {
+		return
+	}
+	if alias, ok := charsetAlias[charset]; ok {
+		charset = alias
+	}
+	enc, err = ianaindex.IANA.Encoding(charset)
+	if err != nil || enc == nil {
+		enc, err = htmlindex.Get(charset)
+	}
+	if err != nil || enc == nil {
+		enc, err = nil, fmt.Errorf("charset(%v) is not supported", name)
+	}
+	return
+}
+
+//DetectCharset will detect the charset by locale environment of LC_ALL/LC_CTYPE/LANG.
+func DetectCharset(getenv func(key string) string) string {
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if val := getenv(key); len(val) > 0 {
+			return val
+		}
+	}
+	return ""
+}
+
+//NewCharsetReader will return the reader which decode the data from charset to UTF-8.
+func NewCharsetReader(reader io.Reader, enc encoding.Encoding) io.Reader {
+	if enc == nil {
+		return reader
+	}
+	return transform.NewReader(reader, enc.NewDecoder())
+}
+
+//NewCharsetWriter will return the writer which encode the UTF-8 data to charset,
+//the character not supported by charset is replaced.
+func NewCharsetWriter(writer io.Writer, enc encoding.Encoding) io.Writer {
+	if enc == nil {
+		return writer
+	}
+	return transform.NewWriter(writer, encoding.ReplaceUnsupported(enc.NewEncoder()))
+}
+
+//NewCharsetOutWriter will return the writer which decode the data from charset to UTF-8 before write.
+func NewCharsetOutWriter(writer io.Writer, enc encoding.Encoding) io.Writer {
+	if enc == nil {
+		return writer
+	}
+	return transform.NewWriter(writer, enc.NewDecoder())
+}
@@ -0,0 +1,91 @@
+package fsck
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestLookupCharset(t *testing.T) {
+	for _, name := range []string{"GBK", "zh_CN.GB18030", "ja_JP.SJIS", "ja_JP.eucJP", "ko_KR.eucKR", "ru_RU.CP1251", "KOI8-R", "cp936", "zh_TW.Big5@x"} {
+		enc, err := LookupCharset(name)
+		if err != nil || enc == nil {
+			t.Errorf("%v:%v", name, err)
+			return
+		}
+	}
+	for _, name := range []string{"", "C", "POSIX", "C.UTF-8", "C.utf8", "POSIX.ISO-8859-1", "en_US", "en_US.UTF-8", "en_US.utf8", "zh_CN@x", "ANSI_X3.4-1968"} {
+		enc, err := LookupCharset(name)
+		if err != nil || enc != nil {
+			t.Errorf("%v:%v", name, err)
+			return
+		}
+	}
+	if _, err := LookupCharset("xx_XX.NOTEXIST"); err == nil {
+		t.Error("error")
+		return
+	}
+	env := map[string]string{"LC_CTYPE": "ja_JP.SJIS", "LANG": "en_US.UTF-8"}
+	if DetectCharset(func(key string) string { return env[key] }) != "ja_JP.SJIS" {
+		t.Error("error")
+		return
+	}
+	env = map[string]string{"LANG": "C.UTF-8"}
+	if enc, err := LookupCharset(DetectCharset(func(key string) string { return env[key] })); err != nil || enc != nil {
+		t.Errorf("%v:%v", enc, err)
+		return
+	}
+	//
+	enc, _ := LookupCharset("ja_JP.SJIS")
+	sjis, _ := japanese.ShiftJIS.NewEncoder().Bytes([]byte("日本語"))
+	data, _ := ioutil.ReadAll(NewCharsetReader(bytes.NewBuffer(sjis), enc))
+	if string(data) != "日本語" {
+		t.Error("error")
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	NewCharsetWriter(buf, enc).Write([]byte("日本語"))
+	if !bytes.Equal(buf.Bytes(), sjis) {
+		t.Error("error")
+		return
+	}
+	buf.Reset()
+	NewCharsetOutWriter(buf, enc).Write(sjis)
+	if buf.String() != "日本語" {
+		t.Error("error")
+		return
+	}
+}
+
+func TestCmdCharset(t *testing.T) {
+	dailer := NewCmdDialer()
+	raw, err := dailer.Dial(10, "tcp://cmd?exec=printf%20%27%5Cx93%5Cxfa%27&LC=ja_JP.SJIS")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := ioutil.ReadAll(raw)
+	if string(data) != "日" {
+		t.Errorf("error:%v", data)
+		return
+	}
+	for _, lc := range []string{"C.UTF-8", "en_US", "POSIX"} {
+		raw, err = dailer.Dial(10, "tcp://cmd?exec=printf%20%27%5Cxe6%5Cx97%5Cxa5%27&LC="+lc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ = ioutil.ReadAll(raw)
+		if string(data) != "日" {
+			t.Errorf("%v:%v", lc, data)
+			return
+		}
+	}
+	_, err = dailer.Dial(10, "tcp://cmd?exec=ls&LC=xx.NOTEXIST")
+	if err == nil {
+		t.Error("error")
+		return
+	}
+}
@@ -0,0 +1,163 @@
+package fsck
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//CmdLimit is the resource limit of command.
+type CmdLimit struct {
+	Timeout time.Duration //the wall-clock timeout, the command will be killed after timeout.
+	CPU     int           //the cpu time limit in seconds.
+	Mem     int           //the virtual memory limit in MB.
+	NoFile  int           //the max open files limit.
+}
+
+//Cap will cap the limit by max, the max limit is used when limit is not set or greater than max.
+func (c *CmdLimit) Cap(max *CmdLimit) {
+	if max == nil {
+		return
+	}
+	capLimit := func(val, max int64) int64 {
+		if max > 0 && (val < 1 || val > max) {
+			return max
+		}
+		return val
+	}
+	c.Timeout = time.Duration(capLimit(int64(c.Timeout), int64(max.Timeout)))
+	c.CPU = int(capLimit(int64(c.CPU), int64(max.CPU)))
+	c.Mem = int(capLimit(int64(c.Mem), int64(max.Mem)))
+	c.NoFile = int(capLimit(int64(c.NoFile), int64(max.NoFile)))
+}
+
+//Ulimit return the ulimit command prefix for bash, it return empty when there is no rlimit.
+func (c *CmdLimit) Ulimit() string {
+	args := []string{}
+	if c.CPU > 0 {
+		args = append(args, fmt.Sprintf("-t %v", c.CPU))
+	}
+	if c.Mem > 0 {
+		args = append(args, fmt.Sprintf("-v %v", c.Mem*1024))
+	}
+	if c.NoFile > 0 {
+		args = append(args, fmt.Sprintf("-n %v", c.NoFile))
+	}
+	if len(args) < 1 {
+		return ""
+	}
+	return "ulimit " + strings.Join(args, " ") + ";"
+}
+
+//CmdOption is the option to run command, it is configured by the dial uri query.
+//
+//	dir=/tmp        the working directory
+//	env=K=V         the extra environment, it can be set multi times
+//	user=xx         the user to run command, only supported when slaver is root
+//	uid=0&gid=0     the uid/gid to run command, only supported when slaver is root
+//	timeout=10      the wall-clock timeout in seconds
+//	cpu=10          the cpu time limit in seconds
+//	mem=100         the virtual memory limit in MB
+//	nofile=100      the max open files limit
+type CmdOption struct {
+	CmdLimit
+	Dir  string
+	Env  []string
+	User string
+	UID  int
+	GID  int
+}
+
+//ParseCmdOption will parse the command option from uri query and cap the limit by max.
+func ParseCmdOption(query url.Values, max *CmdLimit) (option *CmdOption, err error) {
+	option = &CmdOption{UID: -1, GID: -1}
+	var timeout int
+	err = util.ValidAttrF(`dir,O|S,L:0;user,O|S,L:0;uid,O|I,R:-1;gid,O|I,R:-1;timeout,O|I,R:0;cpu,O|I,R:0;mem,O|I,R:0;nofile,O|I,R:0`,
+		query.Get, true, &option.Dir, &option.User, &option.UID, &option.GID, &timeout,
+		&option.CPU, &option.Mem, &option.NoFile)
+	if err != nil {
+		return
+	}
+	option.Timeout = time.Duration(timeout) * time.Second
+	for _, env := range query["env"] {
+		if !strings.Contains(env, "=") {
+			err = fmt.Errorf("invalid env(%v), it must be K=V", env)
+			return
+		}
+		option.Env = append(option.Env, env)
+	}
+	if len(option.User) > 0 {
+		var target *user.User
+		target, err = user.Lookup(option.User)
+		if err != nil {
+			return
+		}
+		option.UID, _ = strconv.Atoi(target.Uid)
+		if option.GID < 0 {
+			option.GID, _ = strconv.Atoi(target.Gid)
+		}
+		option.Env = append(option.Env, "HOME="+target.HomeDir, "USER="+target.Username, "LOGNAME="+target.Username)
+	}
+	if option.GID >= 0 && option.UID < 0 {
+		option.UID = os.Getuid()
+	}
+	if option.UID >= 0 && option.GID < 0 {
+		option.GID = os.Getgid()
+	}
+	option.Cap(max)
+	return
+}
+
+//Apply will apply the working directory, environment and user to command.
+func (o *CmdOption) Apply(cmd *exec.Cmd) (err error) {
+	if len(o.Dir) > 0 {
+		cmd.Dir = o.Dir
+	}
+	if len(o.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, o.Env...)
+	}
+	if o.UID >= 0 && (o.UID != os.Getuid() || o.GID != os.Getgid()) {
+		if os.Getuid() != 0 {
+			err = fmt.Errorf("run command as uid(%v)/gid(%v) is only supported when slaver is root", o.UID, o.GID)
+			return
+		}
+		err = setCmdCred(cmd, uint32(o.UID), uint32(o.GID))
+	}
+	return
+}
+
+//Shell will return the shell command with ulimit prefix.
+func (o *CmdOption) Shell(runnable string) (string, error) {
+	ulimit := o.Ulimit()
+	if len(ulimit) < 1 {
+		return runnable, nil
+	}
+	if runtime.GOOS == "windows" {
+		return "", fmt.Errorf("rlimit is not supported on windows")
+	}
+	return ulimit + runnable, nil
+}
+
+//Watch will kill the process group after timeout, it return nil when timeout is not set.
+//the command must be started in new process group, and the timer should be stopped after command exited.
+func (o *CmdOption) Watch(cid uint16, cmd *exec.Cmd) *time.Timer {
+	if o.Timeout < 1 {
+		return nil
+	}
+	return time.AfterFunc(o.Timeout, func() {
+		log.D("CmdDialer the cmd(%v) is timeout(%v), will kill it", cid, o.Timeout)
+		killCmdGroup(cmd)
+	})
+}
@@ -0,0 +1,97 @@
+package fsck
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCmdOption(t *testing.T) {
+	dailer := NewCmdDialer()
+	raw, err := dailer.Dial(10, "tcp://cmd?exec=pwd%3Becho%20$XX%3Bulimit%20-n&dir=/tmp&env=XX=abc&nofile=100")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := ioutil.ReadAll(raw)
+	if string(data) != "/tmp\nabc\n100\n" {
+		t.Errorf("error:%v", string(data))
+		return
+	}
+	//
+	//timeout
+	dailer.Limit.Timeout = 100 * time.Millisecond
+	raw, err = dailer.Dial(10, "tcp://cmd?exec=sleep%2010&timeout=100")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	begin := time.Now()
+	ioutil.ReadAll(raw)
+	exit := raw.(Exiter).ExitStatus()
+	if time.Since(begin) > 2*time.Second || exit == nil || exit.Signal != "KILL" {
+		t.Errorf("error:%v", exit)
+		return
+	}
+	//timeout with child process
+	raw, err = dailer.Dial(10, "tcp://cmd?exec=sleep%2010%20%26%20sleep%2010&timeout=100")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	begin = time.Now()
+	ioutil.ReadAll(raw)
+	if time.Since(begin) > 2*time.Second {
+		t.Errorf("error:%v", time.Since(begin))
+		return
+	}
+	//
+	//error
+	_, err = dailer.Dial(10, "tcp://cmd?exec=ls&env=xx")
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	_, err = dailer.Dial(10, "tcp://cmd?exec=ls&timeout=xx")
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	if os.Getuid() != 0 {
+		_, err = dailer.Dial(10, "tcp://cmd?exec=ls&uid=0")
+		if err == nil {
+			t.Error("error")
+			return
+		}
+	}
+}
+
+func TestCmdLimit(t *testing.T) {
+	query, _ := url.ParseQuery("cpu=100&mem=10&nofile=10")
+	option, err := ParseCmdOption(query, &CmdLimit{Timeout: time.Second, CPU: 10, Mem: 100})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if option.Timeout != time.Second || option.CPU != 10 || option.Mem != 10 || option.NoFile != 10 {
+		t.Errorf("error:%v", option)
+		return
+	}
+	shell, _ := option.Shell("ls")
+	if shell != "ulimit -t 10 -v 10240 -n 10;ls" {
+		t.Errorf("error:%v", shell)
+		return
+	}
+	if option.UID != -1 || option.GID != -1 {
+		t.Error("error")
+		return
+	}
+	query, _ = url.ParseQuery("")
+	option, _ = ParseCmdOption(query, nil)
+	if shell, _ = option.Shell("ls"); shell != "ls" || option.Watch(0, nil) != nil {
+		t.Error("error")
+		return
+	}
+}
@@ -2,6 +2,7 @@ package fsck
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -15,12 +16,12 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/Centny/gwf/log"
 	"github.com/Centny/gwf/util"
 	"golang.org/x/net/webdav"
-	"golang.org/x/text/encoding/simplifiedchinese"
-	"golang.org/x/text/transform"
 )
 
 type CombinedReadWriterCloser struct {
@@ -46,13 +47,19 @@ type Dialer interface {
 	Dial(cid uint16, uri string) (r io.ReadWriteCloser, err error)
 }
 
+//TCPDialer is the dialer to dial tcp/udp/http/https uri, it is configured by the uri query.
+//
+//	timeout=5          the dial timeout in seconds, default is TCPDialer.Timeout
+//	bind=10.0.0.1      the source ip to bind
+//	resolver=8.8.8.8   the dns server to resolve host, default port is 53
+//	family=4|6         the ip family
 type TCPDialer struct {
-	portMatcher *regexp.Regexp
+	Timeout time.Duration
 }
 
 func NewTCPDialer() *TCPDialer {
 	return &TCPDialer{
-		portMatcher: regexp.MustCompile("^.*:[0-9]+$"),
+		Timeout: 30 * time.Second,
 	}
 }
 
@@ -66,22 +73,63 @@ func (t *TCPDialer) Matched(uri string) bool {
 
 func (t *TCPDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err error) {
 	remote, err := url.Parse(uri)
-	if err == nil {
-		network := remote.Scheme
-		host := remote.Host
-		switch network {
-		case "http":
-			network = "tcp"
-			if !t.portMatcher.MatchString(host) {
-				host += ":80"
-			}
-		case "https":
-			network = "tcp"
-			if !t.portMatcher.MatchString(host) {
-				host += ":443"
-			}
+	if err != nil {
+		err = NewDialError(uri, err)
+		return
+	}
+	var timeout int
+	var bind, resolver, family string
+	err = util.ValidAttrF(`timeout,O|I,R:0;bind,O|S,L:0;resolver,O|S,L:0;family,O|S,O:4~6`,
+		remote.Query().Get, true, &timeout, &bind, &resolver, &family)
+	if err != nil {
+		err = NewDialError(uri, err)
+		return
+	}
+	network := remote.Scheme
+	host := remote.Host
+	switch network {
+	case "http":
+		network = "tcp"
+		if len(remote.Port()) < 1 {
+			host = net.JoinHostPort(remote.Hostname(), "80")
+		}
+	case "https":
+		network = "tcp"
+		if len(remote.Port()) < 1 {
+			host = net.JoinHostPort(remote.Hostname(), "443")
+		}
+	}
+	network += family
+	dialer := &net.Dialer{Timeout: t.Timeout}
+	if timeout > 0 {
+		dialer.Timeout = time.Duration(timeout) * time.Second
+	}
+	if len(bind) > 0 {
+		ip := net.ParseIP(bind)
+		if ip == nil {
+			err = NewDialError(uri, fmt.Errorf("invalid bind ip(%v)", bind))
+			return
+		}
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	if len(resolver) > 0 {
+		if _, _, serr := net.SplitHostPort(resolver); serr != nil {
+			resolver = net.JoinHostPort(strings.Trim(resolver, "[]"), "53")
+		}
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: dialer.Timeout}).DialContext(ctx, network, resolver)
+			},
 		}
-		raw, err = net.Dial(network, host)
+	}
+	raw, err = dialer.Dial(network, host)
+	if err != nil {
+		err = NewDialError(uri, err)
 	}
 	return
 }
@@ -90,12 +138,93 @@ func (t *TCPDialer) String() string {
 	return "TCPDialer"
 }
 
+//the category of dial error.
+const (
+	DialErrRefused     = "refused"
+	DialErrTimeout     = "timeout"
+	DialErrDNS         = "dns"
+	DialErrUnreachable = "unreachable"
+	DialErrInvalid     = "invalid"
+	DialErrOther       = "other"
+)
+
+//DialError is the dial error with category, it is reported to master/client by error message,
+//the category can be parsed by DialErrorCategory.
+type DialError struct {
+	URI      string
+	Category string
+	Err      error
+}
+
+//NewDialError will create the dial error by detecting the category of err.
+func NewDialError(uri string, err error) *DialError {
+	derr := &DialError{URI: uri, Err: err, Category: DialErrOther}
+	cause := err
+	if opErr, ok := cause.(*net.OpError); ok {
+		cause = opErr.Err
+	}
+	if sysErr, ok := cause.(*os.SyscallError); ok {
+		cause = sysErr.Err
+	}
+	switch cause.(type) {
+	case *net.DNSError:
+		derr.Category = DialErrDNS
+		return derr
+	case *url.Error, *net.AddrError, *net.ParseError, net.UnknownNetworkError:
+		derr.Category = DialErrInvalid
+		return derr
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		derr.Category = DialErrTimeout
+		return derr
+	}
+	switch cause {
+	case syscall.ECONNREFUSED:
+		derr.Category = DialErrRefused
+	case syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		derr.Category = DialErrUnreachable
+	default:
+		msg := err.Error()
+		if strings.Contains(msg, "refused") {
+			derr.Category = DialErrRefused
+		} else if strings.Contains(msg, "unreachable") {
+			derr.Category = DialErrUnreachable
+		} else if strings.Contains(msg, "invalid") {
+			derr.Category = DialErrInvalid
+		}
+	}
+	return derr
+}
+
+func (d *DialError) Error() string {
+	return fmt.Sprintf("dial error(%v) to %v: %v", d.Category, d.URI, d.Err)
+}
+
+var dialErrorMatcher = regexp.MustCompile(`dial error\(([a-z]+)\) to `)
+
+//DialErrorCategory return the category of dial error, it is parsed from error message when err is sent by remote,
+//it return empty when err is not dial error.
+func DialErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	if derr, ok := err.(*DialError); ok {
+		return derr.Category
+	}
+	match := dialErrorMatcher.FindStringSubmatch(err.Error())
+	if len(match) > 1 {
+		return match[1]
+	}
+	return ""
+}
+
 var CMD_CTRL_C = []byte{255, 244, 255, 253, 6}
 
 type CmdStdinWriter struct {
 	io.Writer
 	Replace  []byte
 	CloseTag []byte
+	resize   WinSizeParser
 }
 
 func (c *CmdStdinWriter) Write(p []byte) (n int, err error) {
@@ -109,6 +238,7 @@ func (c *CmdStdinWriter) Write(p []byte) (n int, err error) {
 	if len(c.Replace) > 0 {
 		p = bytes.Replace(p, c.Replace, []byte{}, -1)
 	}
+	p, _ = c.resize.Parse(p)
 	n, err = c.Writer.Write(p)
 	return
 }
@@ -118,13 +248,17 @@ type CmdDialer struct {
 	CloseTag []byte
 	BASH     string
 	PS1      string
+	Limit    CmdLimit //the max limit of command, it is used when dial uri is not set or greater than it.
+	//the max bytes of stdout/stderr kept in result mode, the exceeded output is truncated.
+	ResultMax int
 }
 
 func NewCmdDialer() *CmdDialer {
 	return &CmdDialer{
-		Replace:  []byte("\r"),
-		CloseTag: CMD_CTRL_C,
-		BASH:     "bash",
+		Replace:   []byte("\r"),
+		CloseTag:  CMD_CTRL_C,
+		BASH:      "bash",
+		ResultMax: 8 * 1024 * 1024,
 	}
 }
 
@@ -142,15 +276,46 @@ func (c *CmdDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err er
 		return
 	}
 	runnable := remote.Query().Get("exec")
+	option, err := ParseCmdOption(remote.Query(), &c.Limit)
+	if err != nil {
+		return
+	}
 	log.D("CmdDialer dial to cmd:%v", runnable)
 	if runnable == "bash" {
 		cmd := NewCmd(c.BASH, c.PS1, c.BASH)
 		cmd.Cols, cmd.Rows = 80, 60
 		util.ValidAttrF(`cols,O|I,R:0;rows,O|I,R:0;`, remote.Query().Get, true, &cmd.Cols, &cmd.Rows)
+		var shell string
+		shell, err = option.Shell("exec " + c.BASH)
+		if err != nil {
+			return
+		}
+		if shell != "exec "+c.BASH {
+			cmd.Raw.Args = []string{c.BASH, "-c", shell}
+		}
+		err = option.Apply(cmd.Raw)
+		if err != nil {
+			return
+		}
 		err = cmd.Start()
+		if err == nil {
+			cmd.Watch(option.Watch(cid, cmd.Raw))
+		}
 		raw = cmd
 		return
 	}
+	runnable, err = option.Shell(runnable)
+	if err != nil {
+		return
+	}
+	charset := remote.Query().Get("LC")
+	if charset == "auto" {
+		charset = DetectCharset(os.Getenv)
+	}
+	enc, err := LookupCharset(charset)
+	if err != nil {
+		return
+	}
 	var cmd *exec.Cmd
 	switch runtime.GOOS {
 	case "windows":
@@ -158,6 +323,19 @@ func (c *CmdDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err er
 	default:
 		cmd = exec.Command(c.BASH, "-c", runnable)
 	}
+	err = option.Apply(cmd)
+	if err != nil {
+		return
+	}
+	setCmdGroup(cmd)
+	if remote.Query().Get("result") == "json" {
+		var stdin int
+		err = util.ValidAttrF(`stdin,O|I,R:-1`, remote.Query().Get, true, &stdin)
+		if err == nil {
+			raw, err = c.dialResult(cid, cmd, option, stdin, enc)
+		}
+		return
+	}
 	retReader, stdWriter, err := os.Pipe()
 	if err != nil {
 		return
@@ -181,27 +359,53 @@ func (c *CmdDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err er
 			return nil
 		},
 	}
-	//
-	switch remote.Query().Get("LC") {
-	case "zh_CN.GBK":
-		combined.Reader = transform.NewReader(combined.Reader, simplifiedchinese.GBK.NewDecoder())
-		cmdWriter.Writer = transform.NewWriter(cmdWriter.Writer, simplifiedchinese.GBK.NewEncoder())
-	case "zh_CN.GB18030":
-		combined.Reader = transform.NewReader(combined.Reader, simplifiedchinese.GB18030.NewDecoder())
-		cmdWriter.Writer = transform.NewWriter(cmdWriter.Writer, simplifiedchinese.GB18030.NewEncoder())
-	default:
+	conn := &CmdConn{
+		CombinedReadWriterCloser: combined,
+		Cmd:                      cmd,
 	}
-	raw = combined
+	//
+	combined.Reader = NewCharsetReader(combined.Reader, enc)
+	cmdWriter.Writer = NewCharsetWriter(cmdWriter.Writer, enc)
+	raw = conn
 	err = cmd.Start()
 	if err == nil {
+		timer := option.Watch(cid, cmd)
 		go func() {
-			cmd.Wait()
+			err := cmd.Wait()
+			if timer != nil {
+				timer.Stop()
+			}
+			exit := NewExitStatus(cmd.ProcessState, err)
+			conn.lck.Lock()
+			conn.exit = exit
+			conn.lck.Unlock()
+			log.D("CmdDialer the cmd(%v) is done with %v", cid, exit)
 			combined.Close()
 		}()
 	}
 	return
 }
 
+//CmdConn is the raw connection of command, it can get the exit status after closed and send signal to command.
+type CmdConn struct {
+	*CombinedReadWriterCloser
+	Cmd  *exec.Cmd
+	exit *ExitStatus
+	lck  sync.RWMutex
+}
+
+//ExitStatus return the exit status of command, it return nil when command is not exited.
+func (c *CmdConn) ExitStatus() *ExitStatus {
+	c.lck.RLock()
+	defer c.lck.RUnlock()
+	return c.exit
+}
+
+//Signal will send signal to command.
+func (c *CmdConn) Signal(sig string) error {
+	return SignalCmd(c.Cmd, sig)
+}
+
 func (t *CmdDialer) String() string {
 	return "CmdDialer"
 }
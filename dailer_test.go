@@ -3,8 +3,10 @@ package fsck
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -145,3 +147,89 @@ func TestWebDialer(t *testing.T) {
 		return
 	}
 }
+
+func TestTCPDialer(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conn.Write([]byte(conn.RemoteAddr().String()))
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	dialer := NewTCPDialer()
+	raw, err := dialer.Dial(1, "tcp://127.0.0.1:"+port+"?timeout=3&bind=127.0.0.1&family=4")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := ioutil.ReadAll(raw)
+	if !strings.HasPrefix(string(data), "127.0.0.1:") {
+		t.Error("error")
+		return
+	}
+	//
+	//ipv6
+	listener6, err := net.Listen("tcp", "[::1]:0")
+	if err == nil {
+		go func() {
+			conn, _ := listener6.Accept()
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}()
+		_, port6, _ := net.SplitHostPort(listener6.Addr().String())
+		raw, err = dialer.Dial(1, "tcp://[::1]:"+port6+"?family=6")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ = ioutil.ReadAll(raw)
+		if string(data) != "ok" {
+			t.Error("error")
+			return
+		}
+		listener6.Close()
+	}
+	//
+	//error category
+	listener.Close()
+	_, err = dialer.Dial(1, "tcp://127.0.0.1:"+port)
+	if DialErrorCategory(err) != DialErrRefused {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.Dial(1, "http://not.exist.invalid?resolver=127.0.0.1:"+port+"&timeout=1")
+	if DialErrorCategory(err) != DialErrDNS {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.Dial(1, "tcp://127.0.0.1?bind=xx")
+	if DialErrorCategory(err) != DialErrInvalid {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.Dial(1, "tcp://127.0.0.1?family=5")
+	if DialErrorCategory(err) != DialErrInvalid {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.Dial(1, "xx://127.0.0.1:80")
+	if DialErrorCategory(err) != DialErrInvalid {
+		t.Error(err)
+		return
+	}
+	//
+	//remote message
+	if DialErrorCategory(fmt.Errorf("%v", NewDialError("x", &net.DNSError{}))) != DialErrDNS {
+		t.Error("error")
+		return
+	}
+	if DialErrorCategory(nil) != "" || DialErrorCategory(fmt.Errorf("xx")) != "" {
+		t.Error("error")
+		return
+	}
+}
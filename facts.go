@@ -0,0 +1,61 @@
+package fsck
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+var slaverStart = util.Now()
+
+//HostFacts is the host system facts gathered by slaver locally.
+type HostFacts struct {
+	Hostname  string    `json:"hostname"`
+	OS        string    `json:"os"` //the os release name, like Ubuntu 20.04 LTS
+	Kernel    string    `json:"kernel"`
+	Arch      string    `json:"arch"`
+	CPU       int       `json:"cpu"`
+	Uptime    int64     `json:"uptime"` //the host uptime in seconds.
+	Load      []float64 `json:"load"`   //the 1/5/15 minutes load average.
+	MemTotal  int64     `json:"mem_total"`
+	MemAvail  int64     `json:"mem_avail"`
+	DiskTotal int64     `json:"disk_total"` //the disk size of root filesystem.
+	DiskFree  int64     `json:"disk_free"`
+	Version   string    `json:"version"` //the slaver binary version.
+	Start     int64     `json:"start"`   //the slaver start time in unix milliseconds.
+	Time      int64     `json:"time"`    //the time of facts gathered in unix milliseconds.
+}
+
+//NewHostFacts will gather the host facts, the version is the slaver binary version.
+func NewHostFacts(version string) (facts *HostFacts) {
+	facts = &HostFacts{
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		CPU:     runtime.NumCPU(),
+		Version: version,
+		Start:   slaverStart,
+		Time:    util.Now(),
+	}
+	facts.Hostname, _ = os.Hostname()
+	err := readHostFacts(facts, "/")
+	if err != nil {
+		log.D("NewHostFacts read host facts fail with %v", err)
+	}
+	return
+}
+
+//ParseHostFacts will parse the host facts from util.Map, it return nil when m is nil.
+func ParseHostFacts(m util.Map) (facts *HostFacts, err error) {
+	if m == nil {
+		return
+	}
+	data, err := json.Marshal(m)
+	if err == nil {
+		facts = &HostFacts{}
+		err = json.Unmarshal(data, facts)
+	}
+	return
+}
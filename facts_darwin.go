@@ -0,0 +1,21 @@
+package fsck
+
+import (
+	"syscall"
+)
+
+//readHostFacts will read the host facts by sysctl and statfs on root.
+func readHostFacts(facts *HostFacts, root string) (err error) {
+	facts.Kernel, err = syscall.Sysctl("kern.osrelease")
+	if version, serr := syscall.Sysctl("kern.osproductversion"); serr == nil {
+		facts.OS = "macOS " + version
+	}
+	stat := &syscall.Statfs_t{}
+	if serr := syscall.Statfs(root, stat); serr == nil {
+		facts.DiskTotal = int64(stat.Blocks) * int64(stat.Bsize)
+		facts.DiskFree = int64(stat.Bavail) * int64(stat.Bsize)
+	} else {
+		err = serr
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package fsck
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//readHostFacts will read the host facts from proc/etc on root, the missing facts is skipped.
+func readHostFacts(facts *HostFacts, root string) (err error) {
+	if data, rerr := ioutil.ReadFile(filepath.Join(root, "proc/sys/kernel/osrelease")); rerr == nil {
+		facts.Kernel = strings.TrimSpace(string(data))
+	} else {
+		err = rerr
+	}
+	if data, rerr := ioutil.ReadFile(filepath.Join(root, "etc/os-release")); rerr == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "PRETTY_NAME=") {
+				facts.OS = strings.Trim(strings.TrimPrefix(line, "PRETTY_NAME="), "\"'")
+				break
+			}
+		}
+	}
+	if data, rerr := ioutil.ReadFile(filepath.Join(root, "proc/uptime")); rerr == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			uptime, _ := strconv.ParseFloat(fields[0], 64)
+			facts.Uptime = int64(uptime)
+		}
+	} else {
+		err = rerr
+	}
+	if data, rerr := ioutil.ReadFile(filepath.Join(root, "proc/loadavg")); rerr == nil {
+		fields := strings.Fields(string(data))
+		for i := 0; i < 3 && i < len(fields); i++ {
+			load, _ := strconv.ParseFloat(fields[i], 64)
+			facts.Load = append(facts.Load, load)
+		}
+	} else {
+		err = rerr
+	}
+	if data, rerr := ioutil.ReadFile(filepath.Join(root, "proc/meminfo")); rerr == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			val, _ := strconv.ParseInt(fields[1], 10, 64)
+			switch fields[0] {
+			case "MemTotal:":
+				facts.MemTotal = val * 1024
+			case "MemAvailable:":
+				facts.MemAvail = val * 1024
+			}
+		}
+	} else {
+		err = rerr
+	}
+	stat := &syscall.Statfs_t{}
+	if serr := syscall.Statfs(root, stat); serr == nil {
+		facts.DiskTotal = int64(stat.Blocks) * int64(stat.Bsize)
+		facts.DiskFree = int64(stat.Bavail) * int64(stat.Bsize)
+	} else {
+		err = serr
+	}
+	return
+}
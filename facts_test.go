@@ -0,0 +1,66 @@
+package fsck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestHostFacts(t *testing.T) {
+	facts := NewHostFacts("1.0.0")
+	if facts.CPU < 1 || facts.Version != "1.0.0" || facts.Start < 1 || facts.Time < facts.Start || len(facts.Hostname) < 1 {
+		t.Errorf("%#v", facts)
+		return
+	}
+	parsed, err := ParseHostFacts(util.Map{"hostname": "x", "cpu": 2, "load": []interface{}{0.1, 0.2, 0.3}})
+	if err != nil || parsed.Hostname != "x" || parsed.CPU != 2 || len(parsed.Load) != 3 {
+		t.Errorf("%v,%v", parsed, err)
+		return
+	}
+	parsed, err = ParseHostFacts(nil)
+	if err != nil || parsed != nil {
+		t.Errorf("%v,%v", parsed, err)
+		return
+	}
+	_, err = ParseHostFacts(util.Map{"cpu": "xx"})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	fmt.Printf("%v\n", util.S2Json(facts))
+}
+
+func TestReadHostFacts(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	os.RemoveAll("/tmp/fsck_facts")
+	defer os.RemoveAll("/tmp/fsck_facts")
+	os.MkdirAll("/tmp/fsck_facts/proc/sys/kernel", os.ModePerm)
+	os.MkdirAll("/tmp/fsck_facts/etc", os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_facts/proc/sys/kernel/osrelease", []byte("5.4.0-xx\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_facts/etc/os-release", []byte("NAME=\"Ubuntu\"\nPRETTY_NAME=\"Ubuntu 20.04 LTS\"\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_facts/proc/uptime", []byte("3600.50 2000.00\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_facts/proc/loadavg", []byte("0.50 0.25 0.10 1/100 1000\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_facts/proc/meminfo", []byte("MemTotal:     2048 kB\nMemFree:     100 kB\nMemAvailable:     1024 kB\n"), os.ModePerm)
+	facts := &HostFacts{}
+	err := readHostFacts(facts, "/tmp/fsck_facts")
+	if err != nil || facts.Kernel != "5.4.0-xx" || facts.OS != "Ubuntu 20.04 LTS" || facts.Uptime != 3600 ||
+		len(facts.Load) != 3 || facts.Load[1] != 0.25 || facts.MemTotal != 2048*1024 || facts.MemAvail != 1024*1024 ||
+		facts.DiskTotal < 1 {
+		t.Errorf("%#v,%v", facts, err)
+		return
+	}
+	//error
+	os.Remove("/tmp/fsck_facts/proc/loadavg")
+	facts = &HostFacts{}
+	err = readHostFacts(facts, "/tmp/fsck_facts")
+	if err == nil || facts.Kernel != "5.4.0-xx" || len(facts.Load) != 0 {
+		t.Errorf("%#v,%v", facts, err)
+		return
+	}
+}
@@ -0,0 +1,6 @@
+package fsck
+
+//readHostFacts will read the host facts, only the common facts is supported on windows.
+func readHostFacts(facts *HostFacts, root string) (err error) {
+	return
+}
@@ -1,7 +1,9 @@
 package fsck
 
 import (
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -11,7 +13,9 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
+	"golang.org/x/net/http2"
 	"golang.org/x/net/websocket"
 
 	"github.com/Centny/gwf/log"
@@ -20,10 +24,17 @@ import (
 )
 
 type Mapping struct {
-	Name    string   `json:"name"`
-	Channel string   `json:"channel"`
-	Local   *url.URL `json:"local"`
-	Remote  *url.URL `json:"remote"`
+	Name    string         `json:"name"`
+	Channel string         `json:"channel"`
+	Local   *url.URL       `json:"local"`
+	Remote  *url.URL       `json:"remote"`
+	Health  *MappingHealth `json:"health,omitempty"`
+}
+
+//snapshot will return the copy of mapping, it must be called with forward lock because Health is updated by health check.
+func (m *Mapping) snapshot() *Mapping {
+	copied := *m
+	return &copied
 }
 
 func NewMapping(name, uri string) (mapping *Mapping, err error) {
@@ -87,10 +98,13 @@ type Forward struct {
 	stop       map[string]chan int
 	webMapping map[string]*Mapping
 	wsMapping  map[string]*Mapping
+	transports map[*Mapping]*http.Transport
+	accessLogs map[*Mapping]*AccessLog
 	lck        sync.RWMutex
 	WebPrefix  string
 	WebSuffix  string
 	WebAuth    string
+	DialToken  string //the token to dial file session by ProcDialH, the dial is disabled when it is empty.
 	Dialer     ForwardDialerF
 }
 
@@ -102,6 +116,8 @@ func NewForward(dialer ForwardDialerF) *Forward {
 		stop:       map[string]chan int{},
 		webMapping: map[string]*Mapping{},
 		wsMapping:  map[string]*Mapping{},
+		transports: map[*Mapping]*http.Transport{},
+		accessLogs: map[*Mapping]*AccessLog{},
 		lck:        sync.RWMutex{},
 		Dialer:     dialer,
 	}
@@ -116,8 +132,17 @@ func (f *Forward) AddUriForward(name, uri string) (mapping *Mapping, err error)
 }
 
 func (f *Forward) AddForward(m *Mapping) (err error) {
+	check, err := NewHealthCheck(m)
+	if err != nil {
+		return
+	}
 	f.lck.Lock()
-	defer f.lck.Unlock()
+	defer func() {
+		f.lck.Unlock()
+		if err == nil && check != nil {
+			go f.runHealth(m, check)
+		}
+	}()
 	if _, ok := f.ms[m.Name]; ok {
 		err = fmt.Errorf("the forward is exsits by name(%v)", m.Name)
 		return
@@ -140,7 +165,7 @@ func (f *Forward) AddForward(m *Mapping) (err error) {
 		f.ms[m.Name] = m
 		f.ls[m.Local.Host] = l
 		f.stop[m.Name] = make(chan int)
-		go f.accept(m, l, m.Channel, m.Remote.String())
+		go f.accept(m, l, check, m.Channel, m.Remote.String())
 		log.D("Forward add tcp forward by %v success", m)
 	case "web":
 		if _, ok := f.webMapping[m.Local.Host]; ok {
@@ -190,6 +215,8 @@ func (f *Forward) RemoveForward(local string) (err error) {
 		if forward != nil {
 			delete(f.webMapping, rurl.Host)
 			delete(f.ms, forward.Name)
+			f.closeTransport(forward)
+			f.closeAccessLog(forward)
 			log.D("Forward removing web forward by %v success", local)
 		} else {
 			err = fmt.Errorf("web forward is not exist by %v", local)
@@ -202,6 +229,7 @@ func (f *Forward) RemoveForward(local string) (err error) {
 		if forward != nil {
 			delete(f.wsMapping, rurl.Host)
 			delete(f.ms, forward.Name)
+			f.closeAccessLog(forward)
 			log.D("Forward removing ws forward by %v success", local)
 		} else {
 			err = fmt.Errorf("ws forward is not exist by %v", local)
@@ -218,18 +246,18 @@ func (f *Forward) AllForwards() (mapping map[string][]*Mapping) {
 	defer f.lck.RUnlock()
 	mapping = map[string][]*Mapping{}
 	for _, l := range f.ls {
-		mapping[l.Channel] = append(mapping[l.Channel], l.Mapping)
+		mapping[l.Channel] = append(mapping[l.Channel], l.Mapping.snapshot())
 	}
 	for _, m := range f.webMapping {
-		mapping[m.Channel] = append(mapping[m.Channel], m)
+		mapping[m.Channel] = append(mapping[m.Channel], m.snapshot())
 	}
 	for _, m := range f.wsMapping {
-		mapping[m.Channel] = append(mapping[m.Channel], m)
+		mapping[m.Channel] = append(mapping[m.Channel], m.snapshot())
 	}
 	return
 }
 
-func (f *Forward) accept(m *Mapping, listen net.Listener, channel, uri string) {
+func (f *Forward) accept(m *Mapping, listen net.Listener, check *HealthCheck, channel, uri string) {
 	var limit int
 	err := m.LocalValidF(`limit,O|I,R:-1`, &limit)
 	if err != nil {
@@ -242,6 +270,15 @@ func (f *Forward) accept(m *Mapping, listen net.Listener, channel, uri string) {
 			log.D("Forwad(%v) accept fail with %v", m.Name, err)
 			break
 		}
+		if f.refused(m, check) {
+			log.D("Forward(%v) refuse connection from %v by health check is down", m.Name, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		conn, err = NewTapConn(m, conn)
+		if err != nil {
+			log.W("Forward(%v) create tap on %v fail with %v", m.Name, conn.RemoteAddr(), err)
+		}
 		session, err := f.Dialer(channel, uri, conn)
 		if err != nil {
 			log.E("Forward(%v) dial new session by channel(%v),uri(%v) fail with %v", m.Name, channel, uri, err)
@@ -285,6 +322,9 @@ func (f *Forward) copy(m *Mapping, conn net.Conn, session Session) {
 	f.lck.Lock()
 	delete(f.cs, fmt.Sprintf("%v-%v", m.Name, session.ID()))
 	f.lck.Unlock()
+	if exiter, ok := session.(Exiter); ok && exiter.ExitStatus() != nil {
+		log.D("Forwad(%v) remote command on session(%v) is done with %v", m.Name, session.ID(), exiter.ExitStatus())
+	}
 	log.D("Forwad(%v) connect from %v is closed by %v", m.Name, conn.RemoteAddr(), err)
 }
 
@@ -313,11 +353,12 @@ func (f *Forward) Stop(name string, connected bool) (err error) {
 	return
 }
 
+//List will return the snapshot of all mapping, the Health is not updated on the returned mapping.
 func (f *Forward) List() (ms []*Mapping) {
 	f.lck.RLock()
 	defer f.lck.RUnlock()
 	for _, m := range f.ms {
-		ms = append(ms, m)
+		ms = append(ms, m.snapshot())
 	}
 	return
 }
@@ -327,12 +368,20 @@ func (f *Forward) Close() error {
 	for _, m := range ms {
 		f.Stop(m.Name, true)
 	}
+	f.lck.Lock()
+	for m := range f.transports {
+		f.closeTransport(m)
+	}
+	for m := range f.accessLogs {
+		f.closeAccessLog(m)
+	}
+	f.lck.Unlock()
 	return nil
 }
 
 func (f *Forward) ProcWebSubsH(hs *routing.HTTPSession) routing.HResult {
 	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(hs.R.URL.Path, f.WebPrefix), "/"), "/")
-	return f.ProcName(parts[0], hs)
+	return f.procName(parts[0], f.WebPrefix+"/"+parts[0], hs)
 }
 
 func (f *Forward) HostForwardF(hs *routing.HTTPSession) routing.HResult {
@@ -346,7 +395,25 @@ func (f *Forward) HostForwardF(hs *routing.HTTPSession) routing.HResult {
 	return routing.HRES_CONTINUE
 }
 
+//AllowedHost return whether the host is web forward host or web server host, it is used to limit CA issuing.
+func (f *Forward) AllowedHost(host string) bool {
+	if len(f.WebSuffix) < 1 || net.ParseIP(host) != nil {
+		return true
+	}
+	if !strings.HasSuffix(host, f.WebSuffix) {
+		return host == strings.Trim(f.WebSuffix, ". ")
+	}
+	name := strings.Trim(strings.TrimSuffix(host, f.WebSuffix), ". ")
+	f.lck.RLock()
+	defer f.lck.RUnlock()
+	return f.webMapping[name] != nil || f.wsMapping[name] != nil
+}
+
 func (f *Forward) ProcName(name string, hs *routing.HTTPSession) routing.HResult {
+	return f.procName(name, "", hs)
+}
+
+func (f *Forward) procName(name, base string, hs *routing.HTTPSession) routing.HResult {
 	connection := hs.R.Header.Get("Connection")
 	log.D("Forward proc web by name(%v),Connection(%v)", name, connection)
 	var mapping *Mapping
@@ -362,12 +429,33 @@ func (f *Forward) ProcName(name string, hs *routing.HTTPSession) routing.HResult
 		log.W("Forward proc web by name(%v),Connection(%v) fail with not found", name, connection)
 		return hs.Printf("alias not exist by name:%v", name)
 	}
+	alog := f.accessLog(mapping)
 	if connection == "Upgrade" {
 		websocket.Handler(func(conn *websocket.Conn) {
-			f.runWebsocket(conn, mapping)
+			begin := time.Now()
+			size := f.runWebsocket(conn, mapping, hs.R.URL.Query().Get("winsize") == "1")
+			if alog != nil {
+				alog.Log(hs.R, http.StatusSwitchingProtocols, size, begin)
+			}
 		}).ServeHTTP(hs.W, hs.R)
 		return routing.HRES_RETURN
 	}
+	if alog != nil {
+		begin, limit := time.Now(), 0
+		var reqBody *LimitBuffer
+		if alog.Capture() {
+			limit = alog.HarBody
+			if hs.R.Body != nil {
+				reqBody = NewLimitBuffer(limit)
+				hs.R.Body = &accessBody{ReadCloser: hs.R.Body, out: reqBody}
+			}
+		}
+		writer := NewAccessWriter(hs.W, limit)
+		hs.W = writer
+		defer func() {
+			alog.Record(hs.R, reqBody, writer, begin)
+		}()
+	}
 	if len(f.WebAuth) > 0 && mapping.Local.Query().Get("auth") != "0" {
 		username, password, ok := hs.R.BasicAuth()
 		if !(ok && f.WebAuth == fmt.Sprintf("%v:%s", username, password)) {
@@ -376,33 +464,224 @@ func (f *Forward) ProcName(name string, hs *routing.HTTPSession) routing.HResult
 			return hs.Printf("%v", "401 Unauthorized")
 		}
 	}
+	rewrite := NewWebRewrite(mapping, base)
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Host = req.Host
 			req.URL.Scheme = mapping.Remote.Scheme
+			if req.TLS != nil {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			}
+			rewrite.Request(req)
 		},
-		Transport: &http.Transport{
-			Dial: func(network, addr string) (raw net.Conn, err error) {
-				return f.procDial(network, mapping.Remote.Host, mapping)
-			},
-			DialTLS: func(network, addr string) (raw net.Conn, err error) {
-				return f.procDialTLS(network, mapping.Remote.Host, mapping)
-			},
+		ModifyResponse: func(res *http.Response) error {
+			return rewrite.Response(res, hs.R)
 		},
+		Transport: f.transport(mapping),
 	}
 	proxy.ServeHTTP(hs.W, hs.R)
 	return routing.HRES_RETURN
 }
 
-func (f *Forward) runWebsocket(conn *websocket.Conn, mapping *Mapping) {
+//runWebsocket will forward the websocket to remote, the winsize is whether the client sends the window size command,
+//it is stripped when the remote is not command session.
+func (f *Forward) runWebsocket(conn *websocket.Conn, mapping *Mapping, winsize bool) (size int64) {
 	raw, err := f.Dialer(mapping.Channel, mapping.Remote.String(), conn)
 	if err != nil {
 		conn.Close()
 		return
 	}
-	io.Copy(raw, conn)
+	var writer io.Writer = raw
+	if winsize && !strings.HasPrefix(mapping.Remote.String(), "tcp://cmd") {
+		writer = &WinSizeStripWriter{Writer: raw}
+	}
+	size, _ = io.Copy(writer, conn)
 	raw.Close()
 	conn.Close()
+	return
+}
+
+//ProcDialH will dial the file session by channel and uri in query after websocket is upgraded, then forward the session by websocket,
+//the token query must be matched DialToken, the cross origin request is rejected and only file:// uri is allowed.
+//the error is sent as FileReply when dial fail.
+func (f *Forward) ProcDialH(hs *routing.HTTPSession) routing.HResult {
+	var channel, uri, token string
+	err := hs.ValidF(`
+		channel,R|S,L:0;
+		uri,R|S,L:0;
+		token,R|S,L:0;
+		`, &channel, &uri, &token)
+	if err != nil {
+		hs.W.WriteHeader(400)
+		return hs.Printf("%v", err)
+	}
+	if len(f.DialToken) < 1 || subtle.ConstantTimeCompare([]byte(f.DialToken), []byte(token)) != 1 {
+		hs.W.WriteHeader(403)
+		return hs.Printf("%v", "403 Forbidden")
+	}
+	if !strings.HasPrefix(uri, "file://") {
+		hs.W.WriteHeader(403)
+		return hs.Printf("%v", "only file:// uri is allowed")
+	}
+	if len(f.WebAuth) > 0 {
+		username, password, ok := hs.R.BasicAuth()
+		if !(ok && f.WebAuth == fmt.Sprintf("%v:%s", username, password)) {
+			hs.W.WriteHeader(401)
+			return hs.Printf("%v", "401 Unauthorized")
+		}
+	}
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) (err error) {
+			origin := req.Header.Get("Origin")
+			if len(origin) < 1 {
+				return
+			}
+			ourl, err := url.Parse(origin)
+			if err == nil && ourl.Host != req.Host {
+				err = fmt.Errorf("cross origin %v is not allowed", origin)
+			}
+			return
+		},
+		Handler: func(conn *websocket.Conn) {
+			reader, writer := io.Pipe()
+			raw, err := f.Dialer(channel, uri, writer)
+			if err != nil {
+				log.W("Forward dial to %v on channel(%v) fail with %v", uri, channel, err)
+				json.NewEncoder(conn).Encode(&FileReply{Error: err.Error()})
+				conn.Close()
+				return
+			}
+			go func() {
+				io.Copy(conn, reader)
+				conn.Close()
+			}()
+			io.Copy(raw, conn)
+			raw.Close()
+			reader.Close()
+		},
+	}
+	server.ServeHTTP(hs.W, hs.R)
+	return routing.HRES_RETURN
+}
+
+//accessLog return the access log of mapping, it return nil when access log is not enabled.
+func (f *Forward) accessLog(mapping *Mapping) (alog *AccessLog) {
+	f.lck.RLock()
+	alog, ok := f.accessLogs[mapping]
+	f.lck.RUnlock()
+	if ok {
+		return
+	}
+	f.lck.Lock()
+	defer f.lck.Unlock()
+	alog, ok = f.accessLogs[mapping]
+	if ok {
+		return
+	}
+	alog, err := NewAccessLog(mapping)
+	if err != nil {
+		log.W("Forward(%v) create access log fail with %v", mapping.Name, err)
+		alog = nil
+	}
+	f.accessLogs[mapping] = alog
+	return
+}
+
+func (f *Forward) closeAccessLog(mapping *Mapping) {
+	alog, ok := f.accessLogs[mapping]
+	if alog != nil {
+		alog.Close()
+	}
+	if ok {
+		delete(f.accessLogs, mapping)
+	}
+}
+
+//HAR return the HAR capture of mapping by name.
+func (f *Forward) HAR(name string) (har *HAR, err error) {
+	f.lck.RLock()
+	defer f.lck.RUnlock()
+	mapping := f.ms[name]
+	if mapping == nil {
+		err = fmt.Errorf("forward is not exist by name(%v)", name)
+		return
+	}
+	alog := f.accessLogs[mapping]
+	if alog == nil || !alog.Capture() {
+		err = fmt.Errorf("HAR capture is not enabled on forward(%v)", name)
+		return
+	}
+	har = alog.HAR()
+	return
+}
+
+//transport return the long-lived transport of mapping, the upstream connection will be reused by keep-alive.
+//
+//	idle=16          the max idle connection to upstream
+//	idle_timeout=90  the idle connection timeout in seconds
+//	timeout=0        the response header timeout in seconds, zero is not timeout
+//	h2=1             try http2 to https upstream, h2=0 will disable it
+func (f *Forward) transport(mapping *Mapping) (transport *http.Transport) {
+	f.lck.RLock()
+	transport = f.transports[mapping]
+	f.lck.RUnlock()
+	if transport != nil {
+		return
+	}
+	f.lck.Lock()
+	defer f.lck.Unlock()
+	transport = f.transports[mapping]
+	if transport != nil {
+		return
+	}
+	var idle, idleTimeout, timeout int
+	var h2 string
+	err := mapping.LocalValidF(`idle,O|I,R:-1;idle_timeout,O|I,R:-1;timeout,O|I,R:-1;h2,O|S,O:0~1`, &idle, &idleTimeout, &timeout, &h2)
+	if err != nil {
+		log.W("Forward(%v) get the transport option fail with %v, will use default", mapping.Name, err)
+	}
+	if idle < 1 {
+		idle = 16
+	}
+	if idleTimeout < 1 {
+		idleTimeout = 90
+	}
+	var nextProtos []string
+	transport = &http.Transport{
+		MaxIdleConns:          idle,
+		MaxIdleConnsPerHost:   idle,
+		IdleConnTimeout:       time.Duration(idleTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(timeout) * time.Second,
+		Dial: func(network, addr string) (raw net.Conn, err error) {
+			return f.procDial(network, mapping.Remote.Host, mapping)
+		},
+		DialTLS: func(network, addr string) (raw net.Conn, err error) {
+			return f.procDialTLS(network, mapping.Remote.Host, mapping, nextProtos...)
+		},
+	}
+	if mapping.Remote.Scheme == "https" && h2 != "0" {
+		err = http2.ConfigureTransport(transport)
+		if err == nil {
+			nextProtos = transport.TLSClientConfig.NextProtos
+		} else {
+			log.W("Forward(%v) configure http2 transport fail with %v", mapping.Name, err)
+		}
+	}
+	if f.ms[mapping.Name] != mapping {
+		//the mapping is removed when request is in flight, not cache it and disable keep-alive to avoid leaking
+		transport.DisableKeepAlives = true
+		return
+	}
+	f.transports[mapping] = transport
+	return
+}
+
+func (f *Forward) closeTransport(mapping *Mapping) {
+	transport := f.transports[mapping]
+	if transport != nil {
+		transport.CloseIdleConnections()
+		delete(f.transports, mapping)
+	}
 }
 
 func (f *Forward) procDial(network, addr string, mapping *Mapping) (raw net.Conn, err error) {
@@ -410,12 +689,12 @@ func (f *Forward) procDial(network, addr string, mapping *Mapping) (raw net.Conn
 	return
 }
 
-func (f *Forward) procDialTLS(network, addr string, mapping *Mapping) (raw net.Conn, err error) {
+func (f *Forward) procDialTLS(network, addr string, mapping *Mapping, nextProtos ...string) (raw net.Conn, err error) {
 	rawCon, err := f.Dialer(mapping.Channel, mapping.Remote.String(), nil)
 	if err != nil {
 		return
 	}
-	tlsConn := tls.Client(rawCon, &tls.Config{InsecureSkipVerify: true})
+	tlsConn := tls.Client(rawCon, &tls.Config{InsecureSkipVerify: true, NextProtos: nextProtos})
 	err = tlsConn.Handshake()
 	if err == nil {
 		raw = tlsConn
@@ -2,6 +2,7 @@ package fsck
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"testing"
@@ -420,3 +421,68 @@ func TestMapping(t *testing.T) {
 		return
 	}
 }
+
+type connSession struct {
+	net.Conn
+}
+
+func (c *connSession) ID() uint16 {
+	return 0
+}
+
+func (c *connSession) RawWrite(p []byte) (n int, err error) {
+	return c.Write(p)
+}
+
+func (c *connSession) OnlyClose() (err error) {
+	return c.Close()
+}
+
+func TestForwardTransport(t *testing.T) {
+	ts := httptest.NewServer(func(hs *routing.HTTPSession) routing.HResult {
+		return hs.Printf("%v", "ok")
+	})
+	dialed := 0
+	forward := NewForward(func(channel, uri string, raw io.WriteCloser) (session Session, err error) {
+		dialed++
+		conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+		if err == nil {
+			session = &connSession{Conn: conn}
+		}
+		return
+	})
+	forward.WebSuffix = ".loc"
+	_, err := forward.AddUriForward("x", "web://x?idle=2&idle_timeout=10<m>"+ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	webts := httptest.NewMuxServer()
+	webts.Mux.HFunc("^.*$", func(hs *routing.HTTPSession) routing.HResult {
+		hs.R.Host = "x.loc"
+		return forward.HostForwardF(hs)
+	})
+	for i := 0; i < 5; i++ {
+		data, err := webts.G("/")
+		if err != nil || data != "ok" {
+			t.Error(err)
+			return
+		}
+	}
+	if dialed != 1 {
+		t.Error("error")
+		return
+	}
+	mapping := forward.webMapping["x"]
+	err = forward.RemoveForward("web://x")
+	if err != nil || len(forward.transports) > 0 {
+		t.Error("error")
+		return
+	}
+	//the removed mapping is not cached again by in flight request
+	if transport := forward.transport(mapping); transport == nil || !transport.DisableKeepAlives || len(forward.transports) > 0 {
+		t.Error("error")
+		return
+	}
+	forward.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"testing"
 	"time"
 
@@ -13,6 +14,10 @@ import (
 func init() {
 	ShowLog = 2
 	netw.MOD_MAX_SIZE = 4
+	if os.Getenv("FSCK_PLUGIN_HELPER") == "1" {
+		//the plugin helper process is the test binary, it not need the echo server
+		return
+	}
 	echo, err := NewEchoServer("tcp", ":9392")
 	if err != nil {
 		panic(err)
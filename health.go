@@ -0,0 +1,199 @@
+package fsck
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Centny/gwf/log"
+)
+
+//MappingHealth is the last health probe result of mapping.
+type MappingHealth struct {
+	Up      bool      `json:"up"`
+	Latency int64     `json:"latency"`
+	Error   string    `json:"error,omitempty"`
+	Last    time.Time `json:"last"`
+}
+
+func (m *MappingHealth) String() string {
+	if m.Up {
+		return fmt.Sprintf("up(%vms)", m.Latency)
+	}
+	return fmt.Sprintf("down(%v)", m.Error)
+}
+
+//HealthCheck is the health probe of mapping, it is configured by the local uri query of mapping.
+//
+//	health=tcp|http|expect   the probe type
+//	health_interval=10       the probe interval in seconds
+//	health_timeout=5         the probe timeout in seconds
+//	health_path=/            the request path for http probe
+//	health_status=200        the expected status for http probe
+//	health_send=xx           the data to send for expect probe
+//	health_expect=xx         the expected data to receive for expect probe
+//	health_refuse=1          refuse the connection when the target is down, only for tcp forward
+type HealthCheck struct {
+	Type     string
+	Interval time.Duration
+	Timeout  time.Duration
+	Path     string
+	Status   int
+	Send     string
+	Expect   string
+	Refuse   bool
+}
+
+//NewHealthCheck will parse the health check option from mapping, it return nil when health check is not enabled.
+func NewHealthCheck(m *Mapping) (check *HealthCheck, err error) {
+	var htype, path, send, expect, refuse string
+	var interval, timeout, status int
+	err = m.LocalValidF(`health,O|S,O:tcp~http~expect;health_interval,O|I,R:0;health_timeout,O|I,R:0;`+
+		`health_path,O|S,L:0;health_status,O|I,R:0;health_send,O|S,L:0;health_expect,O|S,L:0;health_refuse,O|S,O:0~1`,
+		&htype, &interval, &timeout, &path, &status, &send, &expect, &refuse)
+	if err != nil || len(htype) < 1 {
+		return
+	}
+	check = &HealthCheck{
+		Type:     htype,
+		Interval: time.Duration(interval) * time.Second,
+		Timeout:  time.Duration(timeout) * time.Second,
+		Path:     path,
+		Status:   status,
+		Send:     send,
+		Expect:   expect,
+		Refuse:   refuse == "1",
+	}
+	if check.Interval < 1 {
+		check.Interval = 10 * time.Second
+	}
+	if check.Timeout < 1 {
+		check.Timeout = 5 * time.Second
+	}
+	if len(check.Path) < 1 {
+		check.Path = "/"
+	}
+	if check.Status < 1 {
+		check.Status = 200
+	}
+	if check.Type == "expect" && len(check.Expect) < 1 {
+		err = fmt.Errorf("health_expect is required for expect probe")
+	}
+	return
+}
+
+//Probe will run the probe once by dialer.
+func (h *HealthCheck) Probe(f *Forward, m *Mapping) (health *MappingHealth) {
+	health = &MappingHealth{Last: time.Now()}
+	conn, err := f.Dialer(m.Channel, m.Remote.String(), nil)
+	if err == nil {
+		timer := time.AfterFunc(h.Timeout, func() {
+			conn.Close()
+		})
+		switch h.Type {
+		case "http":
+			err = h.probeHTTP(conn, m)
+		case "expect":
+			err = h.probeExpect(conn)
+		}
+		if !timer.Stop() && err != nil {
+			err = fmt.Errorf("timeout")
+		}
+		conn.Close()
+	}
+	health.Latency = int64(time.Since(health.Last) / time.Millisecond)
+	if err == nil {
+		health.Up = true
+	} else {
+		health.Error = err.Error()
+	}
+	return
+}
+
+func (h *HealthCheck) probeHTTP(conn net.Conn, m *Mapping) (err error) {
+	if m.Remote.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		err = tlsConn.Handshake()
+		if err != nil {
+			return
+		}
+		conn = tlsConn
+	}
+	req, err := http.NewRequest("GET", h.Path, nil)
+	if err != nil {
+		return
+	}
+	req.Host = m.Remote.Host
+	req.Header.Set("Connection", "close")
+	err = req.Write(conn)
+	if err != nil {
+		return
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != h.Status {
+		err = fmt.Errorf("status %v", res.StatusCode)
+	}
+	return
+}
+
+func (h *HealthCheck) probeExpect(conn net.Conn) (err error) {
+	if len(h.Send) > 0 {
+		_, err = conn.Write([]byte(h.Send))
+		if err != nil {
+			return
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	data := make([]byte, 1024)
+	for !bytes.Contains(buf.Bytes(), []byte(h.Expect)) {
+		var n int
+		n, err = conn.Read(data)
+		if err != nil {
+			err = fmt.Errorf("expect %v, but %v", strconv.Quote(h.Expect), strconv.Quote(buf.String()))
+			return
+		}
+		buf.Write(data[:n])
+	}
+	return
+}
+
+func (f *Forward) runHealth(m *Mapping, check *HealthCheck) {
+	log.D("Forward(%v) start %v health check by interval(%v)", m.Name, check.Type, check.Interval)
+	for {
+		f.lck.RLock()
+		running := f.ms[m.Name] == m
+		f.lck.RUnlock()
+		if !running {
+			break
+		}
+		health := check.Probe(f, m)
+		f.lck.Lock()
+		old := m.Health
+		m.Health = health
+		f.lck.Unlock()
+		if old == nil || old.Up != health.Up {
+			log.D("Forward(%v) health check is %v", m.Name, health)
+		}
+		time.Sleep(check.Interval)
+	}
+	log.D("Forward(%v) health check is stopped", m.Name)
+}
+
+//refused return whether the connection should be refused by health check.
+func (f *Forward) refused(m *Mapping, check *HealthCheck) bool {
+	if check == nil || !check.Refuse {
+		return false
+	}
+	f.lck.RLock()
+	defer f.lck.RUnlock()
+	return m.Health != nil && !m.Health.Up
+}
@@ -0,0 +1,110 @@
+package fsck
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/routing"
+	"github.com/Centny/gwf/routing/httptest"
+)
+
+func TestHealthCheck(t *testing.T) {
+	ts := httptest.NewServer(func(hs *routing.HTTPSession) routing.HResult {
+		if hs.R.URL.Path == "/bad" {
+			hs.W.WriteHeader(500)
+		}
+		return hs.Printf("%v", "ok")
+	})
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				buf := make([]byte, 4)
+				io.ReadFull(conn, buf)
+				conn.Write([]byte("pong"))
+				conn.Close()
+			}()
+		}
+	}()
+	forward := NewForward(func(channel, uri string, raw io.WriteCloser) (session Session, err error) {
+		addr := strings.TrimPrefix(ts.URL, "http://")
+		if strings.HasPrefix(uri, "tcp://") {
+			addr = listener.Addr().String()
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			session = &connSession{Conn: conn}
+		}
+		return
+	})
+	_, err := forward.AddUriForward("x", "web://x?health=http<m>"+ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = forward.AddUriForward("y", "web://y?health=http&health_path=/bad<m>"+ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = forward.AddUriForward("z", "tcp://127.0.0.1:23781?health=expect&health_send=ping&health_expect=pong&health_interval=1&health_refuse=1<m>tcp://"+listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(300 * time.Millisecond)
+	ms := map[string]*Mapping{}
+	for _, m := range forward.List() {
+		ms[m.Name] = m
+	}
+	if ms["x"].Health == nil || !ms["x"].Health.Up {
+		t.Error("error")
+		return
+	}
+	if ms["y"].Health == nil || ms["y"].Health.Up || ms["y"].Health.Error != "status 500" {
+		t.Error("error")
+		return
+	}
+	if ms["z"].Health == nil || !ms["z"].Health.Up {
+		t.Error("error")
+		return
+	}
+	fmt.Println(ms["x"].Health, ms["y"].Health, ms["z"].Health)
+	//
+	//down and refuse
+	listener.Close()
+	time.Sleep(1500 * time.Millisecond)
+	for _, m := range forward.List() {
+		ms[m.Name] = m
+	}
+	if ms["z"].Health.Up {
+		t.Error("error")
+		return
+	}
+	conn, err := net.Dial("tcp", ms["z"].Local.Host)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	//
+	//invalid
+	_, err = forward.AddUriForward("e", "web://e?health=expect<m>"+ts.URL)
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	forward.Close()
+}
@@ -0,0 +1,510 @@
+package fsck
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//ingestTimer is the aggregated timer values between flush.
+type ingestTimer struct {
+	sum, min, max float64
+	count         int
+}
+
+func (i *ingestTimer) add(val float64) {
+	if i.count < 1 || val < i.min {
+		i.min = val
+	}
+	if i.count < 1 || val > i.max {
+		i.max = val
+	}
+	i.sum += val
+	i.count++
+}
+
+//ingestHost is the buffered metrics of one host between flush.
+type ingestHost struct {
+	values   map[string]float64      //the gauge and line protocol field, it is kept after flush.
+	counters map[string]float64      //the counter is reset to zero after flush.
+	timers   map[string]*ingestTimer //the timer is removed after flush.
+	flushed  map[string]bool         //the timer flushed on last, it is removed from RealTime when no value on next flush.
+	labels   map[string]string
+	dirty    bool
+	updated  time.Time
+}
+
+func newIngestHost() *ingestHost {
+	return &ingestHost{
+		values:   map[string]float64{},
+		counters: map[string]float64{},
+		timers:   map[string]*ingestTimer{},
+		flushed:  map[string]bool{},
+		labels:   map[string]string{},
+	}
+}
+
+//allow will return true when the key is exists or the keys of host is less than max, 0 is not limited.
+func (i *ingestHost) allow(key string, max int) bool {
+	if max < 1 {
+		return true
+	}
+	_, value := i.values[key]
+	_, counter := i.counters[key]
+	_, timer := i.timers[key]
+	return value || counter || timer || len(i.values)+len(i.counters)+len(i.timers) < max
+}
+
+//keys will return all keys which is flushed to RealTime.
+func (i *ingestHost) keys() (keys []string) {
+	for key := range i.values {
+		keys = append(keys, key)
+	}
+	for key := range i.counters {
+		keys = append(keys, key)
+	}
+	for key := range i.flushed {
+		for _, suffix := range []string{"", "_min", "_max", "_count"} {
+			keys = append(keys, key+suffix)
+		}
+	}
+	return
+}
+
+//RealIngester will receive the StatsD and InfluxDB line protocol metrics by udp/tcp and flush it to RealTime by Flush,
+//so the existing application instrumentation can report to realtime log without custom code. the supported metrics is
+//
+//	<key>:<value>|c[|@<rate>][|#<tag>:<value>,...]     statsd counter, it is summed in flush and reset to zero after flush
+//	<key>:[+-]<value>|g[|#...]                          statsd gauge, the signed value is added to current value
+//	<key>:<value>|ms/h/d[|@<rate>][|#...]               statsd timer, it is flushed as <key>(avg),<key>_min,<key>_max,<key>_count,
+//	                                                    and removed from realtime log when no value is received in next flush
+//	<measurement>[,<tag>=<value>...] <field>=<value>[,...] [timestamp]
+//	                                                    line protocol, it is flushed as <measurement>_<field>, the value field is flushed as <measurement>
+//
+//the host tag is used as realtime log name, default is Name, the tags in Labels is flushed as labels, others is ignored.
+//at most MaxHosts host and MaxKeys key of each host is kept, the new one is rejected when it is full,
+//and the host which is not updated in Stale is removed from ingester and RealTime.
+type RealIngester struct {
+	Real      *RealTime
+	Name      string
+	Flush     time.Duration
+	Labels    []string //the tag names to flush as realtime log labels.
+	MaxHosts  int
+	MaxKeys   int
+	MaxLine   int //the max line length of tcp, the connection is closed when line is too long.
+	Stale     time.Duration
+	hosts     map[string]*ingestHost
+	listeners []io.Closer
+	running   bool
+	lck       sync.RWMutex
+}
+
+//NewRealIngester will return new ingester by default host name.
+func NewRealIngester(real *RealTime, name string) *RealIngester {
+	return &RealIngester{
+		Real:     real,
+		Name:     name,
+		Flush:    10 * time.Second,
+		MaxHosts: 1000,
+		MaxKeys:  1000,
+		MaxLine:  64 * 1024,
+		Stale:    10 * time.Minute,
+		hosts:    map[string]*ingestHost{},
+	}
+}
+
+//Ingest will ingest multi lines of statsd or line protocol, the format is detected by line, it return the last error.
+func (r *RealIngester) Ingest(data string) (err error) {
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var lerr error
+		if idx := strings.Index(line, "|"); idx > 0 && strings.Contains(line[:idx], ":") && !strings.ContainsAny(line[:idx], " ,=") {
+			lerr = r.IngestStatsD(line)
+		} else {
+			lerr = r.IngestLine(line)
+		}
+		if lerr != nil {
+			err = lerr
+		}
+	}
+	return
+}
+
+//IngestStatsD will ingest one statsd line.
+func (r *RealIngester) IngestStatsD(line string) (err error) {
+	parts := strings.Split(line, "|")
+	kv := strings.SplitN(parts[0], ":", 2)
+	if len(parts) < 2 || len(kv) < 2 || len(kv[0]) < 1 {
+		err = fmt.Errorf("invalid statsd line(%v)", line)
+		return
+	}
+	key, sval, kind := kv[0], kv[1], parts[1]
+	val, err := strconv.ParseFloat(sval, 64)
+	if err != nil {
+		err = fmt.Errorf("invalid statsd value(%v) by %v", sval, err)
+		return
+	}
+	rate := 1.0
+	tags := map[string]string{}
+	for _, part := range parts[2:] {
+		if strings.HasPrefix(part, "@") {
+			rate, err = strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				err = fmt.Errorf("invalid statsd sample rate(%v)", part)
+				return
+			}
+		} else if strings.HasPrefix(part, "#") {
+			for _, tag := range strings.Split(part[1:], ",") {
+				tkv := strings.SplitN(tag, ":", 2)
+				if len(tkv) > 1 {
+					tags[tkv[0]] = tkv[1]
+				}
+			}
+		}
+	}
+	if kind != "c" && kind != "g" && kind != "ms" && kind != "h" && kind != "d" {
+		err = fmt.Errorf("statsd type(%v) is not supported", kind)
+		return
+	}
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	host, err := r.host(tags)
+	if err != nil {
+		return
+	}
+	if !host.allow(key, r.MaxKeys) {
+		err = fmt.Errorf("too many keys on host, the max is %v", r.MaxKeys)
+		return
+	}
+	host.dirty = true
+	switch kind {
+	case "c":
+		host.counters[key] += val / rate
+	case "g":
+		if strings.HasPrefix(sval, "+") || strings.HasPrefix(sval, "-") {
+			host.values[key] += val
+		} else {
+			host.values[key] = val
+		}
+	default:
+		timer := host.timers[key]
+		if timer == nil {
+			timer = &ingestTimer{}
+			host.timers[key] = timer
+		}
+		timer.add(val)
+	}
+	return
+}
+
+//IngestLine will ingest one InfluxDB line protocol, the string field and timestamp is ignored.
+func (r *RealIngester) IngestLine(line string) (err error) {
+	parts := splitLineProtocol(line, ' ')
+	if len(parts) < 2 || len(parts) > 3 {
+		err = fmt.Errorf("invalid line protocol(%v)", line)
+		return
+	}
+	series := splitLineProtocol(parts[0], ',')
+	measurement := unescapeLineProtocol(series[0])
+	if len(measurement) < 1 {
+		err = fmt.Errorf("invalid line protocol(%v), the measurement is empty", line)
+		return
+	}
+	tags := map[string]string{}
+	for _, tag := range series[1:] {
+		tkv := splitLineProtocol(tag, '=')
+		if len(tkv) != 2 {
+			err = fmt.Errorf("invalid line protocol tag(%v)", tag)
+			return
+		}
+		tags[unescapeLineProtocol(tkv[0])] = unescapeLineProtocol(tkv[1])
+	}
+	values := map[string]float64{}
+	for _, field := range splitLineProtocol(parts[1], ',') {
+		fkv := splitLineProtocol(field, '=')
+		if len(fkv) != 2 {
+			err = fmt.Errorf("invalid line protocol field(%v)", field)
+			return
+		}
+		name, sval := unescapeLineProtocol(fkv[0]), fkv[1]
+		var val float64
+		switch {
+		case strings.HasPrefix(sval, "\""):
+			continue
+		case sval == "t" || sval == "T" || sval == "true" || sval == "True" || sval == "TRUE":
+			val = 1
+		case sval == "f" || sval == "F" || sval == "false" || sval == "False" || sval == "FALSE":
+			val = 0
+		case strings.HasSuffix(sval, "i") || strings.HasSuffix(sval, "u"):
+			var ival int64
+			ival, err = strconv.ParseInt(sval[:len(sval)-1], 10, 64)
+			val = float64(ival)
+		default:
+			val, err = strconv.ParseFloat(sval, 64)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid line protocol field(%v) by %v", field, err)
+			return
+		}
+		if name == "value" {
+			values[measurement] = val
+		} else {
+			values[measurement+"_"+name] = val
+		}
+	}
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	host, err := r.host(tags)
+	if err != nil {
+		return
+	}
+	for key, val := range values {
+		if !host.allow(key, r.MaxKeys) {
+			err = fmt.Errorf("too many keys on host, the max is %v", r.MaxKeys)
+			continue
+		}
+		host.values[key] = val
+		host.dirty = true
+	}
+	return
+}
+
+//host will return the buffered host by tags and update the labels, it must be called with lock.
+func (r *RealIngester) host(tags map[string]string) (host *ingestHost, err error) {
+	name := tags["host"]
+	if len(name) < 1 {
+		name = r.Name
+	}
+	host = r.hosts[name]
+	if host == nil {
+		if r.MaxHosts > 0 && len(r.hosts) >= r.MaxHosts {
+			err = fmt.Errorf("too many hosts, the max is %v", r.MaxHosts)
+			return
+		}
+		host = newIngestHost()
+		r.hosts[name] = host
+	}
+	for _, label := range r.Labels {
+		if val, ok := tags[label]; ok {
+			host.labels[label] = val
+		}
+	}
+	host.updated = time.Now()
+	return
+}
+
+//FlushLog will flush the buffered metrics of updated host to RealTime, the host which is not updated is not flushed,
+//so it can be offline on RealTime, and the host which is not updated in Stale is removed.
+func (r *RealIngester) FlushLog() {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	now := time.Now()
+	for name, host := range r.hosts {
+		if r.Stale > 0 && now.Sub(host.updated) >= r.Stale {
+			delete(r.hosts, name)
+			r.Real.Remove(name, host.keys()...)
+			continue
+		}
+		if !host.dirty {
+			continue
+		}
+		host.dirty = false
+		metrics := util.Map{}
+		for key, val := range host.values {
+			metrics[key] = val
+		}
+		for key, val := range host.counters {
+			metrics[key] = val
+			if val != 0 { //flush the zero on next
+				host.dirty = true
+			}
+			host.counters[key] = 0
+		}
+		for key := range host.flushed {
+			if _, ok := host.timers[key]; !ok {
+				for _, suffix := range []string{"", "_min", "_max", "_count"} {
+					metrics[key+suffix] = nil
+				}
+			}
+		}
+		host.flushed = map[string]bool{}
+		for key, timer := range host.timers {
+			host.flushed[key] = true
+			host.dirty = true //remove the timer on next when it is not updated
+			metrics[key] = timer.sum / float64(timer.count)
+			metrics[key+"_min"] = timer.min
+			metrics[key+"_max"] = timer.max
+			metrics[key+"_count"] = timer.count
+		}
+		host.timers = map[string]*ingestTimer{}
+		if len(host.labels) > 0 {
+			labels := util.Map{}
+			for key, val := range host.labels {
+				labels[key] = val
+			}
+			metrics["labels"] = labels
+		}
+		r.Real.Patch(name, metrics)
+	}
+}
+
+//ListenUDP will receive the metrics from udp, one packet can contain multi lines.
+func (r *RealIngester) ListenUDP(addr string) (err error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return
+	}
+	r.lck.Lock()
+	r.listeners = append(r.listeners, conn)
+	r.lck.Unlock()
+	log.D("RealIngester listen udp on %v", addr)
+	go r.runUDP(conn)
+	return
+}
+
+func (r *RealIngester) runUDP(conn net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		readed, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.D("RealIngester udp on %v is stopped by %v", conn.LocalAddr(), err)
+			break
+		}
+		err = r.Ingest(string(buf[:readed]))
+		if err != nil {
+			log.D("RealIngester ingest fail with %v", err)
+		}
+	}
+}
+
+//ListenTCP will receive the metrics from tcp by line.
+func (r *RealIngester) ListenTCP(addr string) (err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	r.lck.Lock()
+	r.listeners = append(r.listeners, listener)
+	r.lck.Unlock()
+	log.D("RealIngester listen tcp on %v", addr)
+	go r.runTCP(listener)
+	return
+}
+
+func (r *RealIngester) runTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.D("RealIngester tcp on %v is stopped by %v", listener.Addr(), err)
+			break
+		}
+		go r.procTCP(conn)
+	}
+}
+
+func (r *RealIngester) procTCP(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	if r.MaxLine > 0 {
+		size := 4096
+		if r.MaxLine < size {
+			size = r.MaxLine
+		}
+		scanner.Buffer(make([]byte, 0, size), r.MaxLine)
+	}
+	for scanner.Scan() {
+		if err := r.Ingest(scanner.Text()); err != nil {
+			log.D("RealIngester ingest from %v fail with %v", conn.RemoteAddr(), err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.D("RealIngester tcp from %v is closed by %v", conn.RemoteAddr(), err)
+	}
+}
+
+//Start will start the flush loop.
+func (r *RealIngester) Start() (err error) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.running {
+		err = fmt.Errorf("ingester is running")
+		return
+	}
+	if r.Flush <= 0 {
+		err = fmt.Errorf("the flush interval(%v) must be greater than zero", r.Flush)
+		return
+	}
+	r.running = true
+	go r.run()
+	return
+}
+
+func (r *RealIngester) run() {
+	log.D("RealIngester(%v) start flush by interval(%v)", r.Name, r.Flush)
+	for {
+		time.Sleep(r.Flush)
+		r.lck.RLock()
+		running := r.running
+		r.lck.RUnlock()
+		if !running {
+			break
+		}
+		r.FlushLog()
+	}
+	log.D("RealIngester(%v) is stopped", r.Name)
+}
+
+//Close will stop the flush loop and close all listener.
+func (r *RealIngester) Close() error {
+	r.lck.Lock()
+	r.running = false
+	listeners := r.listeners
+	r.listeners = nil
+	r.lck.Unlock()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	return nil
+}
+
+func (r *RealIngester) String() string {
+	return fmt.Sprintf("RealIngester(%v)", r.Name)
+}
+
+//splitLineProtocol will split the line protocol by separator which is not escaped or quoted.
+func splitLineProtocol(line string, sep byte) (parts []string) {
+	begin, quoted := 0, false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, line[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	parts = append(parts, line[begin:])
+	return
+}
+
+func unescapeLineProtocol(val string) string {
+	for _, escaped := range []string{`\ `, `\,`, `\=`, `\"`, `\\`} {
+		val = strings.Replace(val, escaped, escaped[1:], -1)
+	}
+	return val
+}
@@ -0,0 +1,206 @@
+package fsck
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRealIngester(t *testing.T) {
+	real := NewRealTime()
+	ingester := NewRealIngester(real, "h0")
+	ingester.Labels = []string{"region"}
+	err := ingester.Ingest(`
+requests:1|c
+requests:2|c|@0.5
+conns:10|g
+conns:-3|g
+latency:10|ms
+latency:30|ms|#host:h1,region:eu
+latency:20|ms|#host:h1
+# comment
+cpu,host=h1,region=eu,core=0 usage=12.5,idle=87.5,up=t,ok=1i,msg="a b=c" 1465839830100400200
+mem value=1024i
+disk\ io,host=h1 read=3u
+`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//error
+	for _, line := range []string{
+		"requests:x|c",
+		"requests:1|x",
+		"requests:1|c|@0",
+		"cpu",
+		"cpu usage",
+		"cpu usage=x",
+		"cpu,host usage=1",
+		",host=h1 usage=1",
+		"cpu usage=1 1 x",
+	} {
+		if err = ingester.Ingest(line); err == nil {
+			t.Errorf("%v: not error", line)
+			return
+		}
+		fmt.Println(err)
+	}
+	ingester.FlushLog()
+	h0, h1 := real.ls["h0"], real.ls["h1"]
+	if h0 == nil || h1 == nil {
+		t.Errorf("%v", real.ls)
+		return
+	}
+	if h0.Log.FloatVal("requests") != 5 || h0.Log.FloatVal("conns") != 7 || h0.Log.FloatVal("latency") != 10 ||
+		h0.Log.IntVal("latency_count") != 1 || h0.Log.FloatVal("mem") != 1024 || len(h0.Labels) != 0 {
+		t.Errorf("%v", h0.Log)
+		return
+	}
+	if h1.Log.FloatVal("latency") != 25 || h1.Log.FloatVal("latency_min") != 20 || h1.Log.FloatVal("latency_max") != 30 ||
+		h1.Log.FloatVal("cpu_usage") != 12.5 || h1.Log.FloatVal("cpu_up") != 1 || h1.Log.FloatVal("cpu_ok") != 1 ||
+		h1.Log.Exist("cpu_msg") || h1.Log.FloatVal("disk io_read") != 3 || h1.Labels["region"] != "eu" || len(h1.Labels) != 1 {
+		t.Errorf("%v %v", h1.Log, h1.Labels)
+		return
+	}
+	//the counter is reset and timer is removed
+	ingester.Ingest("latency:40|ms")
+	ingester.FlushLog()
+	if h0.Log.FloatVal("requests") != 0 || h0.Log.FloatVal("conns") != 7 || h0.Log.FloatVal("latency") != 40 ||
+		h1.Log.Exist("latency") || h1.Log.Exist("latency_min") || h1.Log.Exist("latency_max") || h1.Log.Exist("latency_count") ||
+		h1.Log.FloatVal("cpu_usage") != 12.5 {
+		t.Errorf("%v %v", h0.Log, h1.Log)
+		return
+	}
+	//the host without update is not flushed
+	h1Last := h1.Last
+	time.Sleep(10 * time.Millisecond)
+	ingester.FlushLog()
+	if h0.Log.Exist("latency") || h1.Last != h1Last {
+		t.Errorf("%v", h0.Log)
+		return
+	}
+	h0Last := h0.Last
+	time.Sleep(10 * time.Millisecond)
+	ingester.FlushLog()
+	if h0.Last != h0Last {
+		t.Error("error")
+		return
+	}
+	//the other key of same name is kept
+	real.Patch("h0", map[string]interface{}{"cpu": 1})
+	ingester.Ingest("conns:+1|g")
+	ingester.FlushLog()
+	if h0.Log.FloatVal("cpu") != 1 || h0.Log.FloatVal("conns") != 8 {
+		t.Errorf("%v", h0.Log)
+		return
+	}
+	//listen
+	ingester.Flush = 0
+	if err = ingester.Start(); err == nil {
+		t.Error("error")
+		return
+	}
+	ingester.Flush = 50 * time.Millisecond
+	if err = ingester.ListenUDP(":9381"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = ingester.ListenTCP(":9381"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = ingester.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = ingester.Start(); err == nil {
+		t.Error("error")
+		return
+	}
+	udp, err := net.Dial("udp", "localhost:9381")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(udp, "udp:1|g|#host:h2\nudp_count:2|c|#host:h2")
+	udp.Close()
+	tcp, err := net.Dial("tcp", "localhost:9381")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(tcp, "tcp,host=h3 value=3\nbad line\ntcp,host=h3 other=4\n")
+	tcp.Close()
+	time.Sleep(300 * time.Millisecond)
+	hosts, logs := real.MergeLog(map[string]int64{"h2": 0, "h3": 0}, map[string]string{"udp": "sum", "tcp": "sum", "tcp_other": "sum"})
+	if len(hosts) != 2 || logs.FloatVal("udp") != 1 || logs.FloatVal("tcp") != 3 || logs.FloatVal("tcp_other") != 4 {
+		t.Errorf("%v %v", hosts, logs)
+		return
+	}
+	ingester.Close()
+	fmt.Println(ingester)
+}
+
+func TestRealIngesterBounded(t *testing.T) {
+	real := NewRealTime()
+	ingester := NewRealIngester(real, "h0")
+	ingester.MaxHosts = 2
+	ingester.MaxKeys = 2
+	//max hosts and keys
+	if err := ingester.Ingest("a:1|c\nb:1|g\nc:1|ms"); err == nil {
+		t.Error("error")
+		return
+	}
+	if err := ingester.Ingest("a:1|c\ncpu,host=h1 a=1,b=2"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ingester.Ingest("cpu,host=h2 a=1"); err == nil || len(ingester.hosts) != 2 {
+		t.Error(err)
+		return
+	}
+	//timer is aggregated
+	ingester.MaxKeys = 3
+	for i := 0; i < 100; i++ {
+		ingester.Ingest(fmt.Sprintf("latency:%v|ms", i))
+	}
+	ingester.FlushLog()
+	h0 := real.ls["h0"]
+	if h0 == nil || h0.Log.FloatVal("a") != 2 || h0.Log.FloatVal("b") != 1 || h0.Log.Exist("c") ||
+		h0.Log.FloatVal("latency") != 49.5 || h0.Log.IntVal("latency_count") != 100 || h0.Log.FloatVal("latency_max") != 99 {
+		t.Errorf("%v", h0.Log)
+		return
+	}
+	//stale host is removed, the other key of same name is kept
+	real.Patch("h0", map[string]interface{}{"cpu": 1})
+	ingester.Stale = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	ingester.FlushLog()
+	if len(ingester.hosts) != 0 || real.ls["h1"] != nil || real.ls["h0"] == nil || len(real.ls["h0"].Log) != 1 {
+		t.Errorf("%v", real.ls)
+		return
+	}
+	//long line
+	ingester.MaxLine = 16
+	local, remote := net.Pipe()
+	done := make(chan bool)
+	go func() {
+		ingester.procTCP(remote)
+		done <- true
+	}()
+	fmt.Fprintf(local, "a:1|c|#host:h3\n")
+	go fmt.Fprintf(local, "%v:1|c\n", strings.Repeat("x", 100))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("not closed")
+		return
+	}
+	local.Close()
+	if len(ingester.hosts) != 1 || ingester.hosts["h3"] == nil {
+		t.Errorf("%v", ingester.hosts)
+		return
+	}
+}
@@ -0,0 +1,197 @@
+package fsck
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//metricsSample is the raw counter sample of host, the rate metrics is calculated by two sample.
+type metricsSample struct {
+	Time      time.Time
+	CPUTotal  uint64 //the total cpu ticks
+	CPUIdle   uint64 //the idle and iowait cpu ticks
+	Load      float64
+	MemTotal  int64
+	MemAvail  int64
+	NetRx     int64 //the received bytes of all interface except lo
+	NetTx     int64
+	DiskRead  int64 //the read bytes of all disk except partition
+	DiskWrite int64
+	Procs     map[int]*ProcInfo //the matched process by pid
+}
+
+//MetricsCollector will sample the host metrics from /proc by Interval and update it to RealTime by Name,
+//so the metrics of all slavers can be aggregated by sreal. the collected metrics is
+//
+//	cpu,load                                  the cpu usage percent and 1 minute load average
+//	mem_total,mem_used,mem_percent            the memory usage
+//	net_rx,net_tx                             the network bytes per second
+//	disk_read,disk_write                      the disk io bytes per second
+//	proc_<name>_count,_cpu,_rss               the process stats matched by Procs name
+//
+//the rate metrics(cpu/net/disk) is not collected on first sample. the proc_<name>_cpu is calculated by
+//cpu time between two sample, it is the average since process started on first sample or new process.
+type MetricsCollector struct {
+	Real     *RealTime
+	Name     string
+	Interval time.Duration
+	Root     string   //the proc filesystem root, default is /proc.
+	Procs    []string //the process name or cmdline to collect process stats.
+	Labels   util.Map //the labels like region/role to group the metrics by sreal.
+	last     *metricsSample
+	running  bool
+	lck      sync.RWMutex
+}
+
+//NewMetricsCollector will return new metrics collector.
+func NewMetricsCollector(real *RealTime, name string) *MetricsCollector {
+	return &MetricsCollector{
+		Real:     real,
+		Name:     name,
+		Interval: 5 * time.Second,
+		Root:     "/proc",
+	}
+}
+
+//Collect will sample the metrics once and update it to RealTime.
+func (m *MetricsCollector) Collect() (metrics util.Map, err error) {
+	sample, err := readMetricsSample(m.Root)
+	if err != nil {
+		return
+	}
+	metrics = util.Map{
+		"load":      sample.Load,
+		"mem_total": sample.MemTotal,
+		"mem_used":  sample.MemTotal - sample.MemAvail,
+	}
+	if sample.MemTotal > 0 {
+		metrics["mem_percent"] = percent(float64(sample.MemTotal-sample.MemAvail), float64(sample.MemTotal))
+	}
+	m.lck.Lock()
+	last := m.last
+	m.last = sample
+	m.lck.Unlock()
+	if last != nil {
+		//the counter may be reset or decreased, so check both before subtracting the unsigned ticks.
+		if sample.CPUTotal > last.CPUTotal && sample.CPUIdle >= last.CPUIdle && sample.CPUIdle-last.CPUIdle <= sample.CPUTotal-last.CPUTotal {
+			total, idle := sample.CPUTotal-last.CPUTotal, sample.CPUIdle-last.CPUIdle
+			metrics["cpu"] = percent(float64(total-idle), float64(total))
+		}
+		if seconds := sample.Time.Sub(last.Time).Seconds(); seconds > 0 {
+			metrics["net_rx"] = rate(sample.NetRx-last.NetRx, seconds)
+			metrics["net_tx"] = rate(sample.NetTx-last.NetTx, seconds)
+			metrics["disk_read"] = rate(sample.DiskRead-last.DiskRead, seconds)
+			metrics["disk_write"] = rate(sample.DiskWrite-last.DiskWrite, seconds)
+		}
+	}
+	if len(m.Procs) > 0 {
+		var procs []*ProcInfo
+		procs, err = listProcs(m.Root)
+		if err != nil {
+			return
+		}
+		sample.Procs = map[int]*ProcInfo{}
+		for _, name := range m.Procs {
+			var count, rss int64
+			var cpu float64
+			for _, proc := range (&ProcFilter{Name: name}).Filter(procs) {
+				count++
+				rss += proc.RSS
+				cpu += procCPU(proc, last, sample)
+				sample.Procs[proc.PID] = proc
+			}
+			key := "proc_" + strings.Replace(name, " ", "_", -1)
+			metrics[key+"_count"] = count
+			metrics[key+"_cpu"] = float64(int64(cpu*100)) / 100
+			metrics[key+"_rss"] = rss
+		}
+	}
+	if len(m.Labels) > 0 {
+		metrics["labels"] = m.Labels
+	}
+	m.Real.Patch(m.Name, metrics)
+	return
+}
+
+//Start will start the collector loop, it return error when the metrics is not supported.
+func (m *MetricsCollector) Start() (err error) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if m.running {
+		err = fmt.Errorf("metrics collector is running")
+		return
+	}
+	_, err = readMetricsSample(m.Root)
+	if err != nil {
+		return
+	}
+	m.running = true
+	go m.run()
+	return
+}
+
+func (m *MetricsCollector) run() {
+	log.D("MetricsCollector(%v) start collect by interval(%v)", m.Name, m.Interval)
+	for {
+		m.lck.RLock()
+		running := m.running
+		m.lck.RUnlock()
+		if !running {
+			break
+		}
+		_, err := m.Collect()
+		if err != nil {
+			log.W("MetricsCollector(%v) collect fail with %v", m.Name, err)
+		}
+		time.Sleep(m.Interval)
+	}
+	log.D("MetricsCollector(%v) is stopped", m.Name)
+}
+
+//Stop will stop the collector loop.
+func (m *MetricsCollector) Stop() {
+	m.lck.Lock()
+	m.running = false
+	m.last = nil
+	m.lck.Unlock()
+}
+
+func (m *MetricsCollector) String() string {
+	return fmt.Sprintf("MetricsCollector(%v)", m.Name)
+}
+
+//procCPU will return the cpu usage percent of process between last and current sample,
+//it return the average since process started when the process is not found in last sample.
+func procCPU(proc *ProcInfo, last, sample *metricsSample) float64 {
+	if last == nil || last.Procs == nil {
+		return proc.CPU
+	}
+	old := last.Procs[proc.PID]
+	if old == nil || old.Start != proc.Start || old.cpuTime > proc.cpuTime {
+		return proc.CPU
+	}
+	seconds := sample.Time.Sub(last.Time).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return (proc.cpuTime - old.cpuTime) / seconds * 100
+}
+
+func percent(val, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(int64(val/total*10000)) / 100
+}
+
+func rate(val int64, seconds float64) int64 {
+	if val < 0 { //the counter is reset
+		return 0
+	}
+	return int64(float64(val) / seconds)
+}
@@ -0,0 +1,12 @@
+package fsck
+
+import (
+	"fmt"
+	"runtime"
+)
+
+//readMetricsSample is not supported on darwin.
+func readMetricsSample(root string) (sample *metricsSample, err error) {
+	err = fmt.Errorf("metrics is not supported on %v", runtime.GOOS)
+	return
+}
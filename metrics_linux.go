@@ -0,0 +1,118 @@
+package fsck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var diskPartitionRegex = regexp.MustCompile(`^p?\d+$`)
+
+//readMetricsSample will read the metrics counter from proc filesystem root.
+func readMetricsSample(root string) (sample *metricsSample, err error) {
+	sample = &metricsSample{Time: time.Now()}
+	//cpu
+	data, err := ioutil.ReadFile(filepath.Join(root, "stat"))
+	if err != nil {
+		return
+	}
+	line := strings.SplitN(string(data), "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		err = fmt.Errorf("invalid stat(%s)", line)
+		return
+	}
+	for idx, field := range fields[1:] {
+		val, _ := strconv.ParseUint(field, 10, 64)
+		if idx == 8 || idx == 9 { //guest time is included in user time
+			continue
+		}
+		sample.CPUTotal += val
+		if idx == 3 || idx == 4 { //idle and iowait
+			sample.CPUIdle += val
+		}
+	}
+	//load
+	data, err = ioutil.ReadFile(filepath.Join(root, "loadavg"))
+	if err != nil {
+		return
+	}
+	if fields = strings.Fields(string(data)); len(fields) > 0 {
+		sample.Load, _ = strconv.ParseFloat(fields[0], 64)
+	}
+	//memory
+	data, err = ioutil.ReadFile(filepath.Join(root, "meminfo"))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		val, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			sample.MemTotal = val * 1024
+		case "MemAvailable:":
+			sample.MemAvail = val * 1024
+		}
+	}
+	//network, the missing file is skipped on container.
+	if data, nerr := ioutil.ReadFile(filepath.Join(root, "net/dev")); nerr == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) < 2 || strings.TrimSpace(parts[0]) == "lo" {
+				continue
+			}
+			fields := strings.Fields(parts[1])
+			if len(fields) < 9 {
+				continue
+			}
+			rx, _ := strconv.ParseInt(fields[0], 10, 64)
+			tx, _ := strconv.ParseInt(fields[8], 10, 64)
+			sample.NetRx += rx
+			sample.NetTx += tx
+		}
+	}
+	//disk, the sectors is always 512 bytes in diskstats.
+	if data, derr := ioutil.ReadFile(filepath.Join(root, "diskstats")); derr == nil {
+		disks := map[string][]string{}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 10 {
+				continue
+			}
+			name := fields[2]
+			if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") ||
+				strings.HasPrefix(name, "dm-") || strings.HasPrefix(name, "sr") {
+				continue
+			}
+			disks[name] = fields
+		}
+		for name, fields := range disks {
+			if isDiskPartition(name, disks) {
+				continue
+			}
+			read, _ := strconv.ParseInt(fields[5], 10, 64)
+			write, _ := strconv.ParseInt(fields[9], 10, 64)
+			sample.DiskRead += read * 512
+			sample.DiskWrite += write * 512
+		}
+	}
+	return
+}
+
+//isDiskPartition return whether the disk is partition of other disk, like sda1 of sda, nvme0n1p1 of nvme0n1.
+func isDiskPartition(name string, disks map[string][]string) bool {
+	for disk := range disks {
+		if disk != name && strings.HasPrefix(name, disk) && diskPartitionRegex.MatchString(strings.TrimPrefix(name, disk)) {
+			return true
+		}
+	}
+	return false
+}
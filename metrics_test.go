@@ -0,0 +1,123 @@
+package fsck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func writeMetricsProc(cpu, net, disk string) {
+	ioutil.WriteFile("/tmp/fsck_metrics/stat", []byte(cpu+"\ncpu0 1 2 3\nbtime 1600000000\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_metrics/net/dev", []byte("Inter-|   Receive\n face |bytes\n"+net), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_metrics/diskstats", []byte(disk), os.ModePerm)
+}
+
+func TestMetricsCollector(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	os.RemoveAll("/tmp/fsck_metrics")
+	defer os.RemoveAll("/tmp/fsck_metrics")
+	os.MkdirAll("/tmp/fsck_metrics/net", os.ModePerm)
+	os.MkdirAll("/tmp/fsck_metrics/100", os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_metrics/loadavg", []byte("0.50 0.25 0.10 1/100 1000\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_metrics/meminfo", []byte("MemTotal:     4096 kB\nMemAvailable:     1024 kB\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_metrics/uptime", []byte("1100.00 2000.00\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_metrics/100/stat",
+		[]byte("100 (nginx) S 1 100 100 0 -1 4194560 0 0 0 0 3000 2000 0 0 20 0 1 0 10000 1000000 25 18446744073709551615"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_metrics/100/cmdline", []byte("nginx\x00"), os.ModePerm)
+	writeMetricsProc("cpu  100 0 100 700 100 0 0 0 0 0",
+		"    lo: 1000 1 0 0 0 0 0 0 1000 1 0 0 0 0 0 0\n  eth0: 1000 1 0 0 0 0 0 0 2000 1 0 0 0 0 0 0\n",
+		"   8       0 sda 1 0 10 0 1 0 20 0 0 0 0\n   8       1 sda1 1 0 10 0 1 0 20 0 0 0 0\n   7       0 loop0 1 0 10 0 1 0 20 0 0 0 0\n")
+	real := NewRealTime()
+	metrics := NewMetricsCollector(real, "host1")
+	metrics.Root = "/tmp/fsck_metrics"
+	metrics.Procs = []string{"nginx", "not exists"}
+	metrics.Labels = util.Map{"region": "eu"}
+	res, err := metrics.Collect()
+	if err != nil || res.FloatVal("load") != 0.5 || res.IntVal("mem_used") != 3072*1024 || res.FloatVal("mem_percent") != 75 ||
+		res.Exist("cpu") || res.Exist("net_rx") || res.IntVal("proc_nginx_count") != 1 || res.FloatVal("proc_nginx_cpu") != 5 ||
+		res.IntVal("proc_not_exists_count") != 0 {
+		t.Errorf("%v,%v", res, err)
+		return
+	}
+	//rate
+	metrics.last.Time = metrics.last.Time.Add(-2 * time.Second)
+	ioutil.WriteFile("/tmp/fsck_metrics/100/stat",
+		[]byte("100 (nginx) S 1 100 100 0 -1 4194560 0 0 0 0 3100 2000 0 0 20 0 1 0 10000 1000000 25 18446744073709551615"), os.ModePerm)
+	writeMetricsProc("cpu  200 0 200 1300 200 0 0 0 0 0",
+		"    lo: 9000 1 0 0 0 0 0 0 9000 1 0 0 0 0 0 0\n  eth0: 3000 1 0 0 0 0 0 0 6000 1 0 0 0 0 0 0\n",
+		"   8       0 sda 1 0 30 0 1 0 60 0 0 0 0\n   8       1 sda1 1 0 30 0 1 0 60 0 0 0 0\n   7       0 loop0 1 0 90 0 1 0 90 0 0 0 0\n")
+	res, err = metrics.Collect()
+	if err != nil || res.FloatVal("cpu") != 22.22 || res.FloatVal("proc_nginx_cpu") < 49 || res.FloatVal("proc_nginx_cpu") > 50 || res.IntVal("net_rx") < 900 || res.IntVal("net_rx") > 1000 ||
+		res.IntVal("net_tx") < 1900 || res.IntVal("net_tx") > 2000 || res.IntVal("disk_read") < 5000 || res.IntVal("disk_read") > 5120 {
+		t.Errorf("%v,%v", res, err)
+		return
+	}
+	hosts, logs := real.MergeLog(map[string]int64{"*": 2000}, map[string]string{"cpu": "avg", "mem_used": "sum"})
+	if hosts.StrVal("host1") != "ok" || logs.FloatVal("cpu") != 22.22 || real.ls["host1"].Labels["region"] != "eu" {
+		t.Errorf("%v,%v", hosts, logs)
+		return
+	}
+	//idle counter is decreased
+	metrics.last.Time = metrics.last.Time.Add(-2 * time.Second)
+	writeMetricsProc("cpu  300 0 300 1000 100 0 0 0 0 0", "", "")
+	res, err = metrics.Collect()
+	if err != nil || res.Exist("cpu") || res.FloatVal("proc_nginx_cpu") != 0 {
+		t.Errorf("%v,%v", res, err)
+		return
+	}
+	//loop
+	metrics.Interval = 10 * time.Millisecond
+	if err = metrics.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = metrics.Start(); err == nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(50 * time.Millisecond)
+	metrics.Stop()
+	fmt.Printf("%v\n", metrics)
+	//error
+	os.Remove("/tmp/fsck_metrics/uptime")
+	if _, err = metrics.Collect(); err == nil {
+		t.Error(err)
+		return
+	}
+	ioutil.WriteFile("/tmp/fsck_metrics/stat", []byte("xx"), os.ModePerm)
+	if _, err = metrics.Collect(); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = metrics.Start(); err == nil {
+		t.Error(err)
+		return
+	}
+	os.Remove("/tmp/fsck_metrics/meminfo")
+	if _, err = readMetricsSample("/tmp/fsck_metrics"); err == nil {
+		t.Error(err)
+		return
+	}
+	os.Remove("/tmp/fsck_metrics/loadavg")
+	if _, err = readMetricsSample("/tmp/fsck_metrics"); err == nil {
+		t.Error(err)
+		return
+	}
+	os.Remove("/tmp/fsck_metrics/stat")
+	if _, err = readMetricsSample("/tmp/fsck_metrics"); err == nil {
+		t.Error(err)
+		return
+	}
+	//real host
+	if _, err = readMetricsSample("/proc"); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,489 @@
+package fsck
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+)
+
+//the plugin frame type.
+const (
+	PluginDial   = 'D' //dial to uri, the data is uri, slaver->plugin.
+	PluginAccept = 'A' //dial success, plugin->slaver.
+	PluginError  = 'E' //dial fail or connection error, the data is error message, plugin->slaver.
+	PluginData   = 'W' //the connection data, both.
+	PluginClose  = 'C' //close the connection, both.
+)
+
+//PluginMaxFrame is the max data bytes of one plugin frame, the larger data is split when writing and rejected when reading.
+var PluginMaxFrame = 4 * 1024 * 1024
+
+//PluginMaxBuffer is the max received data bytes to buffer on one plugin connection,
+//the plugin process read loop is blocked until the buffer is readed when it is exceeded.
+var PluginMaxBuffer = 8 * 1024 * 1024
+
+//PluginFrame is the frame of plugin protocol, it is encoded as [type:1][cid:2][length:4][data].
+type PluginFrame struct {
+	Type byte
+	CID  uint16
+	Data []byte
+}
+
+//WritePluginFrame will write the frame to writer.
+func WritePluginFrame(w io.Writer, frame *PluginFrame) (err error) {
+	buf := make([]byte, 7+len(frame.Data))
+	buf[0] = frame.Type
+	binary.BigEndian.PutUint16(buf[1:], frame.CID)
+	binary.BigEndian.PutUint32(buf[3:], uint32(len(frame.Data)))
+	copy(buf[7:], frame.Data)
+	_, err = w.Write(buf)
+	return
+}
+
+//ReadPluginFrame will read one frame from reader.
+func ReadPluginFrame(r io.Reader) (frame *PluginFrame, err error) {
+	header := make([]byte, 7)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(header[3:])
+	if length > uint32(PluginMaxFrame) {
+		err = fmt.Errorf("plugin frame length %v is greater than %v", length, PluginMaxFrame)
+		return
+	}
+	frame = &PluginFrame{
+		Type: header[0],
+		CID:  binary.BigEndian.Uint16(header[1:]),
+		Data: make([]byte, length),
+	}
+	_, err = io.ReadFull(r, frame.Data)
+	return
+}
+
+//PluginConn is the connection multiplexed on plugin process, the received data is buffered on connection up to PluginMaxBuffer,
+//so the slow reader will not block the other connections on the same process until its buffer is full.
+type PluginConn struct {
+	CID     uint16
+	process *PluginProcess
+	buf     bytes.Buffer
+	cond    *sync.Cond
+	dialed  chan error
+	closed  bool
+	lck     sync.Mutex
+	OnClose func()
+}
+
+func newPluginConn(cid uint16, process *PluginProcess) (conn *PluginConn) {
+	conn = &PluginConn{
+		CID:     cid,
+		process: process,
+		dialed:  make(chan error, 1),
+	}
+	conn.cond = sync.NewCond(&conn.lck)
+	return
+}
+
+//Read will read the buffered data, it return io.EOF after all data is readed when connection is closed.
+func (p *PluginConn) Read(b []byte) (n int, err error) {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	for p.buf.Len() < 1 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.buf.Len() > 0 {
+		n, err = p.buf.Read(b)
+		p.cond.Broadcast()
+	} else {
+		err = io.EOF
+	}
+	return
+}
+
+//push will append data to buffer, it wait the buffer is readed when buffer is full.
+func (p *PluginConn) push(data []byte) {
+	p.lck.Lock()
+	for p.buf.Len() >= PluginMaxBuffer && !p.closed {
+		p.cond.Wait()
+	}
+	if !p.closed {
+		p.buf.Write(data)
+		p.cond.Broadcast()
+	}
+	p.lck.Unlock()
+}
+
+func (p *PluginConn) Write(b []byte) (n int, err error) {
+	for n < len(b) && err == nil {
+		size := len(b) - n
+		if size > PluginMaxFrame {
+			size = PluginMaxFrame
+		}
+		err = p.process.write(&PluginFrame{Type: PluginData, CID: p.CID, Data: b[n : n+size]})
+		if err == nil {
+			n += size
+		}
+	}
+	return
+}
+
+//Close will close the connection and notify plugin.
+func (p *PluginConn) Close() error {
+	if p.close(fmt.Errorf("closed")) {
+		p.process.write(&PluginFrame{Type: PluginClose, CID: p.CID})
+	}
+	return nil
+}
+
+func (p *PluginConn) close(err error) bool {
+	p.lck.Lock()
+	if p.closed {
+		p.lck.Unlock()
+		return false
+	}
+	p.closed = true
+	p.cond.Broadcast()
+	p.lck.Unlock()
+	p.process.remove(p.CID)
+	select {
+	case p.dialed <- err:
+	default:
+	}
+	if p.OnClose != nil {
+		p.OnClose()
+	}
+	return true
+}
+
+//PluginProcess is the running plugin process, all connections is multiplexed on process stdin/stdout.
+type PluginProcess struct {
+	Name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	wlck   sync.Mutex
+	conns  map[uint16]*PluginConn
+	lck    sync.RWMutex
+	exited bool
+}
+
+//StartPluginProcess will start the plugin process by executable path, extra environment and args, it return nil process when start fail.
+func StartPluginProcess(name, path string, env []string, args ...string) (process *PluginProcess, err error) {
+	defer func() {
+		if err != nil {
+			process = nil
+		}
+	}()
+	process = &PluginProcess{
+		Name:  name,
+		cmd:   exec.Command(path, args...),
+		conns: map[uint16]*PluginConn{},
+	}
+	process.cmd.Env = append(os.Environ(), env...)
+	process.cmd.Stderr = os.Stderr
+	process.stdin, err = process.cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := process.cmd.StdoutPipe()
+	if err != nil {
+		process.stdin.Close()
+		return
+	}
+	err = process.cmd.Start()
+	if err != nil {
+		return
+	}
+	go process.loop(stdout)
+	return
+}
+
+func (p *PluginProcess) loop(stdout io.Reader) {
+	var err error
+	var frame *PluginFrame
+	for {
+		frame, err = ReadPluginFrame(stdout)
+		if err != nil {
+			break
+		}
+		p.lck.RLock()
+		conn := p.conns[frame.CID]
+		p.lck.RUnlock()
+		if conn == nil {
+			if frame.Type == PluginData {
+				p.write(&PluginFrame{Type: PluginClose, CID: frame.CID})
+			}
+			continue
+		}
+		switch frame.Type {
+		case PluginAccept:
+			select {
+			case conn.dialed <- nil:
+			default:
+			}
+		case PluginData:
+			conn.push(frame.Data)
+		case PluginError:
+			conn.close(fmt.Errorf("%s", frame.Data))
+		case PluginClose:
+			conn.close(io.EOF)
+		}
+	}
+	log.D("Plugin(%v) read loop is done by %v", p.Name, err)
+	p.lck.Lock()
+	p.exited = true
+	conns := []*PluginConn{}
+	for _, conn := range p.conns {
+		conns = append(conns, conn)
+	}
+	p.lck.Unlock()
+	for _, conn := range conns {
+		conn.close(fmt.Errorf("plugin(%v) is exited", p.Name))
+	}
+	p.stdin.Close()
+	p.cmd.Wait()
+}
+
+func (p *PluginProcess) write(frame *PluginFrame) (err error) {
+	p.wlck.Lock()
+	defer p.wlck.Unlock()
+	return WritePluginFrame(p.stdin, frame)
+}
+
+func (p *PluginProcess) remove(cid uint16) {
+	p.lck.Lock()
+	delete(p.conns, cid)
+	p.lck.Unlock()
+}
+
+//Exited return whether the plugin process is exited.
+func (p *PluginProcess) Exited() bool {
+	p.lck.RLock()
+	defer p.lck.RUnlock()
+	return p.exited
+}
+
+//Dial will dial to uri by plugin and wait the plugin accept it until timeout.
+func (p *PluginProcess) Dial(cid uint16, uri string, timeout time.Duration) (conn *PluginConn, err error) {
+	conn = newPluginConn(cid, p)
+	p.lck.Lock()
+	if p.exited {
+		p.lck.Unlock()
+		err = fmt.Errorf("plugin(%v) is exited", p.Name)
+		return
+	}
+	if _, ok := p.conns[cid]; ok {
+		p.lck.Unlock()
+		err = fmt.Errorf("plugin(%v) connection(%v) is exists", p.Name, cid)
+		return
+	}
+	p.conns[cid] = conn
+	p.lck.Unlock()
+	err = p.write(&PluginFrame{Type: PluginDial, CID: cid, Data: []byte(uri)})
+	if err == nil {
+		select {
+		case err = <-conn.dialed:
+		case <-time.After(timeout):
+			err = fmt.Errorf("plugin(%v) dial to %v timeout", p.Name, uri)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		conn = nil
+	}
+	return
+}
+
+//Kill will kill the plugin process.
+func (p *PluginProcess) Kill() error {
+	p.stdin.Close()
+	return p.cmd.Process.Kill()
+}
+
+//PluginDialer is the dialer to dial uri by external plugin executable.
+//
+//the plugin is spawned per dial, or kept resident and multiplexed when Resident is true,
+//it read the PluginFrame from stdin and write the PluginFrame to stdout.
+type PluginDialer struct {
+	Scheme   string        `json:"scheme"`
+	Path     string        `json:"path"`
+	Args     []string      `json:"args"`
+	Env      []string      `json:"env"`
+	Resident bool          `json:"resident"`
+	Timeout  time.Duration `json:"-"`
+	process  *PluginProcess
+	lck      sync.Mutex
+}
+
+//NewPluginDialer will return new plugin dialer.
+func NewPluginDialer(scheme, path string, resident bool, args ...string) *PluginDialer {
+	return &PluginDialer{
+		Scheme:   scheme,
+		Path:     path,
+		Args:     args,
+		Resident: resident,
+		Timeout:  10 * time.Second,
+	}
+}
+
+//LoadPluginDialers will load the plugin dialer from json file, the configure is mapping uri scheme to plugin, like
+//	{"k8s":{"path":"/usr/local/bin/k8s-shim","args":[],"env":["K=V"],"resident":true}}
+func LoadPluginDialers(filename string) (dialers []*PluginDialer, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	plugins := map[string]*PluginDialer{}
+	err = json.Unmarshal(data, &plugins)
+	if err != nil {
+		return
+	}
+	for scheme, plugin := range plugins {
+		if len(plugin.Path) < 1 {
+			err = fmt.Errorf("the plugin(%v) path is required", scheme)
+			return
+		}
+		dialer := NewPluginDialer(scheme, plugin.Path, plugin.Resident, plugin.Args...)
+		dialer.Env = plugin.Env
+		dialers = append(dialers, dialer)
+	}
+	return
+}
+
+func (p *PluginDialer) Bootstrap() error {
+	return nil
+}
+
+func (p *PluginDialer) Matched(uri string) bool {
+	return strings.HasPrefix(uri, p.Scheme+"://")
+}
+
+func (p *PluginDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err error) {
+	var process *PluginProcess
+	if p.Resident {
+		p.lck.Lock()
+		if p.process == nil || p.process.Exited() {
+			log.D("PluginDialer(%v) start resident plugin by %v", p.Scheme, p.Path)
+			p.process, err = StartPluginProcess(p.Scheme, p.Path, p.Env, p.Args...)
+			if err != nil {
+				log.W("PluginDialer(%v) start resident plugin by %v fail with %v", p.Scheme, p.Path, err)
+			}
+		}
+		process = p.process
+		p.lck.Unlock()
+	} else {
+		process, err = StartPluginProcess(p.Scheme, p.Path, p.Env, p.Args...)
+	}
+	if err != nil {
+		return
+	}
+	conn, err := process.Dial(cid, uri, p.Timeout)
+	if !p.Resident {
+		if err != nil {
+			process.Kill()
+			return
+		}
+		conn.OnClose = func() {
+			process.Kill()
+		}
+	}
+	if err == nil {
+		raw = conn
+	}
+	return
+}
+
+//Shutdown will kill the resident plugin process.
+func (p *PluginDialer) Shutdown() error {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	if p.process != nil {
+		p.process.Kill()
+		p.process = nil
+	}
+	return nil
+}
+
+func (p *PluginDialer) String() string {
+	return fmt.Sprintf("PluginDialer(%v)", p.Scheme)
+}
+
+//ServePlugin will serve the plugin protocol on in/out, it is used to implement the plugin by golang.
+func ServePlugin(in io.Reader, out io.Writer, dial func(uri string) (io.ReadWriteCloser, error)) (err error) {
+	wlck := sync.Mutex{}
+	write := func(frame *PluginFrame) error {
+		wlck.Lock()
+		defer wlck.Unlock()
+		return WritePluginFrame(out, frame)
+	}
+	conns := map[uint16]io.ReadWriteCloser{}
+	lck := sync.RWMutex{}
+	var frame *PluginFrame
+	for {
+		frame, err = ReadPluginFrame(in)
+		if err != nil {
+			break
+		}
+		lck.RLock()
+		conn := conns[frame.CID]
+		lck.RUnlock()
+		switch frame.Type {
+		case PluginDial:
+			raw, derr := dial(string(frame.Data))
+			if derr != nil {
+				write(&PluginFrame{Type: PluginError, CID: frame.CID, Data: []byte(derr.Error())})
+				continue
+			}
+			lck.Lock()
+			conns[frame.CID] = raw
+			lck.Unlock()
+			write(&PluginFrame{Type: PluginAccept, CID: frame.CID})
+			go func(cid uint16) {
+				buf := make([]byte, 32*1024)
+				for {
+					n, rerr := raw.Read(buf)
+					if n > 0 {
+						write(&PluginFrame{Type: PluginData, CID: cid, Data: buf[:n]})
+					}
+					if rerr != nil {
+						break
+					}
+				}
+				lck.Lock()
+				_, running := conns[cid]
+				delete(conns, cid)
+				lck.Unlock()
+				if running {
+					write(&PluginFrame{Type: PluginClose, CID: cid})
+				}
+				raw.Close()
+			}(frame.CID)
+		case PluginData:
+			if conn != nil {
+				conn.Write(frame.Data)
+			}
+		case PluginClose:
+			if conn != nil {
+				lck.Lock()
+				delete(conns, frame.CID)
+				lck.Unlock()
+				conn.Close()
+			}
+		}
+	}
+	lck.Lock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	lck.Unlock()
+	return
+}
@@ -0,0 +1,246 @@
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("FSCK_PLUGIN_HELPER") != "1" {
+		return
+	}
+	ServePlugin(os.Stdin, os.Stdout, func(uri string) (io.ReadWriteCloser, error) {
+		if strings.Contains(uri, "fail") {
+			return nil, fmt.Errorf("dial fail")
+		}
+		return NewEchoReadWriteCloser(), nil
+	})
+	os.Exit(0)
+}
+
+func newTestPluginDialer(resident bool) *PluginDialer {
+	dialer := NewPluginDialer("echo", os.Args[0], resident, "-test.run=TestPluginHelperProcess")
+	dialer.Env = []string{"FSCK_PLUGIN_HELPER=1"}
+	return dialer
+}
+
+func testPluginEcho(dialer *PluginDialer, cid uint16) (err error) {
+	raw, err := dialer.Dial(cid, fmt.Sprintf("echo://x%v", cid))
+	if err != nil {
+		return
+	}
+	defer raw.Close()
+	data := []byte(fmt.Sprintf("data-%v", cid))
+	_, err = raw.Write(data)
+	if err != nil {
+		return
+	}
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(raw, buf)
+	if err == nil && !bytes.Equal(buf, data) {
+		err = fmt.Errorf("expect %s, but %s", data, buf)
+	}
+	return
+}
+
+func TestPluginDialer(t *testing.T) {
+	for _, resident := range []bool{false, true} {
+		dialer := newTestPluginDialer(resident)
+		if !dialer.Matched("echo://xx") || dialer.Matched("tcp://xx") {
+			t.Error("error")
+			return
+		}
+		done := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			go func(cid uint16) {
+				done <- testPluginEcho(dialer, cid)
+			}(uint16(i + 1))
+		}
+		for i := 0; i < 3; i++ {
+			if err := <-done; err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		_, err := dialer.Dial(10, "echo://fail")
+		if err == nil || err.Error() != "dial fail" {
+			t.Error(err)
+			return
+		}
+		dialer.Shutdown()
+	}
+	//
+	//resident restart
+	dialer := newTestPluginDialer(true)
+	if err := testPluginEcho(dialer, 1); err != nil {
+		t.Error(err)
+		return
+	}
+	raw, _ := dialer.Dial(2, "echo://x")
+	dialer.process.Kill()
+	ioutil.ReadAll(raw)
+	time.Sleep(100 * time.Millisecond)
+	if err := testPluginEcho(dialer, 3); err != nil {
+		t.Error(err)
+		return
+	}
+	dialer.Shutdown()
+	//
+	//timeout
+	dialer = NewPluginDialer("x", "sleep", false, "10")
+	dialer.Timeout = 100 * time.Millisecond
+	if _, err := dialer.Dial(1, "x://x"); err == nil {
+		t.Error("error")
+		return
+	}
+	dialer = NewPluginDialer("x", "/not/exist", false)
+	if _, err := dialer.Dial(1, "x://x"); err == nil {
+		t.Error("error")
+		return
+	}
+	//
+	//resident start fail is not kept
+	dialer = NewPluginDialer("echo", "/not/exist", true)
+	if _, err := dialer.Dial(1, "echo://x"); err == nil || dialer.process != nil {
+		t.Error(err)
+		return
+	}
+	dialer.Path, dialer.Args, dialer.Env = os.Args[0], []string{"-test.run=TestPluginHelperProcess"}, []string{"FSCK_PLUGIN_HELPER=1"}
+	if err := testPluginEcho(dialer, 2); err != nil {
+		t.Error(err)
+		return
+	}
+	dialer.Shutdown()
+}
+
+func TestPluginLimit(t *testing.T) {
+	//frame is too large
+	header := []byte{PluginData, 0, 1, 0xFF, 0xFF, 0xFF, 0xFF}
+	if _, err := ReadPluginFrame(bytes.NewBuffer(header)); err == nil {
+		t.Error("error")
+		return
+	}
+	//push is blocked when buffer is full
+	oldMax := PluginMaxBuffer
+	PluginMaxBuffer = 1024
+	defer func() { PluginMaxBuffer = oldMax }()
+	conn := newPluginConn(1, nil)
+	pushed := make(chan int, 1)
+	go func() {
+		conn.push(make([]byte, 1024))
+		conn.push(make([]byte, 1024))
+		pushed <- 1
+	}()
+	select {
+	case <-pushed:
+		t.Error("not blocked")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n, err := io.ReadFull(conn, make([]byte, 2048)); err != nil || n != 2048 {
+		t.Error(err)
+		return
+	}
+	<-pushed
+}
+
+func TestLoadPluginDialers(t *testing.T) {
+	ioutil.WriteFile("/tmp/fsck_plugins.json", []byte(`{"k8s":{"path":"/usr/bin/k8s","resident":true,"args":["-v"],"env":["A=1"]}}`), os.ModePerm)
+	defer os.Remove("/tmp/fsck_plugins.json")
+	dialers, err := LoadPluginDialers("/tmp/fsck_plugins.json")
+	if err != nil || len(dialers) != 1 || dialers[0].Scheme != "k8s" || !dialers[0].Resident || dialers[0].Args[0] != "-v" || dialers[0].Env[0] != "A=1" {
+		t.Error(err)
+		return
+	}
+	ioutil.WriteFile("/tmp/fsck_plugins.json", []byte(`{"k8s":{}}`), os.ModePerm)
+	if _, err = LoadPluginDialers("/tmp/fsck_plugins.json"); err == nil {
+		t.Error("error")
+		return
+	}
+	ioutil.WriteFile("/tmp/fsck_plugins.json", []byte(`{`), os.ModePerm)
+	if _, err = LoadPluginDialers("/tmp/fsck_plugins.json"); err == nil {
+		t.Error("error")
+		return
+	}
+}
+
+type testPluginOut chan []byte
+
+func (t testPluginOut) Write(p []byte) (n int, err error) {
+	t <- append([]byte{}, p...)
+	n = len(p)
+	return
+}
+
+func TestPluginSessionPool(t *testing.T) {
+	pool := NewSessionPool()
+	pool.RegisterDefaulDialer()
+	dialer := newTestPluginDialer(true)
+	defer dialer.Shutdown()
+	pool.InsertDialer(dialer)
+	if _, ok := pool.Dialers[len(pool.Dialers)-1].(*TCPDialer); !ok {
+		t.Error("error")
+		return
+	}
+	out := make(testPluginOut, 10)
+	_, err := pool.Dial(1, "echo://x1", out)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = pool.Write(append([]byte{0, 0, 1}, []byte("abc")...))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case data := <-out:
+		if string(data[3:]) != "abc" {
+			t.Errorf("error:%v", data)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timeout")
+		return
+	}
+	pool.Close()
+}
+
+func TestPluginSlowReader(t *testing.T) {
+	dialer := newTestPluginDialer(true)
+	defer dialer.Shutdown()
+	slow, err := dialer.Dial(1, "echo://slow")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer slow.Close()
+	for i := 0; i < 64; i++ {
+		slow.Write(make([]byte, 16*1024))
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- testPluginEcho(dialer, 2)
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("blocked by slow reader")
+		return
+	}
+	buf := make([]byte, 1024*1024)
+	if _, err = io.ReadFull(slow, buf); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,358 @@
+package fsck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//PolicyRule is the rule to match the dial uri, the empty field is matched all.
+//
+//	scheme  the uri scheme, like tcp/http/https
+//	host    the host pattern, like *.local/10.0.0.1
+//	cidr    the ip network, like 10.0.0.0/8, the host is resolved when it is not ip
+//	port    the port list or range, like 22,80,8000-9000
+type PolicyRule struct {
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+	CIDR   string `json:"cidr"`
+	Port   string `json:"port"`
+	ipnet  *net.IPNet
+	ports  [][2]int
+}
+
+func (p *PolicyRule) String() string {
+	return fmt.Sprintf("scheme(%v),host(%v),cidr(%v),port(%v)", p.Scheme, p.Host, p.CIDR, p.Port)
+}
+
+func (p *PolicyRule) compile() (err error) {
+	if len(p.CIDR) > 0 {
+		_, p.ipnet, err = net.ParseCIDR(p.CIDR)
+		if err != nil {
+			return
+		}
+	}
+	p.ports = nil
+	for _, part := range strings.Split(p.Port, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) < 1 {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		var begin, end int
+		begin, err = strconv.Atoi(bounds[0])
+		if err != nil {
+			return
+		}
+		end = begin
+		if len(bounds) > 1 {
+			end, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return
+			}
+		}
+		p.ports = append(p.ports, [2]int{begin, end})
+	}
+	return
+}
+
+//Match will check whether the target is matched, when all is true, all resolved ips must be in cidr, else any ip in cidr is matched.
+func (p *PolicyRule) Match(scheme, host string, port int, ips []net.IP, all bool) bool {
+	if len(p.Scheme) > 0 && p.Scheme != scheme {
+		return false
+	}
+	if len(p.Host) > 0 {
+		if matched, _ := path.Match(p.Host, host); !matched {
+			return false
+		}
+	}
+	if len(p.ports) > 0 {
+		matched := false
+		for _, bound := range p.ports {
+			if port >= bound[0] && port <= bound[1] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if p.ipnet != nil {
+		if len(ips) < 1 {
+			return false
+		}
+		for _, ip := range ips {
+			contained := p.ipnet.Contains(ip)
+			if all && !contained {
+				return false
+			}
+			if !all && contained {
+				return true
+			}
+		}
+		return all
+	}
+	return true
+}
+
+//DialPolicy is the slaver local policy to limit the dial uri from master, it is checked before any dialer running.
+//
+//the deny rules is checked first, then the uri must be matched one of allow rules when allow rules is not empty.
+type DialPolicy struct {
+	Cmd       bool          `json:"cmd"`        //whether tcp://cmd is enabled.
+	Webdav    bool          `json:"webdav"`     //whether webdav is enabled.
+	WebdavDir []string      `json:"webdav_dir"` //the allowed webdav dir, empty is allowed all.
+	File      bool          `json:"file"`       //whether file:// transfer is enabled.
+	FileDir   []string      `json:"file_dir"`   //the allowed file transfer dir, empty is allowed all.
+	Tail      bool          `json:"tail"`       //whether tail:// is enabled.
+	TailDir   []string      `json:"tail_dir"`   //the allowed tail dir, empty is allowed all.
+	Proc      bool          `json:"proc"`       //whether proc:// is enabled.
+	Allow     []*PolicyRule `json:"allow"`
+	Deny      []*PolicyRule `json:"deny"`
+	//the resolver to lookup host ip, default is net.LookupIP.
+	LookupIP func(host string) ([]net.IP, error) `json:"-"`
+}
+
+//LoadDialPolicy will load the dial policy from json file.
+func LoadDialPolicy(filename string) (policy *DialPolicy, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	policy = &DialPolicy{}
+	err = json.Unmarshal(data, policy)
+	if err == nil {
+		err = policy.Compile()
+	}
+	return
+}
+
+//Compile will compile all rules, it must be called before check when policy is not loaded by LoadDialPolicy.
+func (d *DialPolicy) Compile() (err error) {
+	for _, rule := range append(append([]*PolicyRule{}, d.Allow...), d.Deny...) {
+		err = rule.compile()
+		if err != nil {
+			err = fmt.Errorf("compile rule %v fail with %v", rule, err)
+			return
+		}
+	}
+	if d.LookupIP == nil {
+		d.LookupIP = net.LookupIP
+	}
+	return
+}
+
+//Check will check whether the uri is allowed by policy.
+func (d *DialPolicy) Check(uri string) (err error) {
+	_, err = d.Pin(uri)
+	return
+}
+
+//Pin will check whether the uri is allowed by policy, and return the uri which host is replaced by the checked ip
+//when the host is resolved by policy, so the dialer will not resolve the host again to other ip.
+//the resolver option is denied and the host which can't be resolved is denied when the policy need ip to check.
+func (d *DialPolicy) Pin(uri string) (pinned string, err error) {
+	pinned = uri
+	if uri == "echo" {
+		return
+	}
+	if strings.HasPrefix(uri, "tcp://cmd") {
+		if !d.Cmd {
+			err = fmt.Errorf("dial %v is denied by policy, cmd is not enabled", uri)
+		}
+		return
+	}
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(uri, "http://web") {
+		err = d.checkDir(uri, "webdav", d.Webdav, d.WebdavDir, remote.Query().Get("dir"))
+		return
+	}
+	if remote.Scheme == "file" {
+		err = d.checkDir(uri, "file", d.File, d.FileDir, filepath.FromSlash(remote.Path))
+		return
+	}
+	if remote.Scheme == "proc" {
+		if !d.Proc {
+			err = fmt.Errorf("dial %v is denied by policy, proc is not enabled", uri)
+		}
+		return
+	}
+	if remote.Scheme == "tail" {
+		err = d.checkDir(uri, "tail", d.Tail, d.TailDir, filepath.FromSlash(remote.Path))
+		return
+	}
+	if remote.Scheme == "real" { //the realtime log is always readable by real_log
+		return
+	}
+	if len(remote.Query().Get("resolver")) > 0 {
+		err = fmt.Errorf("dial %v is denied by policy, resolver is not allowed", uri)
+		return
+	}
+	host, sport, serr := net.SplitHostPort(remote.Host)
+	if serr != nil {
+		host = strings.Trim(remote.Host, "[]")
+		switch remote.Scheme {
+		case "http":
+			sport = "80"
+		case "https":
+			sport = "443"
+		}
+	}
+	host = strings.ToLower(host)
+	port, _ := strconv.Atoi(sport)
+	ips := []net.IP{}
+	resolved := false
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else if d.hasCIDR() {
+		ips, err = d.LookupIP(host)
+		if err == nil && len(ips) < 1 {
+			err = fmt.Errorf("no ip found")
+		}
+		if err != nil {
+			err = fmt.Errorf("dial %v is denied by policy, lookup host(%v) fail with %v", uri, host, err)
+			return
+		}
+		resolved = true
+	}
+	for _, rule := range d.Deny {
+		if rule.Match(remote.Scheme, host, port, ips, false) {
+			err = fmt.Errorf("dial %v is denied by policy rule %v", uri, rule)
+			return
+		}
+	}
+	allowed := len(d.Allow) < 1
+	for _, rule := range d.Allow {
+		if rule.Match(remote.Scheme, host, port, ips, true) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		err = fmt.Errorf("dial %v is not allowed by policy", uri)
+		return
+	}
+	if resolved {
+		pinned = pinURI(remote, ips, sport)
+	}
+	return
+}
+
+//pinURI will replace the host of tcp/udp/http/https uri by the ip matched the family option.
+func pinURI(remote *url.URL, ips []net.IP, port string) string {
+	switch strings.TrimRight(remote.Scheme, "46") {
+	case "tcp", "udp", "http", "https":
+	default:
+		return remote.String()
+	}
+	family := remote.Query().Get("family")
+	for _, ip := range ips {
+		if (family == "4" && ip.To4() == nil) || (family == "6" && ip.To4() != nil) {
+			continue
+		}
+		host := ip.String()
+		if len(port) > 0 {
+			host = net.JoinHostPort(host, port)
+		} else if ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		pinned := *remote
+		pinned.Host = host
+		return pinned.String()
+	}
+	return remote.String()
+}
+
+func (d *DialPolicy) checkDir(uri, name string, enabled bool, allowedDir []string, dir string) (err error) {
+	if !enabled {
+		err = fmt.Errorf("dial %v is denied by policy, %v is not enabled", uri, name)
+		return
+	}
+	if len(allowedDir) < 1 {
+		return
+	}
+	targets := []string{dir}
+	if strings.ContainsAny(dir, "*?[") {
+		matched, _ := filepath.Glob(dir)
+		targets = append(targets, matched...)
+	}
+	for _, target := range targets {
+		err = checkAllowedDir(uri, allowedDir, target)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func checkAllowedDir(uri string, allowedDir []string, dir string) (err error) {
+	real, err := realPath(dir)
+	if err != nil {
+		err = fmt.Errorf("dial %v is denied by policy, resolve dir(%v) fail with %v", uri, dir, err)
+		return
+	}
+	for _, allowed := range allowedDir {
+		allowedReal, rerr := realPath(allowed)
+		if rerr != nil {
+			allowedReal = filepath.Clean(allowed)
+		}
+		if real == allowedReal || strings.HasPrefix(real, strings.TrimSuffix(allowedReal, string(filepath.Separator))+string(filepath.Separator)) {
+			return
+		}
+	}
+	err = fmt.Errorf("dial %v is denied by policy, dir(%v) is not allowed", uri, dir)
+	return
+}
+
+//realPath return the absolute path with symlink resolved, the nearest exists parent is resolved when path is not exists,
+//and the dangling symlink is resolved by its target.
+func realPath(path string) (real string, err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	var tail string
+	for {
+		real, err = filepath.EvalSymlinks(path)
+		if err == nil {
+			real = filepath.Join(real, tail)
+			return
+		}
+		if !os.IsNotExist(err) {
+			return
+		}
+		if link, lerr := os.Readlink(path); lerr == nil {
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(path), link)
+			}
+			path = filepath.Clean(link)
+			continue
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return
+		}
+		tail = filepath.Join(filepath.Base(path), tail)
+		path = parent
+	}
+}
+
+func (d *DialPolicy) hasCIDR() bool {
+	for _, rule := range append(append([]*PolicyRule{}, d.Allow...), d.Deny...) {
+		if rule.ipnet != nil {
+			return true
+		}
+	}
+	return false
+}
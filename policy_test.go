@@ -0,0 +1,169 @@
+package fsck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestDialPolicy(t *testing.T) {
+	ioutil.WriteFile("/tmp/fsck_policy.json", []byte(`{
+		"cmd": false,
+		"webdav": true,
+		"webdav_dir": ["/tmp/dav"],
+		"file": true,
+		"file_dir": ["/tmp/file"],
+		"tail": true,
+		"tail_dir": ["/var/log"],
+		"allow": [
+			{"scheme": "tcp", "cidr": "10.0.0.0/8", "port": "22,8000-9000"},
+			{"scheme": "http", "host": "*.local"}
+		],
+		"deny": [
+			{"cidr": "10.0.1.0/24"},
+			{"host": "bad.local"}
+		]
+	}`), os.ModePerm)
+	defer os.Remove("/tmp/fsck_policy.json")
+	policy, err := LoadDialPolicy("/tmp/fsck_policy.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	policy.LookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "in.lan":
+			return []net.IP{net.ParseIP("10.0.0.2")}, nil
+		case "mix.lan":
+			return []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("192.168.1.1")}, nil
+		case "denied.lan":
+			return []net.IP{net.ParseIP("10.0.0.3"), net.ParseIP("10.0.1.3")}, nil
+		case "a.local", "bad.local":
+			return []net.IP{net.ParseIP("192.168.2.1")}, nil
+		case "empty.local":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("not found")
+	}
+	for _, uri := range []string{
+		"echo",
+		"tcp://10.0.0.1:22",
+		"tcp://10.0.0.1:8080",
+		"tcp://in.lan:22",
+		"http://a.local",
+		"http://A.LOCAL:8080/x",
+		"http://web?dir=/tmp/dav",
+		"http://web?dir=/tmp/dav/x",
+		"file:///tmp/file/a.txt?op=stat",
+		"tail:///var/log/*.log?lines=10",
+		"real://stream?host=h1&cpu=avg",
+	} {
+		if err = policy.Check(uri); err != nil {
+			t.Errorf("%v:%v", uri, err)
+			return
+		}
+	}
+	for _, uri := range []string{
+		"tcp://cmd?exec=ls",
+		"tcp://10.0.0.1:23",
+		"tcp://10.0.1.1:22",
+		"tcp://192.168.1.1:22",
+		"tcp://mix.lan:22",
+		"tcp://denied.lan:22",
+		"tcp://unknown.lan:22",
+		"http://unknown.local",
+		"http://empty.local",
+		"tcp://10.0.0.1:22?resolver=8.8.8.8",
+		"tcp://in.lan:22?resolver=8.8.8.8",
+		"http://bad.local",
+		"https://a.local",
+		"http://web?dir=/tmp/davx",
+		"http://web?dir=/tmp/dav/../x",
+		"file:///etc/passwd?op=get",
+		"file:///tmp/file/../x?op=get",
+		"tail:///etc/passwd",
+		"tail:///var/log/../../etc/passwd",
+		"proc://kill?pid=1",
+	} {
+		if err = policy.Check(uri); err == nil {
+			t.Errorf("%v:not denied", uri)
+			return
+		}
+		fmt.Println(err)
+	}
+	//symlink out of allowed dir
+	os.RemoveAll("/tmp/file")
+	os.MkdirAll("/tmp/file/sub", os.ModePerm)
+	defer os.RemoveAll("/tmp/file")
+	os.Symlink("/etc", "/tmp/file/etc")
+	os.Symlink("/etc/passwd", "/tmp/file/passwd")
+	os.Symlink("/tmp/not_exist_dir/x", "/tmp/file/dangling")
+	os.Symlink("sub", "/tmp/file/link")
+	policy.TailDir = append(policy.TailDir, "/tmp/file")
+	for uri, allowed := range map[string]bool{
+		"file:///tmp/file/sub/new.txt?op=put":    true,
+		"file:///tmp/file/link/a.txt?op=get":     true,
+		"file:///tmp/file/new/a.txt?op=put":      true,
+		"file:///tmp/file/etc/passwd?op=get":     false,
+		"file:///tmp/file/passwd?op=get":         false,
+		"file:///tmp/file/etc/new/a.txt?op=put":  false,
+		"file:///tmp/file/dangling?op=put":       false,
+		"file:///tmp/file/dangling/a.txt?op=put": false,
+		"http://web?dir=/tmp/dav/../file/etc":    false,
+		"tail:///tmp/file/*":                     false,
+		"tail:///tmp/file/sub/*.log":             true,
+	} {
+		if err = policy.Check(uri); (err == nil) != allowed {
+			t.Errorf("%v:%v", uri, err)
+			return
+		}
+	}
+	//pin
+	for uri, expect := range map[string]string{
+		"tcp://in.lan:22":          "tcp://10.0.0.2:22",
+		"http://a.local/x":         "http://192.168.2.1:80/x",
+		"http://A.LOCAL:8080/x":    "http://192.168.2.1:8080/x",
+		"tcp://10.0.0.1:22":        "tcp://10.0.0.1:22",
+		"tcp://in.lan:22?family=6": "tcp://in.lan:22?family=6",
+	} {
+		pinned, err := policy.Pin(uri)
+		if err != nil || pinned != expect {
+			t.Errorf("%v:%v,%v", uri, pinned, err)
+			return
+		}
+	}
+	//
+	pool := NewSessionPool()
+	pool.Dialers = append(pool.Dialers, NewCmdDialer(), NewEchoDialer())
+	pool.Policy = policy
+	_, err = pool.Dial(1, "tcp://cmd?exec=ls", ioutil.Discard)
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	policy.Cmd = true
+	_, err = pool.Dial(2, "tcp://cmd?exec=ls", ioutil.Discard)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pool.Close()
+	//
+	//error
+	policy = &DialPolicy{Allow: []*PolicyRule{{Port: "xx"}}}
+	if policy.Compile() == nil {
+		t.Error("error")
+		return
+	}
+	policy = &DialPolicy{Deny: []*PolicyRule{{CIDR: "xx"}}}
+	if policy.Compile() == nil {
+		t.Error("error")
+		return
+	}
+	if _, err = LoadDialPolicy("/tmp/not_exist.json"); err == nil {
+		t.Error("error")
+		return
+	}
+}
@@ -0,0 +1,224 @@
+package fsck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//ProcInfo is the process info read from /proc.
+type ProcInfo struct {
+	PID     int     `json:"pid"`
+	PPID    int     `json:"ppid"`
+	User    string  `json:"user"`
+	Name    string  `json:"name"`
+	State   string  `json:"state"`
+	Cmdline string  `json:"cmdline"`
+	CPU     float64 `json:"cpu"`   //the average cpu usage percent since process started.
+	RSS     int64   `json:"rss"`   //the resident memory in bytes.
+	Start   int64   `json:"start"` //the start time in unix milliseconds.
+	cpuTime float64 //the total cpu time in seconds, it is used to calculate the cpu usage between two sample.
+}
+
+//ProcReply is the reply of ProcDialer, it is sent as one json line.
+type ProcReply struct {
+	Procs []*ProcInfo `json:"procs,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+//ProcFilter is the filter to list process.
+type ProcFilter struct {
+	Name  string //the process name or cmdline contains.
+	User  string //the process user.
+	Sort  string //sort by pid/cpu/rss, the cpu/rss is sorted by desc.
+	Limit int    //the max process count.
+}
+
+//Filter will filter and sort the process list.
+func (p *ProcFilter) Filter(procs []*ProcInfo) (filtered []*ProcInfo) {
+	filtered = []*ProcInfo{}
+	for _, proc := range procs {
+		if len(p.User) > 0 && proc.User != p.User {
+			continue
+		}
+		if len(p.Name) > 0 && !strings.Contains(proc.Name, p.Name) && !strings.Contains(proc.Cmdline, p.Name) {
+			continue
+		}
+		filtered = append(filtered, proc)
+	}
+	sort.Stable(&ProcSorter{Procs: filtered, By: p.Sort})
+	if p.Limit > 0 && len(filtered) > p.Limit {
+		filtered = filtered[:p.Limit]
+	}
+	return
+}
+
+//ProcSorter is the sorter to sort process by pid/cpu/rss.
+type ProcSorter struct {
+	Procs []*ProcInfo
+	By    string
+}
+
+func (p *ProcSorter) Len() int {
+	return len(p.Procs)
+}
+
+func (p *ProcSorter) Less(i, j int) bool {
+	switch p.By {
+	case "cpu":
+		return p.Procs[i].CPU > p.Procs[j].CPU
+	case "rss":
+		return p.Procs[i].RSS > p.Procs[j].RSS
+	default:
+		return p.Procs[i].PID < p.Procs[j].PID
+	}
+}
+
+func (p *ProcSorter) Swap(i, j int) {
+	p.Procs[i], p.Procs[j] = p.Procs[j], p.Procs[i]
+}
+
+//ProcDialer is the dialer to inspect and control process by proc:// uri, the reply is sent as one json line.
+//
+//	proc://list?name=nginx&user=root&sort=cpu&limit=20   list process filtered by name/user
+//	proc://kill?pid=100&signal=TERM                      send signal to process, default is TERM
+type ProcDialer struct {
+	Root string //the proc filesystem root, default is /proc.
+}
+
+//NewProcDialer will return new proc dialer.
+func NewProcDialer() *ProcDialer {
+	return &ProcDialer{
+		Root: "/proc",
+	}
+}
+
+func (p *ProcDialer) Bootstrap() error {
+	return nil
+}
+
+func (p *ProcDialer) Matched(uri string) bool {
+	return strings.HasPrefix(uri, "proc://")
+}
+
+func (p *ProcDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err error) {
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	local, raw := net.Pipe()
+	go func() {
+		reply := p.serve(remote)
+		if len(reply.Error) > 0 {
+			log.D("ProcDialer serve %v fail with %v", uri, reply.Error)
+		}
+		json.NewEncoder(local).Encode(reply)
+		local.Close()
+	}()
+	return
+}
+
+func (p *ProcDialer) serve(remote *url.URL) (reply *ProcReply) {
+	reply = &ProcReply{}
+	var err error
+	switch remote.Host {
+	case "list":
+		filter := &ProcFilter{}
+		err = util.ValidAttrF(`name,O|S,L:0;user,O|S,L:0;sort,O|S,O:pid~cpu~rss;limit,O|I,R:0`,
+			remote.Query().Get, true, &filter.Name, &filter.User, &filter.Sort, &filter.Limit)
+		if err == nil {
+			var procs []*ProcInfo
+			procs, err = listProcs(p.Root)
+			reply.Procs = filter.Filter(procs)
+		}
+	case "kill":
+		var pid int
+		var signal = "TERM"
+		err = util.ValidAttrF(`pid,R|I,R:1;signal,O|S,L:0`, remote.Query().Get, true, &pid, &signal)
+		if err == nil {
+			err = KillProc(pid, signal)
+		}
+	default:
+		err = fmt.Errorf("proc operation(%v) is not supported", remote.Host)
+	}
+	if err != nil {
+		reply = &ProcReply{Error: err.Error()}
+	}
+	return
+}
+
+func (p *ProcDialer) String() string {
+	return "ProcDialer"
+}
+
+//KillProc will send signal by name to process.
+func KillProc(pid int, signal string) (err error) {
+	if pid < 1 {
+		err = fmt.Errorf("invalid pid(%v)", pid)
+		return
+	}
+	sig, err := ParseSignal(signal)
+	if err != nil {
+		return
+	}
+	proc, err := os.FindProcess(pid)
+	if err == nil {
+		err = proc.Signal(sig)
+	}
+	return
+}
+
+func procCall(dial func(uri string) (raw io.ReadWriteCloser, err error), op string, args url.Values) (reply *ProcReply, err error) {
+	conn, err := dial("proc://" + op + "?" + args.Encode())
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reply = &ProcReply{}
+	err = json.NewDecoder(conn).Decode(reply)
+	if err == nil && len(reply.Error) > 0 {
+		err = fmt.Errorf("%v", reply.Error)
+	}
+	return
+}
+
+//ListRemoteProcs will list the process on slaver by ProcDialer.
+func ListRemoteProcs(dial func(uri string) (raw io.ReadWriteCloser, err error), filter *ProcFilter) (procs []*ProcInfo, err error) {
+	args := url.Values{}
+	if len(filter.Name) > 0 {
+		args.Set("name", filter.Name)
+	}
+	if len(filter.User) > 0 {
+		args.Set("user", filter.User)
+	}
+	if len(filter.Sort) > 0 {
+		args.Set("sort", filter.Sort)
+	}
+	if filter.Limit > 0 {
+		args.Set("limit", fmt.Sprintf("%v", filter.Limit))
+	}
+	reply, err := procCall(dial, "list", args)
+	if err == nil {
+		procs = reply.Procs
+	}
+	return
+}
+
+//KillRemoteProc will send signal to process on slaver by ProcDialer.
+func KillRemoteProc(dial func(uri string) (raw io.ReadWriteCloser, err error), pid int, signal string) (err error) {
+	args := url.Values{}
+	args.Set("pid", fmt.Sprintf("%v", pid))
+	if len(signal) > 0 {
+		args.Set("signal", signal)
+	}
+	_, err = procCall(dial, "kill", args)
+	return
+}
@@ -0,0 +1,12 @@
+package fsck
+
+import (
+	"fmt"
+	"runtime"
+)
+
+//listProcs will read all process info from proc filesystem root, it is not supported on darwin.
+func listProcs(root string) (procs []*ProcInfo, err error) {
+	err = fmt.Errorf("process list is not supported on %v", runtime.GOOS)
+	return
+}
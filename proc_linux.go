@@ -0,0 +1,129 @@
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//the clock ticks per second, it is 100 on almost all linux.
+const procClockTicks = 100
+
+//listProcs will read all process info from proc filesystem root.
+func listProcs(root string) (procs []*ProcInfo, err error) {
+	dirs, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	uptime, btime, err := readProcUptime(root)
+	if err != nil {
+		return
+	}
+	users := map[string]string{}
+	pagesize := int64(os.Getpagesize())
+	for _, dir := range dirs {
+		pid, perr := strconv.Atoi(dir.Name())
+		if perr != nil || !dir.IsDir() {
+			continue
+		}
+		proc, perr := readProc(filepath.Join(root, dir.Name()), pid, uptime, btime, pagesize, users)
+		if perr != nil { //the process may be exited
+			continue
+		}
+		procs = append(procs, proc)
+	}
+	return
+}
+
+func readProcUptime(root string) (uptime float64, btime int64, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, "uptime"))
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 1 {
+		err = fmt.Errorf("invalid uptime(%s)", data)
+		return
+	}
+	uptime, err = strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return
+	}
+	data, err = ioutil.ReadFile(filepath.Join(root, "stat"))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			btime, err = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
+			return
+		}
+	}
+	err = fmt.Errorf("btime is not found")
+	return
+}
+
+func readProc(dir string, pid int, uptime float64, btime, pagesize int64, users map[string]string) (proc *ProcInfo, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return
+	}
+	//pid (comm) state ppid ..., the comm may contains space or ')'
+	begin, end := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if begin < 0 || end < begin {
+		err = fmt.Errorf("invalid stat(%s)", data)
+		return
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 {
+		err = fmt.Errorf("invalid stat(%s)", data)
+		return
+	}
+	proc = &ProcInfo{
+		PID:   pid,
+		Name:  string(data[begin+1 : end]),
+		State: fields[0],
+	}
+	proc.PPID, _ = strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	starttime, _ := strconv.ParseInt(fields[19], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+	proc.RSS = rss * pagesize
+	proc.Start = btime*1000 + starttime*1000/procClockTicks
+	proc.cpuTime = float64(utime+stime) / procClockTicks
+	if elapsed := uptime - float64(starttime)/procClockTicks; elapsed > 0 {
+		proc.CPU = proc.cpuTime / elapsed * 100
+	}
+	cmdline, _ := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	proc.Cmdline = strings.TrimSpace(string(bytes.Replace(cmdline, []byte{0}, []byte{' '}, -1)))
+	if len(proc.Cmdline) < 1 {
+		proc.Cmdline = "[" + proc.Name + "]"
+	}
+	status, _ := ioutil.ReadFile(filepath.Join(dir, "status"))
+	for _, line := range strings.Split(string(status), "\n") {
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		uids := strings.Fields(strings.TrimPrefix(line, "Uid:"))
+		if len(uids) < 1 {
+			break
+		}
+		name, ok := users[uids[0]]
+		if !ok {
+			name = uids[0]
+			if u, uerr := user.LookupId(uids[0]); uerr == nil {
+				name = u.Username
+			}
+			users[uids[0]] = name
+		}
+		proc.User = name
+		break
+	}
+	return
+}
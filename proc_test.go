@@ -0,0 +1,149 @@
+package fsck
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestProcDialer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	proc := NewProcDialer()
+	proc.Bootstrap()
+	if !proc.Matched("proc://list") || proc.Matched("tcp://list") {
+		t.Error("error")
+		return
+	}
+	dial := func(uri string) (raw io.ReadWriteCloser, err error) {
+		return proc.Dial(10, uri)
+	}
+	cmd := exec.Command("sleep", "30")
+	err := cmd.Start()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer cmd.Process.Kill()
+	//list
+	procs, err := ListRemoteProcs(dial, &ProcFilter{Name: "sleep 30"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var found *ProcInfo
+	for _, info := range procs {
+		if info.PID == cmd.Process.Pid {
+			found = info
+		}
+	}
+	if found == nil || found.Name != "sleep" || found.PPID != os.Getpid() || found.RSS < 1 ||
+		found.Start < time.Now().Add(-time.Minute).Unix()*1000 || len(found.User) < 1 {
+		t.Errorf("%v", procs)
+		return
+	}
+	procs, err = ListRemoteProcs(dial, &ProcFilter{Sort: "rss", Limit: 2})
+	if err != nil || len(procs) != 2 || procs[0].RSS < procs[1].RSS {
+		t.Errorf("%v,%v", procs, err)
+		return
+	}
+	procs, err = ListRemoteProcs(dial, &ProcFilter{User: "not-exists-user"})
+	if err != nil || len(procs) != 0 {
+		t.Errorf("%v,%v", procs, err)
+		return
+	}
+	//kill
+	err = KillRemoteProc(dial, cmd.Process.Pid, "KILL")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cmd.Wait()
+	if exit := NewExitStatus(cmd.ProcessState, err); exit.Signal != "KILL" {
+		t.Error(exit)
+		return
+	}
+	//error
+	if err = KillRemoteProc(dial, cmd.Process.Pid, "xx"); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = KillRemoteProc(dial, 0, ""); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = procCall(dial, "xx", nil); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = proc.Dial(10, "%x"); err == nil {
+		t.Error(err)
+		return
+	}
+	proc.Root = "/tmp/fsck_proc_none"
+	if _, err = ListRemoteProcs(dial, &ProcFilter{}); err == nil {
+		t.Error(err)
+		return
+	}
+	fmt.Printf("%v\n", proc)
+}
+
+func TestListProcs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	os.RemoveAll("/tmp/fsck_proc")
+	defer os.RemoveAll("/tmp/fsck_proc")
+	os.MkdirAll("/tmp/fsck_proc/100", os.ModePerm)
+	os.MkdirAll("/tmp/fsck_proc/101", os.ModePerm)
+	os.MkdirAll("/tmp/fsck_proc/self", os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_proc/uptime", []byte("1100.00 2000.00\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_proc/stat", []byte("cpu 1 2 3\nbtime 1600000000\n"), os.ModePerm)
+	//started at 100s, used 50s cpu
+	ioutil.WriteFile("/tmp/fsck_proc/100/stat",
+		[]byte("100 (my (app)) S 1 100 100 0 -1 4194560 0 0 0 0 3000 2000 0 0 20 0 1 0 10000 1000000 25 18446744073709551615"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_proc/100/cmdline", []byte("/bin/app\x00-c\x00x.conf\x00"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_proc/100/status", []byte("Name:\tapp\nUid:\t0\t0\t0\t0\n"), os.ModePerm)
+	ioutil.WriteFile("/tmp/fsck_proc/101/stat", []byte("101 (kworker) I"), os.ModePerm)
+	procs, err := listProcs("/tmp/fsck_proc")
+	if err != nil || len(procs) != 1 {
+		t.Errorf("%v,%v", procs, err)
+		return
+	}
+	info := procs[0]
+	if info.PID != 100 || info.PPID != 1 || info.Name != "my (app)" || info.State != "S" || info.User != "root" ||
+		info.Cmdline != "/bin/app -c x.conf" || info.CPU != 5 || info.RSS != 25*int64(os.Getpagesize()) ||
+		info.Start != 1600000100000 {
+		t.Errorf("%v", fmt.Sprintf("%#v", info))
+		return
+	}
+	//kernel thread
+	ioutil.WriteFile("/tmp/fsck_proc/100/cmdline", []byte{}, os.ModePerm)
+	procs, _ = listProcs("/tmp/fsck_proc")
+	if procs[0].Cmdline != "[my (app)]" {
+		t.Error(procs[0].Cmdline)
+		return
+	}
+	//error
+	ioutil.WriteFile("/tmp/fsck_proc/stat", []byte("cpu 1 2 3\n"), os.ModePerm)
+	if _, err = listProcs("/tmp/fsck_proc"); err == nil {
+		t.Error(err)
+		return
+	}
+	ioutil.WriteFile("/tmp/fsck_proc/uptime", []byte("xx"), os.ModePerm)
+	if _, err = listProcs("/tmp/fsck_proc"); err == nil {
+		t.Error(err)
+		return
+	}
+	ioutil.WriteFile("/tmp/fsck_proc/uptime", []byte(""), os.ModePerm)
+	if _, err = listProcs("/tmp/fsck_proc"); err == nil {
+		t.Error(err)
+		return
+	}
+}
@@ -77,3 +77,125 @@ func TestRealTime(t *testing.T) {
 	//
 	ts.PostN2("/update", "application/json", bytes.NewBufferString("xxxx"))
 }
+
+func TestRealTimeMergeAgg(t *testing.T) {
+	rl := NewRealTime()
+	for i := 1; i <= 10; i++ {
+		rl.Update(fmt.Sprintf("x%v", i), util.Map{"a": i * 10, "c": i * 100})
+	}
+	rl.Update("x11", util.Map{"b": 1})
+	//rate by last two updates
+	for i := 1; i <= 10; i++ {
+		rl.Update(fmt.Sprintf("x%v", i), util.Map{"a": i * 10, "c": i*100 + 50})
+		log := rl.ls[fmt.Sprintf("x%v", i)]
+		log.PrevTimes["c"] = log.Times["c"] - 500
+	}
+	rl.ls["x10"].Log = util.Map{"a": 100, "c": 0}
+	rl.ls["x10"].Last = util.Now() + 1
+	rl.ls["x10"].Times["c"] = util.Now() + 1
+	hosts, logs := rl.MergeLog(map[string]int64{"*": 0}, map[string]string{
+		"a": "p90",
+		"b": "count",
+		"c": "rate",
+		"d": "stddev",
+	})
+	if len(hosts) != 11 || logs.FloatVal("a") != 90 || logs.IntVal("b") != 1 || logs.FloatVal("c") != 900 || logs.FloatVal("d") != 0 {
+		t.Errorf("%v", logs)
+		return
+	}
+	//the host which has not the key is skipped
+	_, logs = rl.MergeLog(map[string]int64{"*": 0}, map[string]string{
+		"a": "p1",
+		"b": "stddev",
+	})
+	if logs.FloatVal("a") != 10 || logs.FloatVal("b") != 0 || !logs.Exist("b") {
+		t.Errorf("%v", logs)
+		return
+	}
+	_, logs = rl.MergeLog(map[string]int64{"*": 0}, map[string]string{
+		"a": "stddev",
+	})
+	if logs.FloatVal("a") != 28.72 {
+		t.Errorf("%v", logs)
+		return
+	}
+	_, logs = rl.MergeLog(map[string]int64{"x1": 0, "x2": 0, "x3": 0, "x4": 0, "x10": 0}, map[string]string{
+		"a": "p50",
+		"c": "last",
+		"b": "count",
+	})
+	if logs.FloatVal("a") != 30 || logs.FloatVal("c") != 0 || logs.IntVal("b") != 0 {
+		t.Errorf("%v", logs)
+		return
+	}
+	_, logs = rl.MergeLog(map[string]int64{"x2": 0, "x4": 0, "x6": 0, "x8": 0}, map[string]string{
+		"a": "stddev",
+		"c": "p100",
+	})
+	if logs.FloatVal("a") != 22.36 || logs.FloatVal("c") != 850 {
+		t.Errorf("%v", logs)
+		return
+	}
+	//the rate is calculated by the change of each key when patch
+	rl.Patch("x12", util.Map{"c": 100})
+	rl.ls["x12"].Times["c"] -= 1000
+	rl.Patch("x12", util.Map{"c": 200})
+	rl.Patch("x12", util.Map{"d": 1})
+	rl.Patch("x12", util.Map{"d": 2})
+	_, logs = rl.MergeLog(map[string]int64{"x12": 0}, map[string]string{"c": "rate", "d": "rate"})
+	if logs.FloatVal("c") < 90 || logs.FloatVal("c") > 100 {
+		t.Errorf("%v", logs)
+		return
+	}
+	rl.Patch("x12", util.Map{"d": nil})
+	if x12 := rl.ls["x12"]; x12.Log.Exist("d") || x12.Prev.Exist("d") || len(x12.Times) != 1 || x12.Log.FloatVal("c") != 200 {
+		t.Errorf("%v", x12)
+		return
+	}
+	for agg, valid := range map[string]bool{"sum": true, "rate": true, "p99": true, "p100": true, "p0": false, "p101": false, "xx": false} {
+		if ValidRealAgg(agg) != valid {
+			t.Error(agg)
+			return
+		}
+	}
+}
+
+func TestRealTimeGroupLog(t *testing.T) {
+	rl := NewRealTime()
+	rl.Update("h1", util.Map{"a": 1, "labels": util.Map{"region": "eu", "role": "db"}})
+	rl.Update("h2", util.Map{"a": 3, "labels": map[string]interface{}{"region": "eu"}})
+	rl.Update("h3", util.Map{"a": 10, "labels": util.Map{"region": "us"}})
+	rl.Update("h4", util.Map{"a": 100})
+	//labels is kept when update without labels
+	rl.Update("h1", util.Map{"a": 1})
+	if rl.ls["h1"].Labels["role"] != "db" || rl.ls["h1"].Log.Exist("labels") {
+		t.Errorf("%v", rl.ls["h1"])
+		return
+	}
+	rl.ls["h2"].Last = util.Now() - 10000
+	groups := rl.GroupLog(map[string]int64{"*": 5000}, map[string]string{"a": "avg"}, "region")
+	if len(groups) != 3 {
+		t.Errorf("%v", groups)
+		return
+	}
+	eu := groups.MapVal("eu")
+	if eu.MapVal("hosts").StrVal("h1") != "ok" || eu.MapVal("hosts").StrVal("h2") != "offline" || eu.MapVal("logs").FloatVal("a") != 1 {
+		t.Errorf("%v", eu)
+		return
+	}
+	if groups.MapVal("us").MapVal("logs").FloatVal("a") != 10 || groups.MapVal("").MapVal("logs").FloatVal("a") != 100 {
+		t.Errorf("%v", groups)
+		return
+	}
+	//filter by name
+	groups = rl.GroupLog(map[string]int64{"h3": 0}, map[string]string{"a": "sum"}, "region")
+	if len(groups) != 1 || groups.MapVal("us").MapVal("logs").FloatVal("a") != 10 {
+		t.Errorf("%v", groups)
+		return
+	}
+	hosts, logs := rl.MergeLog(map[string]int64{}, map[string]string{"a": "sum"})
+	if len(hosts) != 4 || logs.FloatVal("a") != 114 {
+		t.Errorf("%v", logs)
+		return
+	}
+}
@@ -0,0 +1,354 @@
+package fsck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//RealBucket is the aggregated values of one key in time bucket.
+type RealBucket struct {
+	Time  int64   `json:"t"` //the bucket begin time in unix milliseconds.
+	Count int64   `json:"c"`
+	Sum   float64 `json:"s"`
+	Min   float64 `json:"n"`
+	Max   float64 `json:"x"`
+}
+
+//Add will add the value to bucket.
+func (r *RealBucket) Add(val float64) {
+	if r.Count < 1 || val < r.Min {
+		r.Min = val
+	}
+	if r.Count < 1 || val > r.Max {
+		r.Max = val
+	}
+	r.Count++
+	r.Sum += val
+}
+
+//Merge will merge other bucket to bucket.
+func (r *RealBucket) Merge(other *RealBucket) {
+	if other.Count < 1 {
+		return
+	}
+	if r.Count < 1 || other.Min < r.Min {
+		r.Min = other.Min
+	}
+	if r.Count < 1 || other.Max > r.Max {
+		r.Max = other.Max
+	}
+	r.Count += other.Count
+	r.Sum += other.Sum
+}
+
+//Value will return the bucket value by aggregation, the min/max is return for min/max, the average is return for others.
+func (r *RealBucket) Value(agg string) float64 {
+	switch agg {
+	case "min":
+		return r.Min
+	case "max":
+		return r.Max
+	default:
+		if r.Count < 1 {
+			return 0
+		}
+		return r.Sum / float64(r.Count)
+	}
+}
+
+//RealLevel is the downsampling level of history, the values is aggregated by Step and at most Max buckets is kept.
+type RealLevel struct {
+	Step int64 `json:"step"` //the bucket step in milliseconds.
+	Max  int   `json:"max"`
+}
+
+//RealHistory is the bounded in-memory time series of realtime log by name and key,
+//all numeric values is recorded to every level, so the recent values is kept in fine step and the older is downsampled.
+//
+//at most MaxSeries series of name/key is kept, the new series is ignored when it is full,
+//and the name which is not updated in Stale milliseconds is dropped.
+type RealHistory struct {
+	Levels    []RealLevel
+	MaxSeries int
+	Stale     int64
+	series    map[string]map[string][][]*RealBucket
+	updated   map[string]int64
+	count     int
+	expired   int64
+	lck       sync.RWMutex
+}
+
+//NewRealHistory will return new history by levels, the default levels is 10s for 1 hour, 1m for 1 day, 10m for 1 week,
+//the default stale time is the range of the last level.
+func NewRealHistory(levels ...RealLevel) *RealHistory {
+	if len(levels) < 1 {
+		levels = []RealLevel{
+			{Step: 10000, Max: 360},
+			{Step: 60000, Max: 1440},
+			{Step: 600000, Max: 1008},
+		}
+	}
+	last := levels[len(levels)-1]
+	return &RealHistory{
+		Levels:    levels,
+		MaxSeries: 10000,
+		Stale:     last.Step * int64(last.Max),
+		series:    map[string]map[string][][]*RealBucket{},
+		updated:   map[string]int64{},
+	}
+}
+
+//Add will record the numeric values of log by name at time.
+func (r *RealHistory) Add(name string, time int64, log util.Map) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if time-r.expired >= r.Levels[0].Step {
+		r.expire(time)
+	}
+	keys := r.series[name]
+	for key, v := range log {
+		val, ok := realFloat(v)
+		if !ok {
+			continue
+		}
+		levels := keys[key]
+		if levels == nil {
+			if r.MaxSeries > 0 && r.count >= r.MaxSeries {
+				continue
+			}
+			if keys == nil {
+				keys = map[string][][]*RealBucket{}
+				r.series[name] = keys
+			}
+			levels = make([][]*RealBucket, len(r.Levels))
+			keys[key] = levels
+			r.count++
+		}
+		r.updated[name] = time
+		for idx, level := range r.Levels {
+			begin := time - time%level.Step
+			buckets := levels[idx]
+			if len(buckets) < 1 || buckets[len(buckets)-1].Time != begin {
+				buckets = append(buckets, &RealBucket{Time: begin})
+				if len(buckets) > level.Max {
+					buckets = buckets[len(buckets)-level.Max:]
+				}
+				levels[idx] = buckets
+			}
+			buckets[len(buckets)-1].Add(val)
+		}
+	}
+}
+
+//Query will return the series of key by name in time range [from,to), the values is aggregated by step.
+//the bucket of each name is chosen from the finest level which covers the range.
+func (r *RealHistory) Query(name, key string, from, to, step int64) (buckets []*RealBucket) {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	levels := r.series[name][key]
+	if len(levels) < 1 || step < 1 {
+		return
+	}
+	covered := func(idx int) bool {
+		found := levels[idx]
+		return len(found) > 0 && (found[0].Time <= from || len(found) < r.Levels[idx].Max)
+	}
+	selected := -1
+	for idx, level := range r.Levels {
+		if level.Step <= step && covered(idx) {
+			selected = idx
+			break
+		}
+	}
+	if selected < 0 {
+		//the step is less than all level or the level is not covered, using the finest level which covers the range.
+		for idx := range r.Levels {
+			if covered(idx) {
+				selected = idx
+				break
+			}
+		}
+	}
+	if selected < 0 {
+		selected = len(levels) - 1
+	}
+	for _, bucket := range levels[selected] {
+		if bucket.Time < from || bucket.Time >= to {
+			continue
+		}
+		begin := bucket.Time - bucket.Time%step
+		if len(buckets) < 1 || buckets[len(buckets)-1].Time != begin {
+			buckets = append(buckets, &RealBucket{Time: begin})
+		}
+		buckets[len(buckets)-1].Merge(bucket)
+	}
+	return
+}
+
+//expire will remove the name which is not updated after time-Stale.
+func (r *RealHistory) expire(time int64) {
+	r.expired = time
+	if r.Stale < 1 {
+		return
+	}
+	for name, updated := range r.updated {
+		if time-updated < r.Stale {
+			continue
+		}
+		r.count -= len(r.series[name])
+		delete(r.series, name)
+		delete(r.updated, name)
+	}
+}
+
+//Names will return all name in history.
+func (r *RealHistory) Names() (names []string) {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	for name := range r.series {
+		names = append(names, name)
+	}
+	return
+}
+
+//Clear will remove all history.
+func (r *RealHistory) Clear() {
+	r.lck.Lock()
+	r.series = map[string]map[string][][]*RealBucket{}
+	r.updated = map[string]int64{}
+	r.count = 0
+	r.lck.Unlock()
+}
+
+type realHistoryFile struct {
+	Levels []RealLevel                           `json:"levels"`
+	Series map[string]map[string][][]*RealBucket `json:"series"`
+}
+
+//Save will save the history to file.
+func (r *RealHistory) Save(path string) (err error) {
+	r.lck.RLock()
+	data, err := json.Marshal(&realHistoryFile{Levels: r.Levels, Series: r.series})
+	r.lck.RUnlock()
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(path+".tmp", data, 0644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	return
+}
+
+//Load will load the history from file, the history is dropped when the levels is changed,
+//the series which is not matched the levels is dropped.
+func (r *RealHistory) Load(path string) (err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	saved := &realHistoryFile{}
+	err = json.Unmarshal(data, saved)
+	if err != nil || len(saved.Levels) != len(r.Levels) {
+		return
+	}
+	for idx, level := range saved.Levels {
+		if level.Step != r.Levels[idx].Step {
+			return
+		}
+	}
+	series := map[string]map[string][][]*RealBucket{}
+	updated := map[string]int64{}
+	count := 0
+	for name, keys := range saved.Series {
+		for key, levels := range keys {
+			last, verr := r.validLevels(levels)
+			if verr != nil {
+				log.W("RealHistory drop the series %v/%v on %v by %v", name, key, path, verr)
+				continue
+			}
+			if r.MaxSeries > 0 && count >= r.MaxSeries {
+				continue
+			}
+			if series[name] == nil {
+				series[name] = map[string][][]*RealBucket{}
+			}
+			series[name][key] = levels
+			if last > updated[name] {
+				updated[name] = last
+			}
+			count++
+		}
+	}
+	r.lck.Lock()
+	r.series, r.updated, r.count = series, updated, count
+	r.lck.Unlock()
+	return
+}
+
+//validLevels will check the levels of series is matched, it return the last bucket time.
+func (r *RealHistory) validLevels(levels [][]*RealBucket) (last int64, err error) {
+	if len(levels) != len(r.Levels) {
+		err = fmt.Errorf("expect %v levels, but %v", len(r.Levels), len(levels))
+		return
+	}
+	for idx, buckets := range levels {
+		if len(buckets) > r.Levels[idx].Max {
+			err = fmt.Errorf("level %v has %v buckets, but max is %v", idx, len(buckets), r.Levels[idx].Max)
+			return
+		}
+		for _, bucket := range buckets {
+			if bucket == nil {
+				err = fmt.Errorf("level %v has nil bucket", idx)
+				return
+			}
+			if bucket.Time > last {
+				last = bucket.Time
+			}
+		}
+	}
+	return
+}
+
+//ValidRealHistoryAgg return whether the aggregation is supported by history query, only min/max/avg/sum is supported.
+func ValidRealHistoryAgg(agg string) bool {
+	switch agg {
+	case "min", "max", "avg", "sum":
+		return true
+	default:
+		return false
+	}
+}
+
+func realFloat(v interface{}) (val float64, ok bool) {
+	ok = true
+	switch v := v.(type) {
+	case float64:
+		val = v
+	case float32:
+		val = float64(v)
+	case int:
+		val = float64(v)
+	case int64:
+		val = float64(v)
+	case int32:
+		val = float64(v)
+	case uint64:
+		val = float64(v)
+	case uint32:
+		val = float64(v)
+	case json.Number:
+		var err error
+		val, err = v.Float64()
+		ok = err == nil
+	default:
+		ok = false
+	}
+	return
+}
@@ -0,0 +1,202 @@
+package fsck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestRealHistory(t *testing.T) {
+	history := NewRealHistory(RealLevel{Step: 10, Max: 5}, RealLevel{Step: 100, Max: 5})
+	for i := int64(0); i < 100; i++ {
+		history.Add("h1", 1000+i, util.Map{"a": i, "b": float64(1), "c": "xx", "d": json.Number("2")})
+	}
+	//fine level is covered
+	buckets := history.Query("h1", "a", 1050, 1100, 10)
+	if len(buckets) != 5 || buckets[0].Time != 1050 || buckets[0].Count != 10 || buckets[0].Min != 50 || buckets[0].Max != 59 ||
+		buckets[0].Value("avg") != 54.5 || buckets[4].Value("max") != 99 || buckets[4].Value("min") != 90 {
+		t.Errorf("%v", util.S2Json(buckets))
+		return
+	}
+	//downsampling by query step
+	buckets = history.Query("h1", "a", 1050, 1100, 50)
+	if len(buckets) != 1 || buckets[0].Count != 50 || buckets[0].Value("avg") != 74.5 {
+		t.Errorf("%v", util.S2Json(buckets))
+		return
+	}
+	//step is less than finest level, using the finest level
+	buckets = history.Query("h1", "a", 1050, 1100, 5)
+	if len(buckets) != 5 || buckets[0].Time != 1050 || buckets[0].Count != 10 || buckets[4].Value("max") != 99 {
+		t.Errorf("%v", util.S2Json(buckets))
+		return
+	}
+	//fine level is not covered, using coarse level
+	buckets = history.Query("h1", "a", 1000, 1100, 10)
+	if len(buckets) != 1 || buckets[0].Time != 1000 || buckets[0].Count != 100 {
+		t.Errorf("%v", util.S2Json(buckets))
+		return
+	}
+	if buckets = history.Query("h1", "d", 1000, 1100, 100); len(buckets) != 1 || buckets[0].Value("avg") != 2 {
+		t.Errorf("%v", util.S2Json(buckets))
+		return
+	}
+	if len(history.Query("h1", "c", 1000, 1100, 10)) != 0 || len(history.Query("h2", "a", 1000, 1100, 10)) != 0 ||
+		len(history.Query("h1", "a", 1000, 1100, 0)) != 0 {
+		t.Error("error")
+		return
+	}
+	//ring is bounded
+	history.Stale = 100000
+	history.Add("h1", 5000, util.Map{"a": 1})
+	if levels := history.series["h1"]["a"]; len(levels[0]) != 5 || len(levels[1]) != 2 || levels[0][0].Time != 1060 {
+		t.Errorf("%v", util.S2Json(levels))
+		return
+	}
+	//save/load
+	os.Remove("/tmp/fsck_history.json")
+	defer os.Remove("/tmp/fsck_history.json")
+	if err := history.Save("/tmp/fsck_history.json"); err != nil {
+		t.Error(err)
+		return
+	}
+	loaded := NewRealHistory(RealLevel{Step: 10, Max: 5}, RealLevel{Step: 100, Max: 5})
+	if err := loaded.Load("/tmp/fsck_history.json"); err != nil || len(loaded.Names()) != 1 ||
+		len(loaded.Query("h1", "a", 1060, 1100, 10)) != 4 {
+		t.Errorf("%v,%v", loaded.Names(), err)
+		return
+	}
+	if info, err := os.Stat("/tmp/fsck_history.json"); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("%v,%v", info, err)
+		return
+	}
+	//invalid series
+	ioutil.WriteFile("/tmp/fsck_history.json", []byte(`{"levels":[{"step":10,"max":5},{"step":100,"max":5}],"series":{
+		"h1":{"a":[[{"t":1000}],[]],"b":[[]],"c":[[null],[]],"d":[[{},{},{},{},{},{}],[]]}
+	}}`), 0644)
+	if err := loaded.Load("/tmp/fsck_history.json"); err != nil || len(loaded.series["h1"]) != 1 || loaded.count != 1 {
+		t.Errorf("%v,%v", util.S2Json(loaded.series), err)
+		return
+	}
+	loaded.Add("h1", 1010, util.Map{"a": 1, "b": 2})
+	if buckets := loaded.Query("h1", "b", 1000, 1100, 10); len(buckets) != 1 {
+		t.Errorf("%v", util.S2Json(buckets))
+		return
+	}
+	//levels is changed
+	changed := NewRealHistory()
+	if err := changed.Load("/tmp/fsck_history.json"); err != nil || len(changed.Names()) != 0 {
+		t.Errorf("%v,%v", changed.Names(), err)
+		return
+	}
+	changed = NewRealHistory(RealLevel{Step: 10, Max: 5}, RealLevel{Step: 1000, Max: 5})
+	if err := changed.Load("/tmp/fsck_history.json"); err != nil || len(changed.Names()) != 0 {
+		t.Errorf("%v,%v", changed.Names(), err)
+		return
+	}
+	//error
+	if err := loaded.Load("/tmp/fsck_history_none.json"); err == nil {
+		t.Error(err)
+		return
+	}
+	ioutil.WriteFile("/tmp/fsck_history.json", []byte("xx"), os.ModePerm)
+	if err := loaded.Load("/tmp/fsck_history.json"); err == nil {
+		t.Error(err)
+		return
+	}
+	if err := loaded.Save("/tmp/fsck_none/history.json"); err == nil {
+		t.Error(err)
+		return
+	}
+	loaded.Clear()
+	if len(loaded.Names()) != 0 {
+		t.Error("error")
+		return
+	}
+}
+
+func TestRealHistoryBounded(t *testing.T) {
+	history := NewRealHistory(RealLevel{Step: 10, Max: 5}, RealLevel{Step: 100, Max: 5})
+	if history.Stale != 500 {
+		t.Error("error")
+		return
+	}
+	//max series
+	history.MaxSeries = 3
+	history.Add("h1", 1000, util.Map{"a": 1, "b": 1})
+	history.Add("h2", 1000, util.Map{"a": 1})
+	history.Add("h3", 1000, util.Map{"a": 1})
+	if history.count != 3 || len(history.Names()) != 2 || len(history.series["h2"]) != 1 {
+		t.Errorf("%v", util.S2Json(history.series))
+		return
+	}
+	//stale
+	history.Add("h2", 1400, util.Map{"a": 2})
+	history.Add("h3", 1600, util.Map{"a": 1})
+	if history.count != 2 || len(history.Names()) != 2 || len(history.series["h1"]) != 0 || len(history.series["h3"]) != 1 {
+		t.Errorf("%v", util.S2Json(history.series))
+		return
+	}
+	history.Clear()
+	if history.count != 0 || len(history.updated) != 0 {
+		t.Error("error")
+		return
+	}
+	//default disabled
+	if NewRealTime().History != nil {
+		t.Error("error")
+		return
+	}
+	if !ValidRealHistoryAgg("avg") || !ValidRealHistoryAgg("sum") || ValidRealHistoryAgg("p90") || ValidRealHistoryAgg("rate") {
+		t.Error("error")
+		return
+	}
+}
+
+func TestRealTimeQuery(t *testing.T) {
+	real := NewRealTime()
+	real.History = NewRealHistory(RealLevel{Step: 10, Max: 100})
+	for i := int64(0); i < 20; i++ {
+		real.History.Add("h1", 1000+i, util.Map{"a": 1, "b": i})
+		real.History.Add("h2", 1000+i, util.Map{"a": 3, "b": i + 100})
+	}
+	series := real.Query(nil, map[string]string{"a": "avg", "b": "max", "c": "sum"}, 1000, 1020, 10)
+	data := util.S2Json(series)
+	if data != `{"a":[[1000,2],[1010,2]],"b":[[1000,109],[1010,119]],"c":[]}` {
+		t.Error(data)
+		return
+	}
+	series = real.Query([]string{"h1", ""}, map[string]string{"a": "sum", "b": "min"}, 1000, 1020, 20)
+	data = util.S2Json(series)
+	if data != `{"a":[[1000,1]],"b":[[1000,0]]}` {
+		t.Error(data)
+		return
+	}
+	series = real.Query(nil, map[string]string{"a": "sum"}, 1000, 1020, 20)
+	if data = util.S2Json(series); data != `{"a":[[1000,4]]}` {
+		t.Error(data)
+		return
+	}
+	//default range
+	real.Update("h3", util.Map{"a": 5})
+	series = real.Query([]string{"h3"}, map[string]string{"a": "avg"}, 0, 0, 0)
+	if data = util.S2Json(series); len(series) != 1 || len(series.Val("a").([][]interface{})) != 1 {
+		t.Error(data)
+		return
+	}
+	real.Clear()
+	if len(real.History.Names()) != 0 {
+		t.Error("error")
+		return
+	}
+	real.History = nil
+	real.Update("h3", util.Map{"a": 5})
+	if len(real.Query(nil, map[string]string{"a": "avg"}, 0, 0, 0)) != 0 {
+		t.Error("error")
+		return
+	}
+	fmt.Println(data)
+}
@@ -0,0 +1,178 @@
+package fsck
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//RealDialer is the dialer to stream the merged realtime log by real:// uri, the merged log is sent as one json line
+//like {"time":xx,"hosts":{},"logs":{},"groups":{}} when the realtime log is updated, but at most once in interval,
+//and it is sent by Refresh when no update to show the offline host.
+//
+//	real://stream?host=h1,h2&timeout=5000&by=region&interval=1000&cpu=avg&mem=sum
+//
+//the host/timeout/by is same as RealTime.MergeLog/GroupLog, the interval is milliseconds, default is 1000.
+type RealDialer struct {
+	Real    *RealTime //the realtime to stream, it is setted by slaver when it is nil.
+	Refresh time.Duration
+}
+
+//NewRealDialer will return new realtime stream dialer.
+func NewRealDialer() *RealDialer {
+	return &RealDialer{
+		Refresh: 5 * time.Second,
+	}
+}
+
+func (r *RealDialer) Bootstrap() error {
+	return nil
+}
+
+func (r *RealDialer) Matched(uri string) bool {
+	return strings.HasPrefix(uri, "real://")
+}
+
+func (r *RealDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err error) {
+	if r.Real == nil {
+		err = fmt.Errorf("the realtime is not setted")
+		return
+	}
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if remote.Host != "stream" {
+		err = fmt.Errorf("real operation(%v) is not supported", remote.Host)
+		return
+	}
+	query := remote.Query()
+	var hosts []string
+	var by string
+	var timeout, interval int64
+	err = util.ValidAttrF(`host,O|S,L:0;by,O|S,L:0;timeout,O|I,R:0;interval,O|I,R:0`,
+		query.Get, true, &hosts, &by, &timeout, &interval)
+	if err != nil {
+		return
+	}
+	keys := map[string]string{}
+	for key := range query {
+		switch key {
+		case "host", "by", "timeout", "interval":
+		default:
+			keys[key] = query.Get(key)
+			if !ValidRealAgg(keys[key]) {
+				err = fmt.Errorf("the aggregation(%v) of %v is not supported", keys[key], key)
+				return
+			}
+		}
+	}
+	ns := map[string]int64{}
+	for _, host := range hosts {
+		if len(host) > 0 {
+			ns[host] = timeout
+		}
+	}
+	if len(ns) < 1 {
+		ns["*"] = timeout
+	}
+	if interval < 1 {
+		interval = 1000
+	}
+	local, raw := net.Pipe()
+	go r.stream(local, ns, keys, by, time.Duration(interval)*time.Millisecond)
+	return
+}
+
+func (r *RealDialer) stream(local net.Conn, ns map[string]int64, keys map[string]string, by string, interval time.Duration) {
+	updated, cancel := r.Real.Watch()
+	defer cancel()
+	closed := make(chan int)
+	go func() {
+		io.Copy(ioutil.Discard, local)
+		close(closed)
+	}()
+	defer local.Close()
+	encoder := json.NewEncoder(local)
+	for {
+		hosts, logs := r.Real.MergeLog(ns, keys)
+		res := util.Map{
+			"time":  util.Now(),
+			"hosts": hosts,
+			"logs":  logs,
+		}
+		if len(by) > 0 {
+			res["groups"] = r.Real.GroupLog(ns, keys, by)
+		}
+		err := encoder.Encode(res)
+		if err != nil {
+			log.D("RealDialer stream is done by %v", err)
+			return
+		}
+		select {
+		case <-updated:
+		case <-time.After(r.Refresh):
+		case <-closed:
+			return
+		}
+		select { //throttle the update
+		case <-time.After(interval):
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (r *RealDialer) String() string {
+	return "RealDialer"
+}
+
+//RealStreamURI will return the real:// uri to stream merged realtime log, see RealDialer for detail.
+func RealStreamURI(hosts []string, keys map[string]string, by string, timeout, interval int64) string {
+	args := url.Values{}
+	for key, agg := range keys {
+		args.Set(key, agg)
+	}
+	if len(hosts) > 0 {
+		args.Set("host", strings.Join(hosts, ","))
+	}
+	if len(by) > 0 {
+		args.Set("by", by)
+	}
+	if timeout > 0 {
+		args.Set("timeout", fmt.Sprintf("%v", timeout))
+	}
+	if interval > 0 {
+		args.Set("interval", fmt.Sprintf("%v", interval))
+	}
+	return "real://stream?" + args.Encode()
+}
+
+//ReadRealStream will read the merged realtime log stream, the handler is called on each update until it return error.
+func ReadRealStream(raw io.Reader, handler func(res util.Map) error) (err error) {
+	reader := bufio.NewReader(raw)
+	for {
+		var line []byte
+		line, err = reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		res := util.Map{}
+		err = json.Unmarshal(line, &res)
+		if err == nil {
+			err = handler(res)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package fsck
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestRealDialer(t *testing.T) {
+	dialer := NewRealDialer()
+	dialer.Refresh = 200 * time.Millisecond
+	dialer.Bootstrap()
+	if !dialer.Matched("real://stream") || dialer.Matched("tail:///tmp/x") {
+		t.Error("error")
+		return
+	}
+	//not realtime
+	if _, err := dialer.Dial(10, "real://stream?a=avg"); err == nil {
+		t.Error("error")
+		return
+	}
+	dialer.Real = NewRealTime()
+	dialer.Real.Update("h1", util.Map{"a": 1, "labels": util.Map{"region": "eu"}})
+	dialer.Real.Update("h2", util.Map{"a": 3, "labels": util.Map{"region": "us"}})
+	//error
+	for _, uri := range []string{
+		"real://stream?a=xx",
+		"real://list?a=avg",
+		"real://stream?timeout=xx",
+	} {
+		if _, err := dialer.Dial(10, uri); err == nil {
+			t.Errorf("%v: not error", uri)
+			return
+		}
+	}
+	uri := RealStreamURI([]string{"h1", "h2"}, map[string]string{"a": "sum"}, "region", 5000, 100)
+	raw, err := dialer.Dial(10, uri)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	received := make(chan util.Map, 10)
+	go func() {
+		err := ReadRealStream(raw, func(res util.Map) error {
+			received <- res
+			return nil
+		})
+		fmt.Println("read stream done with", err)
+		close(received)
+	}()
+	//first
+	res := <-received
+	if res.MapVal("logs").FloatVal("a") != 4 || res.MapVal("groups").MapVal("eu").MapVal("logs").FloatVal("a") != 1 {
+		t.Errorf("%v", res)
+		return
+	}
+	//update
+	dialer.Real.Update("h1", util.Map{"a": 10})
+	res = <-received
+	if res.MapVal("logs").FloatVal("a") != 13 || res.MapVal("groups").MapVal("eu").MapVal("logs").FloatVal("a") != 10 {
+		t.Errorf("%v", res)
+		return
+	}
+	//refresh without update
+	res = <-received
+	if res.MapVal("logs").FloatVal("a") != 13 {
+		t.Errorf("%v", res)
+		return
+	}
+	raw.Close()
+	for range received {
+	}
+	//all host
+	raw, err = dialer.Dial(10, "real://stream?a=max")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ReadRealStream(raw, func(res util.Map) error {
+		if res.MapVal("logs").FloatVal("a") != 10 || res.Exist("groups") {
+			return fmt.Errorf("%v", res)
+		}
+		return fmt.Errorf("done")
+	})
+	raw.Close()
+	if err == nil || err.Error() != "done" {
+		t.Error(err)
+		return
+	}
+}
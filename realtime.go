@@ -3,6 +3,10 @@ package fsck
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -11,19 +15,26 @@ import (
 )
 
 type RealLog struct {
-	Last int64
-	Log  util.Map
+	Last      int64
+	Log       util.Map
+	Labels    map[string]string //the labels like region/role, it is updated by labels key of log.
+	Times     map[string]int64  `json:"-"` //the last update time of each key.
+	Prev      util.Map          `json:"-"` //the previous value of each key, it is used to calculate rate.
+	PrevTimes map[string]int64  `json:"-"` //the previous update time of each key.
 }
 
 type RealTime struct {
-	ls  map[string]*RealLog
-	lck sync.RWMutex
+	ls       map[string]*RealLog
+	watchers map[chan int]bool
+	lck      sync.RWMutex
+	History  *RealHistory //the time series history, it is disabled when nil, default is nil.
 }
 
 func NewRealTime() *RealTime {
 	return &RealTime{
-		ls:  map[string]*RealLog{},
-		lck: sync.RWMutex{},
+		ls:       map[string]*RealLog{},
+		watchers: map[chan int]bool{},
+		lck:      sync.RWMutex{},
 	}
 }
 
@@ -40,17 +51,107 @@ func (r *RealTime) UpdateH(hs *routing.HTTPSession) routing.HResult {
 			err = fmt.Errorf("the top name is empty")
 			return hs.MsgResErr2(2, "arg-err", err)
 		}
-		rl := r.ls[name]
-		if rl == nil {
-			rl = &RealLog{}
-			r.ls[name] = rl
-		}
-		rl.Last = util.Now()
-		rl.Log = log
+		r.update(name, log)
 	}
 	return hs.MsgRes("ok")
 }
 
+//Update will update the real log by name, it is used by local collector.
+func (r *RealTime) Update(name string, log util.Map) {
+	r.lck.Lock()
+	r.update(name, log)
+	r.lck.Unlock()
+}
+
+//Patch will update the keys of real log by name and keep other keys, so multi collector can update to same name,
+//the key is removed when the value is nil.
+func (r *RealTime) Patch(name string, log util.Map) {
+	r.lck.Lock()
+	patched := util.Map{}
+	changed := util.Map{}
+	if rl := r.ls[name]; rl != nil {
+		for key, val := range rl.Log {
+			patched[key] = val
+		}
+	}
+	for key, val := range log {
+		if val == nil {
+			delete(patched, key)
+			continue
+		}
+		patched[key] = val
+		changed[key] = val
+	}
+	r.patch(name, patched, changed)
+	r.lck.Unlock()
+}
+
+func (r *RealTime) update(name string, log util.Map) {
+	r.patch(name, log, log)
+}
+
+//patch will replace the real log by name, the update time of changed keys is updated and the previous value is kept.
+func (r *RealTime) patch(name string, log, changed util.Map) {
+	rl := r.ls[name]
+	if rl == nil {
+		rl = &RealLog{
+			Times:     map[string]int64{},
+			Prev:      util.Map{},
+			PrevTimes: map[string]int64{},
+		}
+		r.ls[name] = rl
+	}
+	if log.Exist("labels") {
+		labels := log.MapVal("labels")
+		rl.Labels = map[string]string{}
+		for key := range labels {
+			rl.Labels[key] = labels.StrVal(key)
+		}
+		delete(log, "labels")
+	}
+	rl.Last = util.Now()
+	for key := range changed {
+		if key == "labels" {
+			continue
+		}
+		if rl.Log.Exist(key) {
+			rl.Prev[key], rl.PrevTimes[key] = rl.Log[key], rl.Times[key]
+		}
+		rl.Times[key] = rl.Last
+	}
+	for key := range rl.Times {
+		if !log.Exist(key) {
+			delete(rl.Times, key)
+			delete(rl.Prev, key)
+			delete(rl.PrevTimes, key)
+		}
+	}
+	rl.Log = log
+	if r.History != nil {
+		r.History.Add(name, rl.Last, changed)
+	}
+	for watcher := range r.watchers {
+		select {
+		case watcher <- 1:
+		default:
+		}
+	}
+}
+
+//Watch will return the channel which is notified when any log is updated, the cancel must be called after used.
+func (r *RealTime) Watch() (updated chan int, cancel func()) {
+	updated = make(chan int, 1)
+	r.lck.Lock()
+	r.watchers[updated] = true
+	r.lck.Unlock()
+	cancel = func() {
+		r.lck.Lock()
+		delete(r.watchers, updated)
+		r.lck.Unlock()
+	}
+	return
+}
+
 func (r *RealTime) ListH(hs *routing.HTTPSession) routing.HResult {
 	r.lck.RLock()
 	defer r.lck.RUnlock()
@@ -61,7 +162,7 @@ func (r *RealTime) ShowH(hs *routing.HTTPSession) routing.HResult {
 	hs.R.ParseForm()
 	keys := map[string]string{}
 	for key := range hs.R.Form {
-		if key == "name" {
+		if key == "name" || key == "by" {
 			continue
 		}
 		keys[key] = hs.R.FormValue(key)
@@ -74,20 +175,79 @@ func (r *RealTime) ShowH(hs *routing.HTTPSession) routing.HResult {
 		}
 	}
 	hosts, alllog := r.MergeLog(ns, keys)
-	return hs.JRes(util.Map{
+	res := util.Map{
 		"code":  0,
 		"hosts": hosts,
 		"logs":  alllog,
-	})
+	}
+	if by := hs.R.FormValue("by"); len(by) > 0 {
+		res["groups"] = r.GroupLog(ns, keys, by)
+	}
+	return hs.JRes(res)
 }
 
+var realPercentileRegex = regexp.MustCompile(`^p([1-9][0-9]?|100)$`)
+
+//ValidRealAgg return whether the aggregation is supported by MergeLog.
+func ValidRealAgg(agg string) bool {
+	switch agg {
+	case "min", "max", "avg", "sum", "count", "last", "rate", "stddev":
+		return true
+	default:
+		return realPercentileRegex.MatchString(agg)
+	}
+}
+
+//MergeLog will merge the log of hosts by keys aggregation, the aggregation is
+//
+//	sum              the sum of all host, it is default
+//	avg/min/max      the average/min/max of all host
+//	count            the count of host which has the key
+//	last             the value of host which is updated last
+//	p50/p90/p99      the percentile of all host by nearest rank, any pNN is supported
+//	rate             the sum of per second change between last two updates of all host, the reset counter is zero
+//	stddev           the population standard deviation of all host
 func (r *RealTime) MergeLog(ns map[string]int64, keys map[string]string) (hosts, alllog util.Map) {
+	return r.mergeLog(ns, keys, nil)
+}
+
+//GroupLog will merge the log of hosts by keys aggregation in group of label value, the host without the label is
+//grouped to empty value. the result is like {"eu":{"hosts":{...},"logs":{...}}}, see MergeLog for aggregation.
+func (r *RealTime) GroupLog(ns map[string]int64, keys map[string]string, by string) (groups util.Map) {
+	groups = util.Map{}
+	values := map[string]bool{}
+	r.lck.RLock()
+	for _, log := range r.ls {
+		values[log.Labels[by]] = true
+	}
+	r.lck.RUnlock()
+	for value := range values {
+		group := value
+		hosts, alllog := r.mergeLog(ns, keys, func(log *RealLog) bool {
+			return log.Labels[by] == group
+		})
+		if len(hosts) > 0 {
+			groups[group] = util.Map{
+				"hosts": hosts,
+				"logs":  alllog,
+			}
+		}
+	}
+	return
+}
+
+func (r *RealTime) mergeLog(ns map[string]int64, keys map[string]string, match func(log *RealLog) bool) (hosts, alllog util.Map) {
 	r.lck.Lock()
 	now := util.Now()
 	hosts = util.Map{}
 	alllog = util.Map{}
 	hostc := 0
+	values := map[string][]float64{}
+	lasts := map[string]int64{}
 	for name, log := range r.ls {
+		if match != nil && !match(log) {
+			continue
+		}
 		if timeout, ok := ns["*"]; ok {
 			if timeout > 0 && now-log.Last > timeout {
 				hosts[name] = "offline"
@@ -125,8 +285,38 @@ func (r *RealTime) MergeLog(ns map[string]int64, keys map[string]string) (hosts,
 				} else {
 					alllog.SetVal(key, log.Log.FloatVal(key))
 				}
+			case "count":
+				if log.Log.Exist(key) {
+					alllog.SetVal(key, alllog.IntVal(key)+1)
+				} else if !alllog.Exist(key) {
+					alllog.SetVal(key, 0)
+				}
+			case "last":
+				if log.Log.Exist(key) && (!alllog.Exist(key) || log.Last > lasts[key]) {
+					alllog.SetVal(key, log.Log.FloatVal(key))
+					lasts[key] = log.Last
+				}
+			case "rate":
+				var rate float64
+				if log.Prev.Exist(key) && log.Log.Exist(key) && log.Times[key] > log.PrevTimes[key] {
+					rate = (log.Log.FloatVal(key) - log.Prev.FloatVal(key)) * 1000 / float64(log.Times[key]-log.PrevTimes[key])
+				}
+				if rate < 0 { //the counter is reset
+					rate = 0
+				}
+				alllog.SetVal(key, alllog.FloatVal(key)+rate)
+			case "stddev":
+				if log.Log.Exist(key) {
+					values[key] = append(values[key], log.Log.FloatVal(key))
+				}
 			default:
-				alllog.SetVal(key, alllog.FloatVal(key)+log.Log.FloatVal(key))
+				if realPercentileRegex.MatchString(val) {
+					if log.Log.Exist(key) {
+						values[key] = append(values[key], log.Log.FloatVal(key))
+					}
+				} else {
+					alllog.SetVal(key, alllog.FloatVal(key)+log.Log.FloatVal(key))
+				}
 			}
 		}
 		hosts[name] = "ok"
@@ -136,18 +326,211 @@ func (r *RealTime) MergeLog(ns map[string]int64, keys map[string]string) (hosts,
 	if hostc > 0 {
 		for key, val := range keys {
 			if val == "avg" {
-				fmt.Println(key, alllog.FloatVal(key), float64(hostc))
 				alllog.SetVal(key, float64(int64(alllog.FloatVal(key)/float64(hostc)*100))/100)
 			}
 		}
 	}
+	for key, val := range keys {
+		switch {
+		case val == "rate":
+			alllog.SetVal(key, float64(int64(alllog.FloatVal(key)*100))/100)
+		case val == "stddev" && len(values[key]) > 0:
+			alllog.SetVal(key, float64(int64(stddev(values[key])*100))/100)
+		case realPercentileRegex.MatchString(val) && len(values[key]) > 0:
+			p, _ := strconv.ParseFloat(strings.TrimPrefix(val, "p"), 64)
+			alllog.SetVal(key, percentile(values[key], p))
+		}
+	}
 	return
 }
 
+//percentile return the nearest rank percentile of values.
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+//stddev return the population standard deviation of values.
+func stddev(values []float64) float64 {
+	var sum, squares float64
+	for _, val := range values {
+		sum += val
+	}
+	avg := sum / float64(len(values))
+	for _, val := range values {
+		squares += (val - avg) * (val - avg)
+	}
+	return math.Sqrt(squares / float64(len(values)))
+}
+
+//Lasts return the last update time of all host.
+func (r *RealTime) Lasts() (lasts map[string]int64) {
+	lasts = map[string]int64{}
+	r.lck.RLock()
+	for name, log := range r.ls {
+		lasts[name] = log.Last
+	}
+	r.lck.RUnlock()
+	return
+}
+
+//Values return the value of key on all host which has the key and is updated in timeout milliseconds, 0 is not timeout.
+func (r *RealTime) Values(key string, timeout int64) (values map[string]float64) {
+	values = map[string]float64{}
+	now := util.Now()
+	r.lck.RLock()
+	for name, log := range r.ls {
+		if !log.Log.Exist(key) || (timeout > 0 && now-log.Last > timeout) {
+			continue
+		}
+		values[name] = log.Log.FloatVal(key)
+	}
+	r.lck.RUnlock()
+	return
+}
+
+//Remove will remove the keys of real log by name without updating the last time,
+//the real log is removed when all keys is removed or keys is empty.
+func (r *RealTime) Remove(name string, keys ...string) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	rl := r.ls[name]
+	if rl == nil {
+		return
+	}
+	for _, key := range keys {
+		delete(rl.Log, key)
+		delete(rl.Times, key)
+		delete(rl.Prev, key)
+		delete(rl.PrevTimes, key)
+	}
+	if len(keys) < 1 || len(rl.Log) < 1 {
+		delete(r.ls, name)
+	}
+}
+
 func (r *RealTime) Clear() {
 	r.lck.Lock()
 	r.ls = map[string]*RealLog{}
 	r.lck.Unlock()
+	if r.History != nil {
+		r.History.Clear()
+	}
+}
+
+//QueryH will query the history series by name=h1,h2&from=xx&to=xx&step=xx&key1=avg, the time is unix milliseconds,
+//the default range is last one hour and the default step is 1 minute.
+func (r *RealTime) QueryH(hs *routing.HTTPSession) routing.HResult {
+	hs.R.ParseForm()
+	var from, to, step int64
+	var nsstr string
+	err := util.ValidAttrF(`
+		name,O|S,L:0;
+		from,O|I,R:0;
+		to,O|I,R:0;
+		step,O|I,R:0;
+		`, hs.R.FormValue, true, &nsstr, &from, &to, &step)
+	if err != nil {
+		return hs.MsgResErr2(1, "arg-err", err)
+	}
+	keys := map[string]string{}
+	for key := range hs.R.Form {
+		switch key {
+		case "name", "from", "to", "step":
+		default:
+			keys[key] = hs.R.FormValue(key)
+			if !ValidRealHistoryAgg(keys[key]) {
+				err = fmt.Errorf("the aggregation(%v) of %v is not supported by history", keys[key], key)
+				return hs.MsgResErr2(1, "arg-err", err)
+			}
+		}
+	}
+	var ns []string
+	if len(nsstr) > 0 {
+		ns = strings.Split(nsstr, ",")
+	}
+	series := r.Query(ns, keys, from, to, step)
+	return hs.JRes(util.Map{
+		"code":   0,
+		"series": series,
+	})
+}
+
+//Query will return the series of keys aggregated over hosts in time range [from,to) by step,
+//the value of each host in bucket is min/max for min/max and average for others, then the values of all hosts
+//is aggregated by avg/sum/min/max. the series is like {"key":[[time,value],...]}.
+//the range is last one hour when from is zero, and the step is 1 minute when it is zero.
+func (r *RealTime) Query(ns []string, keys map[string]string, from, to, step int64) (series util.Map) {
+	series = util.Map{}
+	if r.History == nil {
+		return
+	}
+	if to < 1 {
+		to = util.Now() + 1
+	}
+	if from < 1 {
+		from = to - 3600000
+	}
+	if step < 1 {
+		step = 60000
+	}
+	names := []string{}
+	for _, name := range ns {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) < 1 {
+		names = r.History.Names()
+	}
+	for key, agg := range keys {
+		values := map[int64]*RealBucket{}
+		times := []int64{}
+		for _, name := range names {
+			for _, bucket := range r.History.Query(name, key, from, to, step) {
+				merged := values[bucket.Time]
+				if merged == nil {
+					merged = &RealBucket{Time: bucket.Time}
+					values[bucket.Time] = merged
+					times = append(times, bucket.Time)
+				}
+				merged.Add(bucket.Value(agg))
+			}
+		}
+		sort.Sort(realTimeSorter(times))
+		points := [][]interface{}{}
+		for _, time := range times {
+			merged := values[time]
+			var val float64
+			if agg == "sum" {
+				val = merged.Sum
+			} else {
+				val = merged.Value(agg)
+			}
+			points = append(points, []interface{}{time, float64(int64(val*100)) / 100})
+		}
+		series[key] = points
+	}
+	return
+}
+
+type realTimeSorter []int64
+
+func (r realTimeSorter) Len() int {
+	return len(r)
+}
+
+func (r realTimeSorter) Less(i, j int) bool {
+	return r[i] < r[j]
+}
+
+func (r realTimeSorter) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
 }
 
 func NotifyReal(url string, data util.Map) (res util.Map, err error) {
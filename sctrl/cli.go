@@ -3,6 +3,9 @@ package main
 import (
 	"bytes"
 	"container/list"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +13,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -28,8 +32,9 @@ import (
 )
 
 const (
-	KeyWebCmdURL = "_web_cmd_url"
-	WebCmdPrefix = "-sctrlweb:"
+	KeyWebCmdURL   = "_web_cmd_url"
+	KeyWebDialAuth = "_web_dial_token"
+	WebCmdPrefix   = "-sctrlweb:"
 )
 
 var SpaceRegex = regexp.MustCompile("[ \n]+")
@@ -69,6 +74,8 @@ type Task struct {
 	Fields    map[string]string
 	Lck       sync.RWMutex
 	CmdPrefix string
+	Results   map[string]*fsck.AgentResult //the result of agent session by name.
+	agents    map[string]io.Closer
 }
 
 func NewTask(id string) *Task {
@@ -81,6 +88,8 @@ func NewTask(id string) *Task {
 		Fields:    map[string]string{},
 		Lck:       sync.RWMutex{},
 		CmdPrefix: WebCmdPrefix,
+		Results:   map[string]*fsck.AgentResult{},
+		agents:    map[string]io.Closer{},
 	}
 	task.Fields["tid"] = task.ID
 	return task
@@ -112,10 +121,13 @@ func (t *Task) Header() map[string]string {
 }
 
 type WebServer struct {
-	URL  string
-	srv  *http.Server
-	Mux  *routing.SessionMux
-	Addr string
+	URL       string
+	srv       *http.Server
+	tlsSrv    *http.Server
+	Mux       *routing.SessionMux
+	Addr      string
+	TLSAddr   string
+	TLSConfig *tls.Config
 }
 
 func (w *WebServer) Start() (err error) {
@@ -135,6 +147,13 @@ func (w *WebServer) Start() (err error) {
 		fmt.Printf("listen wen on %v\n", w.Addr)
 		go w.srv.Serve(l)
 	}
+	if err == nil && len(w.TLSAddr) > 0 {
+		w.tlsSrv = &http.Server{Addr: w.TLSAddr, Handler: w.Mux, TLSConfig: w.TLSConfig}
+		fmt.Printf("listen web https on %v\n", w.TLSAddr)
+		go func() {
+			log.Printf("web https server is stopped by %v", w.tlsSrv.ListenAndServeTLS("", ""))
+		}()
+	}
 	return
 }
 
@@ -142,6 +161,9 @@ func (w *WebServer) Close() (err error) {
 	if w.srv != nil {
 		err = w.srv.Close()
 	}
+	if w.tlsSrv != nil {
+		w.tlsSrv.Close()
+	}
 	return
 }
 
@@ -158,6 +180,8 @@ type Terminal struct {
 	Mux          *routing.SessionMux
 	WebSrv       *WebServer
 	Forward      *fsck.Forward
+	Tail         *fsck.TailCollector
+	Alert        *fsck.AlertManager
 	WebUI        *fsck.WebUI
 	WebCmd       string //the web cmd path
 	CmdPrefix    string
@@ -218,12 +242,17 @@ func NewTerminal(c *fsck.Slaver, name, ps1, shell, webcmd string, buffered int)
 		pings: map[string]string{},
 		pslck: sync.RWMutex{},
 	}
+	term.Forward.DialToken = newDialToken()
+	term.Tail = fsck.NewTailCollector(c.DialSession, term.Log.Write)
+	term.Alert = fsck.NewAlertManager(c.Real)
+	term.WebUI.Alert = term.Alert
 	term.Web.H = term.OnWebCmd
 	term.WebSrv = &WebServer{Mux: term.Mux}
 	//
 	term.Mux.HFilterFunc("^.*$", term.Forward.HostForwardF)
 	term.Forward.WebPrefix = "/ws"
 	term.Mux.HFunc("^/ws/.*$", term.Forward.ProcWebSubsH)
+	term.Mux.HFunc("^/dial(\\?.*)?$", term.Forward.ProcDialH)
 	//
 	term.WebUI.Hand(term.Mux, true)
 	//
@@ -232,6 +261,8 @@ func NewTerminal(c *fsck.Slaver, name, ps1, shell, webcmd string, buffered int)
 	term.Mux.HandleFunc("/lslog(\\?.*)?$", term.Log.ListLogH)
 	term.Mux.HFunc("^/real/update(\\?.*)?$", c.Real.UpdateH)
 	term.Mux.HFunc("^/real/show(\\?.*)?$", c.Real.ShowH)
+	term.Mux.HFunc("^/real/query(\\?.*)?$", c.Real.QueryH)
+	term.Mux.HFunc("^/real/stream(\\?.*)?$", term.RealStreamH)
 	prefix := bytes.NewBuffer(nil)
 	fmt.Fprintf(prefix, "set +o history\n")
 	fmt.Fprintf(prefix, "alias srun='%v/sctrl -run'\n", webcmd)
@@ -245,6 +276,11 @@ func NewTerminal(c *fsck.Slaver, name, ps1, shell, webcmd string, buffered int)
 	fmt.Fprintf(prefix, "alias saddmap='%v/sctrl -run saddmap'\n", webcmd)
 	fmt.Fprintf(prefix, "alias srmmap='%v/sctrl -run srmmap'\n", webcmd)
 	fmt.Fprintf(prefix, "alias slsmap='%v/sctrl -run slsmap'\n", webcmd)
+	fmt.Fprintf(prefix, "alias saddtail='%v/sctrl -run saddtail'\n", webcmd)
+	fmt.Fprintf(prefix, "alias srmtail='%v/sctrl -run srmtail'\n", webcmd)
+	fmt.Fprintf(prefix, "alias slstail='%v/sctrl -run slstail'\n", webcmd)
+	fmt.Fprintf(prefix, "alias sps='%v/sctrl -run sps'\n", webcmd)
+	fmt.Fprintf(prefix, "alias skill='%v/sctrl -run skill'\n", webcmd)
 	fmt.Fprintf(prefix, "alias smaster='%v/sctrl -run smaster'\n", webcmd)
 	fmt.Fprintf(prefix, "alias sslaver='%v/sctrl -run sslaver'\n", webcmd)
 	fmt.Fprintf(prefix, "alias sreal='%v/sctrl -run sreal'\n", webcmd)
@@ -263,6 +299,9 @@ func (t *Terminal) ListLogName() (ns []string) {
 	for em := t.ss.Front(); em != nil; em = em.Next() {
 		ns = append(ns, em.Value.(*SshSession).Name)
 	}
+	for _, source := range t.Tail.List() {
+		ns = append(ns, source.Name)
+	}
 	return
 }
 
@@ -318,8 +357,10 @@ func (t *Terminal) OnWebCmd(w *Web, line string) (data interface{}, err error) {
 			if found == nil {
 				fmt.Fprintf(buf, "-error: session %v not found\n", name)
 			} else {
-				found.Value.(*SshSession).Close()
+				removed := found.Value.(*SshSession)
+				removed.Close()
 				t.ss.Remove(found)
+				t.removePing(removed.Channel)
 				fmt.Fprintf(buf, "-done: session %v remove success\n", name)
 			}
 		}
@@ -447,11 +488,119 @@ func (t *Terminal) OnWebCmd(w *Web, line string) (data interface{}, err error) {
 				localmax = locallen
 			}
 		}
-		var format = fmt.Sprintf(" %v%v%v %v%v%v %v\n", "%", namemax, "s", "%", localmax, "s", "%v")
+		var format = fmt.Sprintf(" %v%v%v %v%v%v %v%v\n", "%", namemax, "s", "%", localmax, "s", "%v", "%v")
 		for _, m := range t.Forward.List() {
-			fmt.Fprintf(buf, format, m.Name, m.Local, m.Remote)
+			var health string
+			if m.Health != nil {
+				health = " " + m.Health.String()
+			}
+			fmt.Fprintf(buf, format, m.Name, m.Local, m.Remote, health)
+		}
+		data = buf.Bytes()
+	case "saddtail":
+		if len(cmds) < 2 {
+			err = saddtailUsage
+			return
+		}
+		args := SpaceRegex.Split(cmds[1], 2)
+		if len(args) < 2 {
+			err = saddtailUsage
+			return
+		}
+		var source *fsck.TailSource
+		source, err = t.Tail.Add(args[0], args[1])
+		if err == nil {
+			data = fmt.Sprintf("tail %v by %v success, show it by sctrl-log %v\n", source.Name, source, source.Name)
+		}
+		return
+	case "srmtail":
+		if len(cmds) < 2 {
+			err = srmtailUsage
+			return
+		}
+		for _, name := range SpaceRegex.Split(cmds[1], -1) {
+			err = t.Tail.Remove(name)
+			if err != nil {
+				return
+			}
+		}
+		data = "ok\n"
+		return
+	case "sps":
+		if len(cmds) < 2 {
+			err = spsUsage
+			return
+		}
+		args := SpaceRegex.Split(cmds[1], -1)
+		filter := &fsck.ProcFilter{}
+		for _, arg := range args[1:] {
+			switch {
+			case strings.HasPrefix(arg, "-user="):
+				filter.User = strings.TrimPrefix(arg, "-user=")
+			case strings.HasPrefix(arg, "-sort="):
+				filter.Sort = strings.TrimPrefix(arg, "-sort=")
+			case strings.HasPrefix(arg, "-limit="):
+				filter.Limit, err = strconv.Atoi(strings.TrimPrefix(arg, "-limit="))
+				if err != nil {
+					return
+				}
+			default:
+				filter.Name = arg
+			}
+		}
+		var channel string
+		channel, err = t.hostChannel(args[0])
+		if err != nil {
+			return
+		}
+		var procs []*fsck.ProcInfo
+		procs, err = fsck.ListRemoteProcs(t.dialRaw(channel), filter)
+		if err == nil {
+			buf := bytes.NewBuffer(nil)
+			fmt.Fprintf(buf, "%7s %7s %-10s %6s %9s %-5s %-16s %v\n", "PID", "PPID", "USER", "CPU%", "RSS", "STATE", "START", "COMMAND")
+			for _, proc := range procs {
+				fmt.Fprintf(buf, "%7d %7d %-10s %6.1f %9s %-5s %-16s %v\n", proc.PID, proc.PPID, proc.User, proc.CPU,
+					formatSize(proc.RSS), proc.State, time.Unix(proc.Start/1000, 0).Format("2006-01-02 15:04"), proc.Cmdline)
+			}
+			data = buf.Bytes()
+		}
+		return
+	case "skill":
+		if len(cmds) < 2 {
+			err = skillUsage
+			return
+		}
+		args := SpaceRegex.Split(cmds[1], -1)
+		if len(args) < 2 {
+			err = skillUsage
+			return
+		}
+		var pid int
+		pid, err = strconv.Atoi(args[1])
+		if err != nil {
+			return
+		}
+		var signal string
+		if len(args) > 2 {
+			signal = args[2]
+		}
+		var channel string
+		channel, err = t.hostChannel(args[0])
+		if err != nil {
+			return
+		}
+		err = fsck.KillRemoteProc(t.dialRaw(channel), pid, signal)
+		if err == nil {
+			data = fmt.Sprintf("send signal to %v on %v success\n", pid, args[0])
+		}
+		return
+	case "slstail":
+		buf := bytes.NewBuffer(nil)
+		for _, source := range t.Tail.List() {
+			fmt.Fprintf(buf, " %v %v running:%v\n", source.Name, source, source.Running)
 		}
 		data = buf.Bytes()
+		return
 	case "smaster":
 		var res util.Map
 		res, err = t.C.List()
@@ -494,6 +643,14 @@ func (t *Terminal) OnWebCmd(w *Web, line string) (data interface{}, err error) {
 					fmt.Fprintf(buf, "  %-10s -> avg:%-3d max:%-5d count:%-4d\n", action.StrVal("name"),
 						action.IntVal("avg"), action.IntVal("max"), action.IntVal("count"))
 				}
+				for _, alert := range res.AryMapVal("alerts") {
+					fmt.Fprintf(buf, "  %-10s -> %v@%v(%v) since %v\n", "alert", alert.StrVal("rule"), alert.StrVal("instance"),
+						alert.Val("value"), time.Unix(alert.IntVal("since")/1000, 0).Format("2006-01-02 15:04:05"))
+				}
+				facts, _ := fsck.ParseHostFacts(res.MapVal("facts"))
+				if facts != nil {
+					writeHostFacts(buf, facts)
+				}
 				fmt.Fprintf(buf, "\n")
 			}
 			data = buf.Bytes()
@@ -509,11 +666,25 @@ func (t *Terminal) OnWebCmd(w *Web, line string) (data interface{}, err error) {
 		keys := map[string]string{}
 		name := ""
 		clear := 0
+		var since, step time.Duration
+		var by string
 		for idx, arg := range args {
 			if idx == 0 {
 				name = arg
 			} else if strings.HasPrefix(arg, "-clear") {
 				clear = 1
+			} else if strings.HasPrefix(arg, "-by=") {
+				by = strings.TrimPrefix(arg, "-by=")
+			} else if strings.HasPrefix(arg, "-since=") {
+				since, err = time.ParseDuration(strings.TrimPrefix(arg, "-since="))
+				if err != nil {
+					return
+				}
+			} else if strings.HasPrefix(arg, "-step=") {
+				step, err = time.ParseDuration(strings.TrimPrefix(arg, "-step="))
+				if err != nil {
+					return
+				}
 			} else if strings.HasPrefix(arg, "-host=") {
 				parts := strings.Split(strings.TrimPrefix(arg, "-host="), ",")
 				for _, part := range parts {
@@ -530,7 +701,28 @@ func (t *Terminal) OnWebCmd(w *Web, line string) (data interface{}, err error) {
 				} else {
 					keys[parts[0]] = "sum"
 				}
+				if !fsck.ValidRealAgg(keys[parts[0]]) {
+					err = fmt.Errorf("the aggregation(%v) of %v is not supported", keys[parts[0]], parts[0])
+					return
+				}
+			}
+		}
+		if since > 0 {
+			for key, agg := range keys {
+				if !fsck.ValidRealHistoryAgg(agg) {
+					err = fmt.Errorf("the aggregation(%v) of %v is not supported by history, only min/max/avg/sum is supported", agg, key)
+					return
+				}
 			}
+			hosts := []string{}
+			for n := range ns {
+				hosts = append(hosts, n)
+			}
+			task := NewTask("")
+			task.Selected = t.selected
+			data = task
+			go t.execRealHistoryTask(task, name, hosts, keys, since, step)
+			return
 		}
 		for n := range ns {
 			ns[n] = timeout * 1000
@@ -548,7 +740,7 @@ func (t *Terminal) OnWebCmd(w *Web, line string) (data interface{}, err error) {
 		if clear > 0 {
 			task.CmdPrefix = ""
 		}
-		go t.execRealTask(task, name, ns, keys, clear, time.Duration(delay)*time.Second)
+		go t.execRealTask(task, name, ns, keys, clear, by, time.Duration(delay)*time.Second)
 	case "sping":
 		if len(cmds) < 2 {
 			err = srmmapUsage
@@ -666,40 +858,24 @@ func (t *Terminal) OnWebCmd(w *Web, line string) (data interface{}, err error) {
 	return
 }
 
-func (t *Terminal) execRealTask(task *Task, name string, ns map[string]int64, keys map[string]string, clear int, delay time.Duration) {
+func (t *Terminal) execRealTask(task *Task, name string, ns map[string]int64, keys map[string]string, clear int, by string, delay time.Duration) {
+	defer task.Close()
 	colmax := make([]int, 5)
+	if delay > 0 && clear < 1 {
+		//try to stream the update from slaver, fallback to query by delay when slaver not supported.
+		err := t.execRealStream(task, name, ns, keys, by, delay, colmax)
+		if err == nil {
+			return
+		}
+		log.Printf("Terminal stream real log on %v fail with %v, will query by delay", name, err)
+	}
 	for {
-		allres, err := t.C.RealLog([]string{name}, ns, keys, clear)
+		allres, err := t.C.RealLogBy([]string{name}, ns, keys, clear, by)
 		if err == nil {
-			res := allres.MapVal(name)
-			logs := res.MapVal("logs")
-			hosts := res.MapVal("hosts")
-			max := len("status")
-			for key := range logs {
-				if len(key) > max {
-					max = len(key)
-				}
-			}
-			online := 0
-			for n := range hosts {
-				if hosts.StrVal(n) == "ok" {
-					online++
-				}
-			}
 			if clear > 0 {
-				fmt.Fprintf(task, "->Slaver %v is clearup\n", name)
+				_, err = fmt.Fprintf(task, "->Slaver %v is clearup\n", name)
 			} else {
-				fmt.Fprintf(task, "->Slaver %v %v/%v hosts -> %v\n", name, online, len(hosts), res.Val("status"))
-			}
-			if len(logs) > 0 {
-				vals := []string{}
-				for key, val := range logs {
-					vals = append(vals, fmt.Sprintf("%v:%v", key, val))
-				}
-				sort.Sort(util.NewStringSorter(vals))
-				buf := fsck.ColumnBytes(" ", colmax, vals...)
-				buf.WriteTo(task)
-				_, err = fmt.Fprintf(task, "\n\n")
+				err = t.writeRealResult(task, name, allres.MapVal(name), by, colmax)
 			}
 		} else {
 			_, err = fmt.Fprintf(task, "->Slaver %v -> %v\n", name, err)
@@ -709,7 +885,205 @@ func (t *Terminal) execRealTask(task *Task, name string, ns map[string]int64, ke
 		}
 		time.Sleep(delay)
 	}
-	task.Close()
+}
+
+//execRealStream will subscribe the merged log of slaver by real:// and write each update to task,
+//the stream is redialed after delay when it is broken, it return error when dial fail, so the caller
+//can fallback to query, and return nil when the task is closed.
+func (t *Terminal) execRealStream(task *Task, name string, ns map[string]int64, keys map[string]string, by string, delay time.Duration, colmax []int) (err error) {
+	hosts := []string{}
+	var timeout int64
+	for n, v := range ns {
+		if n != "*" {
+			hosts = append(hosts, n)
+		}
+		timeout = v
+	}
+	uri := fsck.RealStreamURI(hosts, keys, by, timeout, int64(delay/time.Millisecond))
+	for {
+		var raw io.ReadWriteCloser
+		raw, err = t.dialRaw(name)(uri)
+		if err != nil {
+			return
+		}
+		var werr error
+		rerr := fsck.ReadRealStream(raw, func(res util.Map) error {
+			res["status"] = "ok"
+			werr = t.writeRealResult(task, name, res, by, colmax)
+			return werr
+		})
+		raw.Close()
+		if werr != nil {
+			return
+		}
+		_, werr = fmt.Fprintf(task, "->Slaver %v stream is broken by %v, will redial after %v\n", name, rerr, delay)
+		if werr != nil {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+//writeRealResult will write the merged log or grouped log of slaver.
+func (t *Terminal) writeRealResult(task *Task, name string, res util.Map, by string, colmax []int) (err error) {
+	if len(by) < 1 {
+		err = t.writeRealLog(task, "->Slaver "+name, fmt.Sprintf(" -> %v", res.Val("status")), res.MapVal("hosts"), res.MapVal("logs"), colmax)
+		return
+	}
+	groups := res.MapVal("groups")
+	values := []string{}
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Sort(util.NewStringSorter(values))
+	fmt.Fprintf(task, "->Slaver %v %v groups by %v -> %v\n", name, len(values), by, res.Val("status"))
+	for _, value := range values {
+		group := groups.MapVal(value)
+		if len(value) < 1 {
+			value = "<none>"
+		}
+		err = t.writeRealLog(task, fmt.Sprintf("  %v=%v", by, value), "", group.MapVal("hosts"), group.MapVal("logs"), colmax)
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+//writeRealLog will write the merged log with online/offline hosts.
+func (t *Terminal) writeRealLog(task *Task, title, suffix string, hosts, logs util.Map, colmax []int) (err error) {
+	online := 0
+	offline := []string{}
+	for n := range hosts {
+		if hosts.StrVal(n) == "ok" {
+			online++
+		} else {
+			offline = append(offline, n)
+		}
+	}
+	sort.Sort(util.NewStringSorter(offline))
+	fmt.Fprintf(task, "%v %v/%v hosts%v\n", title, online, len(hosts), suffix)
+	if len(offline) > 0 {
+		fmt.Fprintf(task, "  offline: %v\n", strings.Join(offline, ","))
+	}
+	if len(logs) > 0 {
+		vals := []string{}
+		for key, val := range logs {
+			vals = append(vals, fmt.Sprintf("%v:%v", key, val))
+		}
+		sort.Sort(util.NewStringSorter(vals))
+		buf := fsck.ColumnBytes(" ", colmax, vals...)
+		buf.WriteTo(task)
+		_, err = fmt.Fprintf(task, "\n\n")
+	}
+	return
+}
+
+//RealStreamH will stream the merged log of slaver as server-sent events, each update is sent as one json data,
+//the arguments is same as real:// uri on slaver, see fsck.RealDialer for detail.
+//
+//	/real/stream?slaver=name&host=h1,h2&timeout=5000&by=region&interval=1000&cpu=avg
+func (t *Terminal) RealStreamH(hs *routing.HTTPSession) routing.HResult {
+	var slaver string
+	err := util.ValidAttrF(`slaver,R|S,L:0`, hs.R.FormValue, true, &slaver)
+	if err != nil {
+		return hs.MsgResErr2(1, "arg-err", err)
+	}
+	args := url.Values{}
+	for key, vals := range hs.R.Form {
+		if key != "slaver" {
+			args[key] = vals
+		}
+	}
+	raw, err := t.dialRaw(slaver)("real://stream?" + args.Encode())
+	if err != nil {
+		return hs.MsgResErr2(2, "srv-err", err)
+	}
+	defer raw.Close()
+	hs.W.Header().Set("Content-Type", "text/event-stream")
+	hs.W.Header().Set("Cache-Control", "no-cache")
+	writer := NewNoBufferResponseWriter(hs.W)
+	err = fsck.ReadRealStream(raw, func(res util.Map) error {
+		data, _ := json.Marshal(res)
+		_, werr := fmt.Fprintf(writer, "data: %s\n\n", data)
+		return werr
+	})
+	log.Printf("Terminal real stream on %v is done by %v", slaver, err)
+	return routing.HRES_RETURN
+}
+
+func (t *Terminal) execRealHistoryTask(task *Task, name string, hosts []string, keys map[string]string, since, step time.Duration) {
+	defer task.Close()
+	if step < time.Second {
+		step = time.Minute
+	}
+	now := util.Now()
+	allres, err := t.C.RealQuery([]string{name}, hosts, keys, now-int64(since/time.Millisecond), now+1, int64(step/time.Millisecond))
+	if err != nil {
+		fmt.Fprintf(task, "->Slaver %v -> %v\n", name, err)
+		return
+	}
+	res := allres.MapVal(name)
+	if status := res.StrVal("status"); status != "ok" {
+		fmt.Fprintf(task, "->Slaver %v -> %v\n", name, status)
+		return
+	}
+	series := res.MapVal("series")
+	fields := []string{}
+	for key := range keys {
+		fields = append(fields, key)
+	}
+	sort.Sort(util.NewStringSorter(fields))
+	rows := map[int64][]string{}
+	times := []int64{}
+	for idx, key := range fields {
+		points, _ := series.Val(key).([]interface{})
+		for _, point := range points {
+			values, ok := point.([]interface{})
+			if !ok || len(values) < 2 {
+				continue
+			}
+			at, _ := strconv.ParseFloat(fmt.Sprintf("%v", values[0]), 64)
+			row := rows[int64(at)]
+			if row == nil {
+				row = make([]string, len(fields))
+				rows[int64(at)] = row
+				times = append(times, int64(at))
+			}
+			row[idx] = fmt.Sprintf("%v", values[1])
+		}
+	}
+	sort.Sort(timeSorter(times))
+	fmt.Fprintf(task, "->Slaver %v history in %v by %v\n", name, since, step)
+	fmt.Fprintf(task, "%-20s", "time")
+	for _, key := range fields {
+		fmt.Fprintf(task, " %12s", key)
+	}
+	fmt.Fprintf(task, "\n")
+	for _, at := range times {
+		fmt.Fprintf(task, "%-20s", time.Unix(at/1000, 0).Format("2006-01-02 15:04:05"))
+		for _, val := range rows[at] {
+			if len(val) < 1 {
+				val = "-"
+			}
+			fmt.Fprintf(task, " %12s", val)
+		}
+		fmt.Fprintf(task, "\n")
+	}
+}
+
+type timeSorter []int64
+
+func (t timeSorter) Len() int {
+	return len(t)
+}
+
+func (t timeSorter) Less(i, j int) bool {
+	return t[i] < t[j]
+}
+
+func (t timeSorter) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
 }
 
 func (t *Terminal) execPingTask(task *Task, name string, delay time.Duration) {
@@ -796,6 +1170,19 @@ func (t *Terminal) remoteTerm(task *Task, tid string) {
 		if len(termTask.Selected) > 0 && !fsck.Having(termTask.Selected, name) {
 			continue
 		}
+		if session.Agent {
+			sid := fmt.Sprintf("%v-%v", tid, name)
+			termTask.Lck.Lock()
+			conn := termTask.agents[sid]
+			termTask.Lck.Unlock()
+			if conn == nil {
+				fmt.Fprintf(task, "%v->task is already done(kill skipped)\n", session.Name)
+				continue
+			}
+			conn.Close()
+			fmt.Fprintf(task, "%v->close agent session success\n", session.Name)
+			continue
+		}
 		if !session.Running {
 			fmt.Fprintf(task, "%v->session is not runnning(kill skipped)\n", session.Name)
 			continue
@@ -831,6 +1218,14 @@ func (t *Terminal) remoteExecf(task *Task, script []byte, format string, args ..
 	var execSession = func(session *SshSession, sid string) {
 		defer wg.Done()
 		name := session.Name
+		if session.Agent {
+			task.Lck.Lock()
+			task.Subs[sid] = name
+			task.Lck.Unlock()
+			fmt.Fprintf(task, "%v->exec %v by agent\n", name, cmds)
+			go t.agentExec(task, session, sid, script, cmds)
+			return
+		}
 		var err error
 		tcmds := bytes.NewBuffer(nil)
 		fmt.Fprintf(tcmds, "_sexec_sid=%v\n", sid)
@@ -888,6 +1283,145 @@ func (t *Terminal) remoteExecf(task *Task, script []byte, format string, args ..
 	t.tasks[task.ID] = task
 }
 
+//dialRaw return the func to dial uri on channel as raw connection.
+func (t *Terminal) dialRaw(channel string) func(uri string) (raw io.ReadWriteCloser, err error) {
+	return func(uri string) (raw io.ReadWriteCloser, err error) {
+		reader, writer := io.Pipe()
+		conn, err := t.C.DialSession(channel, uri, writer)
+		if err != nil {
+			return
+		}
+		raw = &fsck.CombinedReadWriterCloser{
+			Reader: reader,
+			Writer: conn,
+			Closer: func() error {
+				reader.Close()
+				return conn.Close()
+			},
+		}
+		return
+	}
+}
+
+//hostChannel return the slaver channel of host, the host is agent session name or slaver name.
+func (t *Terminal) hostChannel(host string) (channel string, err error) {
+	for em := t.ss.Front(); em != nil; em = em.Next() {
+		session := em.Value.(*SshSession)
+		if session.Name != host {
+			continue
+		}
+		if !session.Agent {
+			err = fmt.Errorf("host(%v) is not agent session", host)
+			return
+		}
+		channel = session.Channel
+		return
+	}
+	channel = host
+	return
+}
+
+//formatSize return the human readable size, like 1.5M.
+func formatSize(size int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	val := float64(size)
+	idx := 0
+	for val >= 1024 && idx < len(units)-1 {
+		val /= 1024
+		idx++
+	}
+	if idx < 1 {
+		return fmt.Sprintf("%v%v", size, units[idx])
+	}
+	return fmt.Sprintf("%.1f%v", val, units[idx])
+}
+
+//writeHostFacts will write the host facts as human readable.
+func writeHostFacts(w io.Writer, facts *fsck.HostFacts) {
+	fmt.Fprintf(w, "  %-10s -> %v\n", "hostname", facts.Hostname)
+	fmt.Fprintf(w, "  %-10s -> %v, kernel %v, %v\n", "os", facts.OS, facts.Kernel, facts.Arch)
+	load := []string{}
+	for _, val := range facts.Load {
+		load = append(load, fmt.Sprintf("%.2f", val))
+	}
+	fmt.Fprintf(w, "  %-10s -> %v cores, load %v\n", "cpu", facts.CPU, strings.Join(load, " "))
+	if facts.MemTotal > 0 {
+		fmt.Fprintf(w, "  %-10s -> %v/%v available\n", "memory", formatSize(facts.MemAvail), formatSize(facts.MemTotal))
+	}
+	if facts.DiskTotal > 0 {
+		fmt.Fprintf(w, "  %-10s -> %v/%v free\n", "disk", formatSize(facts.DiskFree), formatSize(facts.DiskTotal))
+	}
+	if facts.Uptime > 0 {
+		fmt.Fprintf(w, "  %-10s -> %v\n", "uptime", time.Duration(facts.Uptime)*time.Second)
+	}
+	fmt.Fprintf(w, "  %-10s -> %v, started %v\n", "version", facts.Version, time.Unix(facts.Start/1000, 0).Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "  %-10s -> %v\n", "updated", time.Unix(facts.Time/1000, 0).Format("2006-01-02 15:04:05"))
+}
+
+//agentExec will execute the command on slaver agent of session and write the structured result to task.
+func (t *Terminal) agentExec(task *Task, session *SshSession, sid string, script []byte, cmds string) {
+	name := session.Name
+	runnable := cmds
+	if len(script) > 0 {
+		runnable = "bash -s -- " + cmds
+	}
+	query := url.Values{}
+	if len(session.Charset) > 0 {
+		query.Set("LC", session.Charset)
+	}
+	for _, env := range append(append([]string{}, t.Env...), session.SshHost.Env...) {
+		query.Add("env", env)
+	}
+	dial := t.dialRaw(session.Channel)
+	result, err := fsck.AgentExec(func(uri string) (raw io.ReadWriteCloser, err error) {
+		raw, err = dial(uri)
+		if err == nil {
+			task.Lck.Lock()
+			task.agents[sid] = raw
+			task.Lck.Unlock()
+		}
+		return
+	}, runnable, script, query)
+	t.taskLck.Lock()
+	defer t.taskLck.Unlock()
+	task.Lck.Lock()
+	delete(task.agents, sid)
+	if result != nil {
+		task.Results[name] = result
+	}
+	task.Lck.Unlock()
+	if err != nil {
+		task.errc++
+		fmt.Fprintf(task, "%v->done error(-1): %v\n", name, err)
+	} else {
+		if len(result.Stdout) > 0 {
+			fmt.Fprintf(task, "%v->stdout:\n%v", name, result.Stdout)
+			if !strings.HasSuffix(result.Stdout, "\n") {
+				fmt.Fprintf(task, "\n")
+			}
+		}
+		if len(result.Stderr) > 0 {
+			fmt.Fprintf(task, "%v->stderr:\n%v", name, result.Stderr)
+			if !strings.HasSuffix(result.Stderr, "\n") {
+				fmt.Fprintf(task, "\n")
+			}
+		}
+		if result.Truncated {
+			fmt.Fprintf(task, "%v->output is truncated\n", name)
+		}
+		if result.Success() {
+			delete(task.Subs, sid)
+			fmt.Fprintf(task, "%v->done well: %v used %vms\n", name, result, result.Used)
+		} else {
+			task.errc++
+			fmt.Fprintf(task, "%v->done error(%v): %v used %vms\n", name, result.Code, result, result.Used)
+		}
+	}
+	if len(task.Subs)-task.errc < 1 {
+		task.Close()
+	}
+}
+
 func (t *Terminal) handleMessage(message string) {
 	log.Printf("handle message->%v", message)
 	message = strings.TrimSpace(message)
@@ -956,6 +1490,12 @@ func (t *Terminal) loopPing(name string, delay time.Duration) {
 	for t.running {
 		used, call, back, err := t.C.PingSession(name, data)
 		t.pslck.Lock()
+		if _, found := t.pings[name]; !found { //all session on channel is removed
+			t.pslck.Unlock()
+			t.Alert.RemovePing(name)
+			break
+		}
+		t.Alert.ObservePing(name, used, err)
 		if err != nil {
 			log.Printf("Terminal ping to %v fail with %v", name, err)
 			t.pings[name] = "-1,-1,-1"
@@ -972,6 +1512,19 @@ func (t *Terminal) loopPing(name string, delay time.Duration) {
 	}
 }
 
+//removePing will stop the ping to channel when there is no session on it.
+func (t *Terminal) removePing(channel string) {
+	for em := t.ss.Front(); em != nil; em = em.Next() {
+		if em.Value.(*SshSession).Channel == channel {
+			return
+		}
+	}
+	t.pslck.Lock()
+	delete(t.pings, channel)
+	t.pslck.Unlock()
+	t.Alert.RemovePing(channel)
+}
+
 func (t *Terminal) NotifyTitle() {
 	pings := []string{}
 	t.pslck.RLock()
@@ -979,6 +1532,9 @@ func (t *Terminal) NotifyTitle() {
 		pings = append(pings, fmt.Sprintf("%v(%v)", name, ps))
 	}
 	t.pslck.RUnlock()
+	if alerts := t.Alert.Alerts(); len(alerts) > 0 {
+		pings = append(pings, fmt.Sprintf("ALERT(%v):%v", len(alerts), alerts[0]))
+	}
 	fmt.Fprintf(os.Stdout, "\033]0;%v(%v),%v\a", t.Name, t.ss.Len(), strings.Join(pings, ","))
 }
 
@@ -1064,13 +1620,17 @@ func (t *Terminal) AddSession(name, uri string, connect bool, env map[string]int
 	if err != nil {
 		return
 	}
-	fmt.Printf("add session by name(%v),channel(%v),host(%v),username(%v),password(%v)\n",
-		host.Name, host.Channel, host.URI, host.Username, host.Password)
 	session := NewSshSession(t.C, host)
 	session.PreEnv = t.Env
 	session.EnableCallback([]byte(t.CmdPrefix), t.callback)
 	session.Add(NewNamedWriter(name, t.Log))
-	if connect {
+	if host.Agent {
+		fmt.Printf("add agent session by name(%v),channel(%v)\n", host.Name, host.Channel)
+	} else {
+		fmt.Printf("add session by name(%v),channel(%v),host(%v),username(%v),password(%v)\n",
+			host.Name, host.Channel, host.URI, host.Username, host.Password)
+	}
+	if connect && !host.Agent {
 		err = session.Start()
 		if err != nil {
 			return
@@ -1099,6 +1659,13 @@ func (t *Terminal) AddUriForward(name, uri string) (m *fsck.Mapping, err error)
 	return
 }
 
+//newDialToken return the random token to dial file session by web.
+func newDialToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
 func (t *Terminal) SaveConf() {
 	if len(t.InstancePath) < 1 {
 		return
@@ -1116,10 +1683,11 @@ func (t *Terminal) SaveConf() {
 		_, name = filepath.Split(pwd)
 	}
 	newone := map[string]interface{}{
-		"web_url": t.WebSrv.URL,
-		"pwd":     pwd,
-		"name":    name,
-		"last":    util.Now(),
+		"web_url":    t.WebSrv.URL,
+		"dial_token": t.Forward.DialToken,
+		"pwd":        pwd,
+		"name":       name,
+		"last":       util.Now(),
 	}
 	for idx, cf := range conf {
 		cpwd, _ := cf["pwd"].(string)
@@ -1162,6 +1730,7 @@ func (t *Terminal) Start(conf *WorkConf) (err error) {
 	log.Printf("listen web on %v", t.WebSrv.URL)
 	t.Cmd.Raw.Env = append(t.Cmd.Raw.Env, t.Env...)
 	t.Cmd.AddEnvf("%v=%v", KeyWebCmdURL, t.WebSrv.URL)
+	t.Cmd.AddEnvf("%v=%v", KeyWebDialAuth, t.Forward.DialToken)
 	t.Cmd.AddEnvf("HISTFILE=/tmp/.sctrl_%v_history", t.Name)
 	t.Cmd.EnableCallback([]byte(t.CmdPrefix), t.callback)
 	t.Cmd.Add(NewNamedWriter(t.Cmd.Name, t.Log))
@@ -1184,6 +1753,22 @@ func (t *Terminal) Start(conf *WorkConf) (err error) {
 			fmt.Printf("add session fail with %v\n", err)
 		}
 	}
+	for name, tail := range conf.Tail {
+		fmt.Printf("add tail by %v,%v\n", name, tail)
+		_, err := t.Tail.Add(name, tail)
+		if err != nil {
+			fmt.Printf("add tail fail with %v\n", err)
+		}
+	}
+	if conf.Alert != nil {
+		err := t.Alert.Apply(conf.Alert)
+		if err == nil {
+			err = t.Alert.Start()
+		}
+		if err != nil {
+			fmt.Printf("start alert fail with %v\n", err)
+		}
+	}
 	for name, forward := range conf.Forward {
 		// if len(forward.Name) < 1 || len(forward.Remote.) < 1 {
 		// 	fmt.Printf("forward conf %v is not correct,name/remote must be setted\n", MarshalAll(forward))
@@ -1347,6 +1932,8 @@ func (t *Terminal) Close() (err error) {
 	t.WebSrv.Close()
 	fmt.Printf("closing forward channel server...\n")
 	t.Forward.Close()
+	t.Tail.Close()
+	t.Alert.Stop()
 	readkeyClose("cli")
 	t.running = false
 	fmt.Printf("clean done...\n")
@@ -103,6 +103,48 @@ var slsmapUsage = NewUsage("Sctrl slsmap version %v\n", Version).
 	Append("  name\n").
 	Append("       the forward alias\n")
 
+var saddtailUsage = NewUsage("Sctrl saddtail version %v\n", Version).
+	Append("       saddtail will follow the log file on slaver and show it by sctrl-log <name>\n").
+	Append("Usage: saddtail <name> <<slaver>tail:///path>\n").
+	Append("       saddtail nginx '<web1>tail:///var/log/nginx/access.log?lines=10'\n").
+	Append("       saddtail app '<web2>tail:///data/app/logs/*.log'\n").
+	Append("Options:\n").
+	Append("  name\n").
+	Append("       the log name\n").
+	Append("  lines/offset\n").
+	Append("       start from the last lines or byte offset, default is the end of file\n").
+	Append("  prefix\n").
+	Append("       prefix the line by file name, default is 1 when path is glob pattern\n")
+
+var srmtailUsage = NewUsage("Sctrl srmtail version %v\n", Version).
+	Append("       srmtail will stop the log file following by name\n").
+	Append("Usage: srmtail <name> <name1>\n").
+	Append("       srmtail nginx\n")
+
+var slstailUsage = NewUsage("Sctrl slstail version %v\n", Version).
+	Append("       slstail will show all log file following\n").
+	Append("Usage: slstail\n")
+
+var spsUsage = NewUsage("Sctrl sps version %v\n", Version).
+	Append("       sps will list the process on host by slaver agent\n").
+	Append("Usage: sps <host> [<name>] [-user=<user>] [-sort=pid|cpu|rss] [-limit=<count>]\n").
+	Append("       sps host1 nginx\n").
+	Append("       sps slaver1 -user=root -sort=cpu -limit=10\n").
+	Append("Options:\n").
+	Append("  host\n").
+	Append("       the agent host name or slaver name\n").
+	Append("  name\n").
+	Append("       filter by process name or command line contains\n")
+
+var skillUsage = NewUsage("Sctrl skill version %v\n", Version).
+	Append("       skill will send signal to process on host by slaver agent\n").
+	Append("Usage: skill <host> <pid> [<signal>]\n").
+	Append("       skill host1 1234\n").
+	Append("       skill host1 1234 KILL\n").
+	Append("Options:\n").
+	Append("  signal\n").
+	Append("       the signal name, HUP/INT/QUIT/KILL/TERM, default is TERM\n")
+
 var smasterUsage = NewUsage("Sctrl smaster version %v\n", Version).
 	Append("       smaster will show the master status\n").
 	Append("Usage: smaster\n")
@@ -116,6 +158,9 @@ var srealUsage = NewUsage("Sctrl sreal version %v\n", Version).
 	Append("       sreal will show the realtime log\n").
 	Append("Usage: sreal <slaver1,slaver2> [-host=<host1,host2>] [-timeout=2] field1=avg field2=sum\n").
 	Append("       sreal slaver1 -host=h1,h2,h3 avg1=avg sum1=sum\n").
+	Append("       sreal slaver1 -since=6h -step=10m cpu=avg mem_used=max\n").
+	Append("       sreal slaver1 -by=region cpu=avg latency=p99\n").
+	Append("       sreal slaver1 -delay=1 cpu=avg mem_used=sum\n").
 	Append("Options:\n").
 	Append("  slave\n").
 	Append("       the slaver name\n").
@@ -123,10 +168,29 @@ var srealUsage = NewUsage("Sctrl sreal version %v\n", Version).
 	Append("       the client name\n").
 	Append("  timeout\n").
 	Append("       the data timeout of notify log data\n").
+	Append("  delay\n").
+	Append("       keep showing the update from slaver at most once in delay seconds\n").
 	Append("  field=avg\n").
 	Append("       get the avg value of field from all host\n").
 	Append("  field=sum\n").
-	Append("       get the sum value of field from all host\n")
+	Append("       get the sum value of field from all host\n").
+	Append("  field=min/max\n").
+	Append("       get the min/max value of field from all host\n").
+	Append("  field=count/last\n").
+	Append("       get the count of host which has field, or the value of host which is updated last\n").
+	Append("  field=p50/p90/p99\n").
+	Append("       get the percentile value of field from all host\n").
+	Append("  field=rate\n").
+	Append("       get the sum of per second change of counter field from all host\n").
+	Append("  field=stddev\n").
+	Append("       get the standard deviation of field from all host\n").
+	Append("  by\n").
+	Append("       group the hosts by label value, the labels is updated by labels key of log\n").
+	Append("  since\n").
+	Append("       show the history series of field in duration like 30m/6h, the value of each host is aggregated by step first,\n").
+	Append("       only min/max/avg/sum is supported, the history must be enabled by slaver -realhistory\n").
+	Append("  step\n").
+	Append("       the step of history series, default is 1m\n")
 
 var spingUsage = NewUsage("Sctrl sping version %v\n", Version).
 	Append("       sping will ping to remote slaver and return the delay\n").
@@ -152,6 +216,11 @@ var shelpUsage = NewUsage("Sctrl version %v\n", Version).
 	Append("\n%v\n", saddmapUsage).
 	Append("\n%v\n", srmmapUsage).
 	Append("\n%v\n", slsmapUsage).
+	Append("\n%v\n", saddtailUsage).
+	Append("\n%v\n", srmtailUsage).
+	Append("\n%v\n", slstailUsage).
+	Append("\n%v\n", spsUsage).
+	Append("\n%v\n", skillUsage).
 	Append("\n%v\n", smasterUsage).
 	Append("\n%v\n", sslaverUsage).
 	Append("\n%v\n", srealUsage).
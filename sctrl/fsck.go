@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/user"
 	"path/filepath"
 	"reflect"
 	"strconv"
@@ -65,6 +67,8 @@ var realAddr string
 var webAddr string
 var webSuffix string
 var webAuth string
+var webTLSAddr string
+var webCA string
 var workspace string
 var cert string
 var key string
@@ -92,6 +96,8 @@ func regCommonFlags() {
 	flag.StringVar(&webAddr, "webaddr", "", "the web server listen address")
 	flag.StringVar(&webAuth, "webauth", "", "the web server basi auth")
 	flag.StringVar(&webSuffix, "websuffix", "", "the web server suffix")
+	flag.StringVar(&webTLSAddr, "webtlsaddr", "", "the web server https listen address, the certificate is issued by local CA")
+	flag.StringVar(&webCA, "webca", "", "the local CA directory for web https server, default is $HOME/.sctrl_ca")
 
 	flag.StringVar(&cert, "cert", "", "the cert file")
 	flag.StringVar(&key, "key", "", "the cert key")
@@ -137,11 +143,36 @@ func regClientFlags(alias bool) {
 var masterAddr string
 var slaverToken string
 var slaverName string
+var cmdTimeout, cmdCPU, cmdMem, cmdNoFile int
+var dialPolicy string
+var dialPlugins string
+var metricsDelay int
+var metricsProcs string
+var metricsLabels string
+var ingestAddr string
+var ingestFlush int
+var ingestLabels string
+var realHistory string
+var alertConf string
 
 func regSlaverFlags(alias bool) {
 	flag.StringVar(&masterAddr, "master", "sctrl.srv:9234", "the sctrl master server address")
 	flag.StringVar(&slaverToken, "auth", "", "the token for login to server")
 	flag.StringVar(&slaverName, "name", "", "the slaver name")
+	flag.IntVar(&cmdTimeout, "cmdtimeout", 0, "the max wall-clock timeout in seconds for command")
+	flag.IntVar(&cmdCPU, "cmdcpu", 0, "the max cpu time in seconds for command")
+	flag.IntVar(&cmdMem, "cmdmem", 0, "the max virtual memory in MB for command")
+	flag.IntVar(&cmdNoFile, "cmdnofile", 0, "the max open files for command")
+	flag.StringVar(&dialPolicy, "policy", "", "the local dial policy file")
+	flag.StringVar(&dialPlugins, "plugins", "", "the dial plugin configure file, it is mapping uri scheme to plugin executable")
+	flag.IntVar(&metricsDelay, "metrics", 0, "the interval in seconds to collect host metrics to realtime log, 0 is disabled")
+	flag.StringVar(&metricsProcs, "metricsprocs", "", "the process names to collect metrics, separated by comma")
+	flag.StringVar(&metricsLabels, "metricslabels", "", "the labels of metrics to group by sreal, like region=eu,role=db")
+	flag.StringVar(&ingestAddr, "ingest", "", "the udp/tcp listen address to receive statsd and influxdb line protocol metrics to realtime log, like :8125")
+	flag.IntVar(&ingestFlush, "ingestflush", 10, "the interval in seconds to flush the ingested metrics to realtime log")
+	flag.StringVar(&ingestLabels, "ingestlabels", "", "the tag names of ingested metrics to group by sreal, like region,role")
+	flag.StringVar(&alertConf, "alert", "", "the alert configure file, it is evaluated on realtime log")
+	flag.StringVar(&realHistory, "realhistory", "", "the file to persist the realtime history, it is saved every minute, the history is disabled when it is empty")
 	if !alias {
 		flag.BoolVar(&runClient, "sc", false, "run as slaver client")
 	}
@@ -398,6 +429,76 @@ func printProfileUsage(code int, alias bool) {
 	exitf(code)
 }
 
+func printExportCAUsage(code int, alias bool) {
+	_, name := filepath.Split(os.Args[0])
+	if alias {
+		name = "sctrl-exportca"
+	}
+	fmt.Fprintf(os.Stderr, "Sctrl exportca version %v\n", Version)
+	if alias {
+		fmt.Fprintf(os.Stderr, "Usage:  %v [-webca <dir>]\n", name)
+		fmt.Fprintf(os.Stderr, "        %v > sctrl-ca.pem\n", name)
+	} else {
+		fmt.Fprintf(os.Stderr, "Usage:  %v -exportca [-webca <dir>]\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -exportca > sctrl-ca.pem\n", name)
+	}
+	fmt.Fprintf(os.Stderr, "Exportca options:\n")
+	flag.PrintDefaults()
+	exitf(code)
+}
+
+func printTapdumpUsage(code int, alias bool) {
+	_, name := filepath.Split(os.Args[0])
+	if alias {
+		name = "sctrl-tapdump"
+	}
+	fmt.Fprintf(os.Stderr, "Sctrl tapdump version %v\n", Version)
+	if alias {
+		fmt.Fprintf(os.Stderr, "Usage:  %v [-x] <tap file...>\n", name)
+		fmt.Fprintf(os.Stderr, "        %v /tmp/sctrl-tap/x-20180101000000-1.tap\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -x /tmp/sctrl-tap/x-20180101000000-1.pcapng\n", name)
+	} else {
+		fmt.Fprintf(os.Stderr, "Usage:  %v -tapdump [-x] <tap file...>\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -tapdump /tmp/sctrl-tap/x-20180101000000-1.tap\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -tapdump -x /tmp/sctrl-tap/x-20180101000000-1.pcapng\n", name)
+	}
+	fmt.Fprintf(os.Stderr, "Tapdump options:\n")
+	flag.PrintDefaults()
+	exitf(code)
+}
+
+func printTransferUsage(code int, put, alias bool) {
+	_, name := filepath.Split(os.Args[0])
+	op := "get"
+	if put {
+		op = "put"
+	}
+	if alias {
+		name = "sctrl-" + op
+	}
+	fmt.Fprintf(os.Stderr, "Sctrl %v version %v\n", op, Version)
+	if alias && put {
+		fmt.Fprintf(os.Stderr, "Usage:  %v <local...> <slaver>:<remote>\n", name)
+		fmt.Fprintf(os.Stderr, "        %v a.txt x1:/tmp/\n", name)
+		fmt.Fprintf(os.Stderr, "        %v /data/dir x1:/data/dir\n", name)
+		fmt.Fprintf(os.Stderr, "        %v a.txt http://x.xxx.com:2232/xx/\n", name)
+	} else if alias {
+		fmt.Fprintf(os.Stderr, "Usage:  %v <slaver>:<remote> <local>\n", name)
+		fmt.Fprintf(os.Stderr, "        %v x1:/tmp/a.txt ./\n", name)
+		fmt.Fprintf(os.Stderr, "        %v x1:/data/dir /data/dir\n", name)
+	} else if put {
+		fmt.Fprintf(os.Stderr, "Usage:  %v -put <local...> <slaver>:<remote>\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -put a.txt x1:/tmp/\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -put /data/dir x1:/data/dir\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -put a.txt http://x.xxx.com:2232/xx/\n", name)
+	} else {
+		fmt.Fprintf(os.Stderr, "Usage:  %v -get <slaver>:<remote> <local>\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -get x1:/tmp/a.txt ./\n", name)
+		fmt.Fprintf(os.Stderr, "        %v -get x1:/data/dir /data/dir\n", name)
+	}
+	exitf(code)
+}
+
 func printShellUsage(code int, alias bool) {
 	_, name := filepath.Split(os.Args[0])
 	if alias {
@@ -594,6 +695,40 @@ func main() {
 			}
 		}
 		sctrlExec("profile", nil, false)
+	case name == "sctrl-exportca" || mode == "-exportca":
+		flag.BoolVar(&help, "h", false, "show help")
+		flag.StringVar(&webCA, "webca", "", "the local CA directory, default is $HOME/.sctrl_ca")
+		if mode == "-exportca" {
+			flag.CommandLine.Parse(os.Args[2:])
+		} else {
+			flag.Parse()
+		}
+		if help {
+			printExportCAUsage(0, name == "sctrl-exportca")
+		}
+		sctrlExportCA()
+	case name == "sctrl-tapdump" || mode == "-tapdump":
+		flag.BoolVar(&help, "h", false, "show help")
+		flag.BoolVar(&tapHex, "x", false, "show data in hexdump")
+		if mode == "-tapdump" {
+			flag.CommandLine.Parse(os.Args[2:])
+		} else {
+			flag.Parse()
+		}
+		if help || flag.NArg() < 1 {
+			printTapdumpUsage(0, name == "sctrl-tapdump")
+		}
+		sctrlTapdump(flag.Args()...)
+	case name == "sctrl-put" || mode == "-put" || name == "sctrl-get" || mode == "-get":
+		put := name == "sctrl-put" || mode == "-put"
+		args := os.Args[1:]
+		if mode == "-put" || mode == "-get" {
+			args = os.Args[2:]
+		}
+		if len(args) < 2 || args[0] == "-h" {
+			printTransferUsage(1, put, name == "sctrl-put" || name == "sctrl-get")
+		}
+		sctrlTransfer(put, args...)
 	case mode == "-h":
 		printAllUsage(0)
 	default:
@@ -613,6 +748,156 @@ func sctrlEcho() {
 	make(chan int) <- 0
 }
 
+func loadWebCA() (ca *fsck.CA, err error) {
+	dir := webCA
+	if len(dir) < 1 {
+		var usr *user.User
+		usr, err = user.Current()
+		if err != nil {
+			return
+		}
+		dir = filepath.Join(usr.HomeDir, ".sctrl_ca")
+	}
+	ca, err = fsck.LoadCA(dir)
+	return
+}
+
+func sctrlExportCA() {
+	ca, err := loadWebCA()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load CA fail with %v\n", err)
+		exitf(1)
+	}
+	ca.ExportCA(os.Stdout)
+	exitf(0)
+}
+
+var tapHex bool
+
+func sctrlTapdump(files ...string) {
+	for _, file := range files {
+		in, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open tap file fail with %v\n", err)
+			exitf(1)
+		}
+		fmt.Printf("==> %v <==\n", file)
+		err = fsck.ReadTap(in, func(record *fsck.TapRecord) error {
+			dir := ">"
+			if record.Dir == fsck.TapIn {
+				dir = "<"
+			}
+			fmt.Printf("%v %v %v bytes\n", record.Time.Format("15:04:05.000000"), dir, len(record.Data))
+			if tapHex {
+				fmt.Print(hex.Dump(record.Data))
+			} else {
+				fmt.Printf("%s\n", bytes.Map(func(r rune) rune {
+					if r == '\n' || r == '\t' || (r >= 32 && r < 127) {
+						return r
+					}
+					return '.'
+				}, record.Data))
+			}
+			return nil
+		})
+		in.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read tap file fail with %v\n", err)
+			exitf(1)
+		}
+	}
+	exitf(0)
+}
+
+func sctrlTransfer(put bool, args ...string) {
+	srvAddr, _, err := findWebURL("", true, true, true, 5*time.Second)
+	if err != nil {
+		fmt.Printf("find sctrl client fail with %v\n", err)
+		exitf(1)
+	}
+	rurl, err := url.Parse(srvAddr)
+	if err != nil {
+		fmt.Printf("parse sctrl client url fail with %v\n", err)
+		exitf(1)
+	}
+	var channel string
+	transfer := fsck.NewFileTransfer(func(uri string) (raw io.ReadWriteCloser, err error) {
+		query := url.Values{}
+		query.Set("channel", channel)
+		query.Set("uri", uri)
+		query.Set("token", findDialToken(srvAddr))
+		raw, err = websocket.Dial("ws://"+rurl.Host+"/dial?"+query.Encode(), "", srvAddr)
+		if err != nil {
+			err = fmt.Errorf("dial to %v on %v fail with %v", uri, channel, err)
+		}
+		return
+	})
+	var parseRemote = func(arg string) (remote string) {
+		parts := strings.SplitN(arg, ":", 2)
+		if len(parts) < 2 || len(parts[0]) < 1 || len(parts[1]) < 1 {
+			fmt.Printf("invalid remote %v, it must be <slaver>:<remote>\n", arg)
+			exitf(1)
+		}
+		channel = parts[0]
+		return parts[1]
+	}
+	if put && (strings.HasPrefix(args[len(args)-1], "http://") || strings.HasPrefix(args[len(args)-1], "https://")) {
+		//put to webdav forward
+		for _, local := range args[:len(args)-1] {
+			err = webdavPut(local, args[len(args)-1])
+			if err != nil {
+				break
+			}
+		}
+	} else if put {
+		remote := parseRemote(args[len(args)-1])
+		locals := args[:len(args)-1]
+		if len(locals) > 1 && !strings.HasSuffix(remote, "/") {
+			remote += "/"
+		}
+		for _, local := range locals {
+			err = transfer.Put(local, remote)
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		remote := parseRemote(args[0])
+		err = transfer.Get(remote, args[1])
+	}
+	if err != nil {
+		fmt.Printf("-error: %v\n", err)
+		exitf(1)
+	}
+	exitf(0)
+}
+
+func webdavPut(local, remote string) (err error) {
+	file, err := os.Open(local)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if strings.HasSuffix(remote, "/") {
+		remote += filepath.Base(local)
+	}
+	req, err := http.NewRequest("PUT", remote, file)
+	if err != nil {
+		return
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		err = fmt.Errorf("put %v to %v fail with status %v", local, remote, res.Status)
+		return
+	}
+	fmt.Printf("put %v -> %v done\n", local, remote)
+	return
+}
+
 var server *fsck.Server
 
 func sctrlServer() {
@@ -651,6 +936,21 @@ func sctrlServer() {
 		go func() {
 			fmt.Println(routing.ListenAndServe(webAddr))
 		}()
+		if len(webTLSAddr) > 0 {
+			ca, err := loadWebCA()
+			if err != nil {
+				gwflog.E("server load web CA fail with %v", err)
+				os.Exit(1)
+				return
+			}
+			ca.Allowed = server.Forward.AllowedHost
+			webui.CA = ca
+			gwflog.D("run web https server by listen:%v,ca:%v", webTLSAddr, ca.Dir)
+			go func() {
+				srv := &http.Server{Addr: webTLSAddr, Handler: routing.Shared, TLSConfig: ca.TLSConfig()}
+				fmt.Println(srv.ListenAndServeTLS("", ""))
+			}()
+		}
 	}
 	if len(cert) > 0 {
 		gwflog.D("server load x509 cert:%v,key:%v", cert, key)
@@ -686,7 +986,40 @@ func sctrlSlaver() {
 	impl.ShowLog = loglevel > 3
 	slaver := fsck.NewSlaver("slaver")
 	slaver.HbDelay = int64(hbdelay)
+	slaver.Version = Version
 	slaver.SP.RegisterDefaulDialer()
+	if len(dialPolicy) > 0 {
+		policy, err := fsck.LoadDialPolicy(dialPolicy)
+		if err != nil {
+			gwflog.E("slaver load dial policy from %v fail with %v", dialPolicy, err)
+			os.Exit(1)
+			return
+		}
+		gwflog.D("slaver load dial policy from %v", dialPolicy)
+		slaver.SP.Policy = policy
+	}
+	if len(dialPlugins) > 0 {
+		dialers, err := fsck.LoadPluginDialers(dialPlugins)
+		if err != nil {
+			gwflog.E("slaver load dial plugins from %v fail with %v", dialPlugins, err)
+			os.Exit(1)
+			return
+		}
+		for _, dialer := range dialers {
+			gwflog.D("slaver add plugin dialer %v by %v", dialer, dialer.Path)
+			slaver.SP.InsertDialer(dialer)
+		}
+	}
+	for _, dialer := range slaver.SP.Dialers {
+		if cmdDialer, ok := dialer.(*fsck.CmdDialer); ok {
+			cmdDialer.Limit = fsck.CmdLimit{
+				Timeout: time.Duration(cmdTimeout) * time.Second,
+				CPU:     cmdCPU,
+				Mem:     cmdMem,
+				NoFile:  cmdNoFile,
+			}
+		}
+	}
 	if len(cert) > 0 {
 		gwflog.D("slaver load x509 cert:%v,key:%v", cert, key)
 		cert, err := tls.LoadX509KeyPair(cert, key)
@@ -702,10 +1035,94 @@ func sctrlSlaver() {
 			return
 		}
 	}
+	if len(alertConf) > 0 {
+		conf, err := fsck.ReadAlertConf(alertConf)
+		if err == nil {
+			slaver.Alert = fsck.NewAlertManager(slaver.Real)
+			err = slaver.Alert.Apply(conf)
+		}
+		if err == nil {
+			for _, rule := range slaver.Alert.Rules {
+				if rule.Kind == "ping" { //the ping is observed by terminal only
+					err = fmt.Errorf("the ping rule(%v) is not supported on slaver", rule)
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = slaver.Alert.Start()
+		}
+		if err != nil {
+			gwflog.E("slaver load alert from %v fail with %v", alertConf, err)
+			os.Exit(1)
+			return
+		}
+	}
 	slaver.StartSlaver(masterAddr, slaverName, slaverToken)
+	if metricsDelay > 0 {
+		metrics := fsck.NewMetricsCollector(slaver.Real, slaverName)
+		metrics.Interval = time.Duration(metricsDelay) * time.Second
+		if len(metricsProcs) > 0 {
+			metrics.Procs = strings.Split(metricsProcs, ",")
+		}
+		if len(metricsLabels) > 0 {
+			metrics.Labels = util.Map{}
+			for _, label := range strings.Split(metricsLabels, ",") {
+				parts := strings.SplitN(label, "=", 2)
+				if len(parts) > 1 {
+					metrics.Labels[parts[0]] = parts[1]
+				}
+			}
+		}
+		err := metrics.Start()
+		if err != nil {
+			gwflog.E("slaver start metrics collector fail with %v", err)
+		}
+	}
+	if len(ingestAddr) > 0 {
+		if ingestFlush < 1 {
+			gwflog.E("slaver start ingester fail with the ingestflush(%v) must be greater than zero", ingestFlush)
+			os.Exit(1)
+			return
+		}
+		ingester := fsck.NewRealIngester(slaver.Real, slaverName)
+		ingester.Flush = time.Duration(ingestFlush) * time.Second
+		if len(ingestLabels) > 0 {
+			ingester.Labels = strings.Split(ingestLabels, ",")
+		}
+		err := ingester.ListenUDP(ingestAddr)
+		if err == nil {
+			err = ingester.ListenTCP(ingestAddr)
+		}
+		if err == nil {
+			err = ingester.Start()
+		}
+		if err != nil {
+			gwflog.E("slaver start ingester on %v fail with %v", ingestAddr, err)
+			os.Exit(1)
+			return
+		}
+	}
 	routing.Shared.HFunc("/real/update", slaver.Real.UpdateH)
 	routing.Shared.HFunc("/real/show", slaver.Real.ShowH)
 	routing.Shared.HFunc("/real/list", slaver.Real.ListH)
+	routing.Shared.HFunc("/real/query", slaver.Real.QueryH)
+	if len(realHistory) > 0 {
+		slaver.Real.History = fsck.NewRealHistory()
+		err := slaver.Real.History.Load(realHistory)
+		if err != nil && !os.IsNotExist(err) {
+			gwflog.W("slaver load realtime history from %v fail with %v", realHistory, err)
+		}
+		go func() {
+			for {
+				time.Sleep(time.Minute)
+				err := slaver.Real.History.Save(realHistory)
+				if err != nil {
+					gwflog.W("slaver save realtime history to %v fail with %v", realHistory, err)
+				}
+			}
+		}()
+	}
 	wait := make(chan int)
 	<-wait
 	exitf(0)
@@ -814,6 +1231,17 @@ func sctrlClient() {
 	terminal = NewTerminal(client, name, ps1, bash, webcmd, buffered)
 	terminal.InstancePath = instancePath
 	terminal.WebSrv.Addr = webAddr
+	if len(webTLSAddr) > 0 {
+		ca, err := loadWebCA()
+		if err != nil {
+			fmt.Printf("load web CA fail with %v\n", err)
+			exitf(1)
+		}
+		ca.Allowed = terminal.Forward.AllowedHost
+		terminal.WebUI.CA = ca
+		terminal.WebSrv.TLSAddr = webTLSAddr
+		terminal.WebSrv.TLSConfig = ca.TLSConfig()
+	}
 	terminal.Forward.WebAuth = webAuth
 	terminal.Forward.WebSuffix = webSuffix
 	if len(workspace) > 0 {
@@ -1071,6 +1499,33 @@ func sctrlReal() {
 	exitf(1)
 }
 
+//findDialToken return the token to dial file session on web url by environment or instance configure.
+func findDialToken(webURL string) (token string) {
+	token = os.Getenv(KeyWebDialAuth)
+	if len(token) > 0 {
+		return
+	}
+	for _, confPath := range []string{
+		filepath.Join(os.Getenv("HOME"), ".sctrl_instance.json"),
+		filepath.Join(os.Getenv("TMPDIR"), ".sctrl_instance.json"),
+		filepath.Join("/tmp", ".sctrl_instance.json"),
+	} {
+		data, err := ioutil.ReadFile(confPath)
+		if err != nil {
+			continue
+		}
+		confList := []util.Map{}
+		json.Unmarshal(data, &confList)
+		for _, conf := range confList {
+			if conf.StrVal("web_url") == webURL {
+				token = conf.StrVal("dial_token")
+				return
+			}
+		}
+	}
+	return
+}
+
 func findWebURL(last string, log, wait, signle bool, delay time.Duration) (url string, pwd string, err error) {
 	url = os.Getenv(KeyWebCmdURL)
 	if len(url) > 0 {
@@ -1251,6 +1706,7 @@ func sctrlShell(uri string) {
 	var err error
 	var conn io.ReadWriteCloser
 	var rurl *url.URL
+	var winsize bool //only the named session is forwarded to command session which accept window size command.
 	if strings.Contains(uri, "://") {
 		rurl, err = url.Parse(uri)
 		if err == nil {
@@ -1270,7 +1726,8 @@ func sctrlShell(uri string) {
 		srvAddr, _, err = findWebURL("", true, true, true, 5*time.Second)
 		rurl, err = url.Parse(srvAddr)
 		if err == nil {
-			conn, err = websocket.Dial("ws://"+rurl.Host+"/ws/"+uri, "", srvAddr)
+			conn, err = websocket.Dial("ws://"+rurl.Host+"/ws/"+uri+"?winsize=1", "", srvAddr)
+			winsize = true
 		}
 	}
 	if err != nil {
@@ -1283,6 +1740,21 @@ func sctrlShell(uri string) {
 		readkeyClose("shell")
 		exitf(0)
 	}()
+	if winsize {
+		go func() {
+			//send window size on start and resize
+			winch := make(chan os.Signal, 1)
+			readkeyNotifyResize(winch)
+			for {
+				w, h := readkeyGetSize()
+				_, err := conn.Write(fsck.WinSizeCmd(w, h))
+				if err != nil {
+					break
+				}
+				<-winch
+			}
+		}()
+	}
 	go func() {
 		io.Copy(os.Stdout, conn)
 		fmt.Printf("connection is closed\n")
@@ -6,6 +6,9 @@ ln -sf $GOPATH/bin/sctrl $GOPATH/bin/sctrl-slaver
 ln -sf $GOPATH/bin/sctrl $GOPATH/bin/sctrl-log
 ln -sf $GOPATH/bin/sctrl $GOPATH/bin/sctrl-profile
 ln -sf $GOPATH/bin/sctrl $GOPATH/bin/sctrl-shell
+ln -sf $GOPATH/bin/sctrl $GOPATH/bin/sctrl-tapdump
+ln -sf $GOPATH/bin/sctrl $GOPATH/bin/sctrl-put
+ln -sf $GOPATH/bin/sctrl $GOPATH/bin/sctrl-get
 ln -sf $GOPATH/src/github.com/sutils/fsck/sctrl/sctrl-ssh.sh $GOPATH/bin/sctrl-ssh
 ln -sf $GOPATH/src/github.com/sutils/fsck/sctrl/sctrl-scp.sh $GOPATH/bin/sctrl-scp
 ln -sf $GOPATH/src/github.com/sutils/fsck/sctrl/sctrl-ws.sh $GOPATH/bin/sctrl-ws
@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/sutils/readkey"
 )
@@ -31,3 +33,7 @@ var readkeyGetSize = func() (w, h int) {
 var readkeySetSize = func(fd uintptr, w, h int) (err error) {
 	return readkey.SetSize(fd, w, h)
 }
+
+var readkeyNotifyResize = func(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
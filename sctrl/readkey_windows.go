@@ -28,3 +28,6 @@ var readkeyGetSize = func() (w, h int) {
 var readkeySetSize = func(fd uintptr, w, h int) (err error) {
 	return nil
 }
+
+var readkeyNotifyResize = func(c chan os.Signal) {
+}
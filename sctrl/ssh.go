@@ -15,6 +15,7 @@ import (
 
 	"github.com/sutils/fsck"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/text/encoding"
 )
 
 type NetAddr struct {
@@ -109,7 +110,9 @@ type SshHost struct {
 	Password string   `json:"password"`
 	Channel  string   `json:"channel"`
 	Pty      string   `json:"pty"`
+	Charset  string   `json:"charset"`
 	Env      []string `json:"env"`
+	Agent    bool     `json:"agent"` //execute command by slaver agent instead of ssh.
 }
 
 func ParseSshHost(name, uri string, env map[string]interface{}) (host *SshHost, err error) {
@@ -137,6 +140,8 @@ func ParseSshHost(name, uri string, env map[string]interface{}) (host *SshHost,
 	if len(pty) > 0 {
 		host.Pty = pty
 	}
+	host.Charset = ruri.Query().Get("charset")
+	host.Agent = ruri.Host == "agent" || ruri.Query().Get("agent") == "1"
 	for key, val := range env {
 		host.Env = append(host.Env, fmt.Sprintf("%v=%v", key, val))
 	}
@@ -184,6 +189,10 @@ func (s *SshSession) DisableCallback() {
 }
 
 func (s *SshSession) Start() (err error) {
+	if s.Agent {
+		err = fmt.Errorf("session(%v) is agent mode, only sexec/seval is supported", s.Name)
+		return
+	}
 	session, err := s.C.DialSession(s.Channel, s.URI, nil)
 	if err == nil {
 		s.conn = NewSshNetConn(s.URI, session)
@@ -211,9 +220,14 @@ func (s *SshSession) StartSession(con net.Conn) (err error) {
 	if err != nil {
 		return
 	}
-	s.session.Stdout = s.MultiWriter
-	s.session.Stderr = s.MultiWriter
-	s.stdin, _ = s.session.StdinPipe()
+	enc, err := s.lookupCharset()
+	if err != nil {
+		return
+	}
+	s.session.Stdout = fsck.NewCharsetOutWriter(s.MultiWriter, enc)
+	s.session.Stderr = fsck.NewCharsetOutWriter(s.MultiWriter, enc)
+	stdin, _ := s.session.StdinPipe()
+	s.stdin = fsck.NewCharsetWriter(stdin, enc)
 	// Request pseudo terminal
 	modes := ssh.TerminalModes{
 	// ssh.ECHO:          0,     // Disable echoing
@@ -252,6 +266,26 @@ func (s *SshSession) StartSession(con net.Conn) (err error) {
 	return
 }
 
+//lookupCharset will find the encoding of remote host, it detect charset by locale command when charset is auto.
+func (s *SshSession) lookupCharset() (enc encoding.Encoding, err error) {
+	charset := s.Charset
+	if charset == "auto" {
+		var session *ssh.Session
+		session, err = s.client.NewSession()
+		if err != nil {
+			return
+		}
+		out, _ := session.Output("locale charmap")
+		session.Close()
+		charset = strings.TrimSpace(string(out))
+	}
+	enc, err = fsck.LookupCharset(charset)
+	if err == nil && enc != nil {
+		fmt.Printf("%v using charset %v\n", s.Name, charset)
+	}
+	return
+}
+
 func (s *SshSession) Wait() (err error) {
 	if s.session == nil {
 		err = fmt.Errorf("not started")
@@ -37,6 +37,24 @@ func TestParseSshHost(t *testing.T) {
 		t.Error(host)
 		return
 	}
+	host, err = ParseSshHost("abc", "mx://root:sco@loc.m?pty=vt100&charset=auto", nil)
+	if err != nil || host.Pty != "vt100" || host.Charset != "auto" {
+		fmt.Println(err)
+		t.Error(host)
+		return
+	}
+	host, err = ParseSshHost("abc", "mx://agent", nil)
+	if err != nil || host.Channel != "mx" || !host.Agent {
+		fmt.Println(err)
+		t.Error(host)
+		return
+	}
+	host, err = ParseSshHost("abc", "mx://loc.m?agent=1", nil)
+	if err != nil || !host.Agent {
+		fmt.Println(err)
+		t.Error(host)
+		return
+	}
 	_, err = ParseSshHost("abc", "mx://%Xx", nil)
 	if err == nil {
 		t.Error(err)
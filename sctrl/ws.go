@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
+
+	"github.com/sutils/fsck"
 )
 
 type Host struct {
@@ -21,6 +23,8 @@ type WorkConf struct {
 	Instance string                 `json:"instance"`
 	Hosts    []*Host                `json:"hosts"`
 	Forward  map[string]string      `json:"forward"`
+	Tail     map[string]string      `json:"tail"`
+	Alert    *fsck.AlertConf        `json:"alert"`
 	Env      map[string]interface{} `json:"env"`
 }
 
@@ -81,6 +81,7 @@ type Master struct {
 	sidc    uint16
 	//
 	pings map[uint16]int64
+	facts map[string]util.Map //the last known host facts by slaver name
 	//
 	NewListenerF func(l *netw.Listener) (raw net.Listener, err error)
 }
@@ -91,6 +92,7 @@ func NewMaster() *Master {
 		slck:    sync.RWMutex{},
 		slavers: map[string]string{},
 		clients: map[string]string{},
+		facts:   map[string]util.Map{},
 		ni2s:    map[string]string{},
 		si2n:    map[string]string{},
 		pings:   map[uint16]int64{},
@@ -116,9 +118,11 @@ func (m *Master) Run(rcaddr string, ts map[string]int) (err error) {
 	m.L.AddFFunc("^/usr/.*$", m.AccessH)
 	m.L.AddHFunc("/usr/dial", m.DialH)
 	m.L.AddHFunc("/usr/close", m.CloseH)
+	m.L.AddHFunc("/usr/signal", m.SignalH)
 	m.L.AddHFunc("/usr/list", m.ListH)
 	m.L.AddHFunc("/usr/status", m.StatusH)
 	m.L.AddHFunc("/usr/real_log", m.RealLogH)
+	m.L.AddHFunc("/usr/real_query", m.RealQueryH)
 	m.L.AddHFunc("ping", m.PingH)
 	m.L.NewListenerF = m.NewListenerF
 	err = m.L.Run()
@@ -205,6 +209,7 @@ func (m *Master) OnLogin(rc *impl.RCM_Cmd, token string) (cid string, err error)
 	m.L.AddC_rc(cid, rc)
 	m.L.CloseC(old)
 	if ctype == TypeSlaver {
+		go m.refreshFacts(name, cid)
 		log.D("Master accept slaver connect by name(%v) from %v", name, rc.RemoteAddr())
 	} else {
 		log.D("Master accept client connect by session(%v) from %v", session, rc.RemoteAddr())
@@ -246,13 +251,70 @@ func (m *Master) StatusH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 		} else {
 			res["status"] = "ok"
 			allres[name] = res
+			m.cacheFacts(name, res)
 		}
 	}
+	//using the last known facts when slaver is offline or status fail.
+	m.slck.RLock()
+	for _, name := range ns {
+		facts := m.facts[name]
+		if facts == nil {
+			continue
+		}
+		res, ok := allres[name].(util.Map)
+		if !ok {
+			res = util.Map{
+				"status": "offline",
+			}
+			allres[name] = res
+		}
+		if res.MapVal("facts") == nil {
+			res["facts"] = facts
+		}
+	}
+	m.slck.RUnlock()
 	val = allres
 	return
 }
 
+//refreshFacts will fetch the host facts from slaver and cache it, it is called when slaver login,
+//so the facts is still known after the slaver is offline.
+func (m *Master) refreshFacts(name, cid string) {
+	cmdc := m.L.CmdC(cid)
+	if cmdc == nil {
+		return
+	}
+	res, err := cmdc.Exec_m("status", util.Map{})
+	if err != nil {
+		log.D("Master fetch facts from slaver(%v) fail with %v", name, err)
+		return
+	}
+	m.cacheFacts(name, res)
+}
+
+func (m *Master) cacheFacts(name string, res util.Map) {
+	facts := res.MapVal("facts")
+	if facts == nil {
+		return
+	}
+	m.slck.Lock()
+	m.facts[name] = facts
+	m.slck.Unlock()
+}
+
 func (m *Master) RealLogH(rc *impl.RCM_Cmd) (val interface{}, err error) {
+	val, err = m.execSlavers(rc, "real_log")
+	return
+}
+
+//RealQueryH will query the realtime history series on slavers, see RealTime.Query for detail.
+func (m *Master) RealQueryH(rc *impl.RCM_Cmd) (val interface{}, err error) {
+	val, err = m.execSlavers(rc, "real_query")
+	return
+}
+
+//execSlavers will execute the command with arguments on all slavers by name.
+func (m *Master) execSlavers(rc *impl.RCM_Cmd, cmd string) (val interface{}, err error) {
 	var ns []string
 	err = rc.ValidF(`
 		name,R|S,L:0;
@@ -278,7 +340,7 @@ func (m *Master) RealLogH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 			}
 			continue
 		}
-		res, err := cmdc.Exec_m("real_log", *rc.Map)
+		res, err := cmdc.Exec_m(cmd, *rc.Map)
 		if err != nil {
 			allres[name] = util.Map{
 				"status": err.Error(),
@@ -430,9 +492,13 @@ func (m *Master) CloseH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 		err = fmt.Errorf("not login?")
 		return
 	}
-	log.D("Master closing session(%v) by name:%v,client:%v,cid:%v", sid, name, session, cid)
+	exit := rc.MapVal("exit")
+	log.D("Master closing session(%v) by name:%v,client:%v,cid:%v,exit:%v", sid, name, session, cid, exit)
 	if session == "master" {
 		session := m.SP.Find(sid)
+		if sids, ok := session.(*SidSession); ok && exit != nil {
+			sids.SetExitStatus(ParseExitStatus(exit))
+		}
 		if session != nil {
 			session.Close()
 		}
@@ -450,9 +516,13 @@ func (m *Master) CloseH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 		log.D("Master close session(%v) on name(%v) fail with %v", sid, name, err)
 		return
 	}
-	val, err = cmdc.Exec_m("close", util.Map{
+	args := util.Map{
 		"sid": sid,
-	})
+	}
+	if exit != nil {
+		args["exit"] = exit
+	}
+	val, err = cmdc.Exec_m("close", args)
 	if err == nil {
 		log.D("Master close session(%v) on name(%v) success", sid, name)
 	} else {
@@ -461,6 +531,48 @@ func (m *Master) CloseH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 	return
 }
 
+func (m *Master) SignalH(rc *impl.RCM_Cmd) (val interface{}, err error) {
+	var sid uint16
+	var signal string
+	err = rc.ValidF(`
+		sid,R|I,R:0;
+		signal,R|S,L:0;
+		`, &sid, &signal)
+	if err != nil {
+		return
+	}
+	session := rc.Kvs().StrVal("session")
+	if len(session) < 1 {
+		err = fmt.Errorf("the session is empty, not login?")
+		return
+	}
+	err = m.Signal(session, sid, signal)
+	return
+}
+
+//Signal will send signal to the remote raw of session which is dialed by session.
+func (m *Master) Signal(session string, sid uint16, signal string) (err error) {
+	m.slck.RLock()
+	name := m.si2n[fmt.Sprintf("%v-%v", session, sid)]
+	cid := m.slavers[name]
+	m.slck.RUnlock()
+	if len(name) < 1 {
+		err = fmt.Errorf("session(%v) is not found", sid)
+		return
+	}
+	cmdc := m.L.CmdC(cid)
+	if cmdc == nil {
+		err = fmt.Errorf("slaver not found")
+		return
+	}
+	_, err = cmdc.Exec_m("signal", util.Map{
+		"sid":    sid,
+		"signal": signal,
+	})
+	log.D("Master send signal(%v) to session(%v) on name(%v) done with %v", signal, sid, name, err)
+	return
+}
+
 func (m *Master) PingH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 	var name string
 	err = rc.ValidF(`
@@ -646,6 +758,8 @@ type Slaver struct {
 	OnLogin func(a *rc.AutoLoginH, err error)
 	Real    *RealTime
 	Forward *Forward
+	Version string //the slaver binary version.
+	Alert   *AlertManager
 	//
 	DailAddr func(addr string) (raw net.Conn, err error)
 }
@@ -730,6 +844,13 @@ func (s *Slaver) Start(rcaddr, name, session, token, ctype string) (err error) {
 	auto.Runner = s.R
 	s.Channel = NewChannel(s.R.RCBH, s.R.RCM_Con.RC_Con, s.R.RCM_Con, s.R.RCM_S, s.SP)
 	s.Channel.Real = s.Real
+	for _, dialer := range s.SP.Dialers {
+		if rd, ok := dialer.(*RealDialer); ok && rd.Real == nil {
+			rd.Real = s.Real
+		}
+	}
+	s.Channel.Version = s.Version
+	s.Channel.Alert = s.Alert
 	s.Channel.Name = ctype
 	s.R.L.DailAddr = s.DailAddr
 	s.R.Start()
@@ -748,6 +869,10 @@ func (s *Slaver) CloseSession(sid uint16) (err error) {
 	return s.Channel.Close(sid)
 }
 
+func (s *Slaver) Signal(sid uint16, signal string) (err error) {
+	return s.Channel.Signal(sid, signal)
+}
+
 func (s *Slaver) List() (res util.Map, err error) {
 	return s.Channel.List()
 }
@@ -772,6 +897,17 @@ func (s *Slaver) RealLog(name []string, ns map[string]int64, keys map[string]str
 	return
 }
 
+//RealLogBy will merge the realtime log and group it by label, see Channel.RealLogBy.
+func (s *Slaver) RealLogBy(name []string, ns map[string]int64, keys map[string]string, clear int, by string) (all util.Map, err error) {
+	all, err = s.Channel.RealLogBy(name, ns, keys, clear, by)
+	return
+}
+
+func (s *Slaver) RealQuery(name, ns []string, keys map[string]string, from, to, step int64) (all util.Map, err error) {
+	all, err = s.Channel.RealQuery(name, ns, keys, from, to, step)
+	return
+}
+
 //OnConn see ConHandler for detail
 func (s *Slaver) OnConn(con netw.Con) bool {
 	//fmt.Println("master is connected")
@@ -807,6 +943,10 @@ type Channel struct {
 	pings map[string]*EchoPing
 	pslck sync.RWMutex
 	Real  *RealTime
+	//the slaver binary version which is reported in host facts.
+	Version string
+	//the alert manager which firing alerts is reported in status.
+	Alert *AlertManager
 }
 
 func NewChannel(bh *impl.OBDH, rc *impl.RC_Con, rm *impl.RCM_Con, rs *impl.RCM_S, sp *SessionPool) *Channel {
@@ -824,8 +964,10 @@ func NewChannel(bh *impl.OBDH, rc *impl.RC_Con, rm *impl.RCM_Con, rs *impl.RCM_S
 	channel.RS.AddHFunc("status", channel.StatusH)
 	channel.RS.AddHFunc("dial", channel.DialH)
 	channel.RS.AddHFunc("close", channel.CloseH)
+	channel.RS.AddHFunc("signal", channel.SignalH)
 	channel.RS.AddHFunc("ping", channel.PingH)
 	channel.RS.AddHFunc("real_log", channel.RealLogH)
+	channel.RS.AddHFunc("real_query", channel.RealQueryH)
 	channel.BH.AddF(ChannelCmdC, channel.OnMasterCmd)
 	return channel
 }
@@ -868,6 +1010,11 @@ func (c *Channel) CloseH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 		return
 	}
 	defer c.M.Done(c.M.Start("close"))
+	if sids, ok := c.SP.Find(sid).(*SidSession); ok {
+		if exit := rc.MapVal("exit"); exit != nil {
+			sids.SetExitStatus(ParseExitStatus(exit))
+		}
+	}
 	session := c.SP.Remove(sid)
 	if session == nil {
 		err = fmt.Errorf("session(%v) is not found", sid)
@@ -894,9 +1041,13 @@ func (c *Channel) Close(sid uint16) (err error) {
 
 func (c *Channel) CloseSession(session Session) (err error) {
 	sid := session.ID()
-	_, err = c.RM.Exec_m("/usr/close", util.Map{
+	args := util.Map{
 		"sid": sid,
-	})
+	}
+	if exiter, ok := session.(Exiter); ok && exiter.ExitStatus() != nil {
+		args["exit"] = exiter.ExitStatus().Map()
+	}
+	_, err = c.RM.Exec_m("/usr/close", args)
 	if err == nil {
 		log.D("Channel(%v) close remote session(%v) success", c.Name, sid)
 	} else {
@@ -905,6 +1056,33 @@ func (c *Channel) CloseSession(session Session) (err error) {
 	return
 }
 
+func (c *Channel) SignalH(rc *impl.RCM_Cmd) (val interface{}, err error) {
+	var sid uint16
+	var signal string
+	err = rc.ValidF(`
+		sid,R|I,R:0;
+		signal,R|S,L:0;
+		`, &sid, &signal)
+	if err != nil {
+		return
+	}
+	defer c.M.Done(c.M.Start("signal"))
+	err = c.SP.Signal(sid, signal)
+	if err == nil {
+		log.D("Channel(%v) send signal(%v) to session(%v) success", c.Name, signal, sid)
+	}
+	return
+}
+
+//Signal will send signal to the remote raw of session.
+func (c *Channel) Signal(sid uint16, signal string) (err error) {
+	_, err = c.RM.Exec_m("/usr/signal", util.Map{
+		"sid":    sid,
+		"signal": signal,
+	})
+	return
+}
+
 func (c *Channel) Dial(name, uri string) (sid uint16, err error) {
 	res, err := c.RM.Exec_m("/usr/dial", util.Map{
 		"uri":  uri,
@@ -1000,7 +1178,18 @@ func (c *Channel) PingSession(name, data string) (used, slaverCall, slaverBack i
 }
 
 func (c *Channel) StatusH(rc *impl.RCM_Cmd) (val interface{}, err error) {
-	val, err = c.M.State()
+	state, err := c.M.State()
+	if err != nil {
+		return
+	}
+	if state == nil {
+		state = util.Map{}
+	}
+	state["facts"] = NewHostFacts(c.Version)
+	if c.Alert != nil {
+		state["alerts"] = c.Alert.Alerts()
+	}
+	val = state
 	return
 }
 
@@ -1026,16 +1215,62 @@ func (c *Channel) RealLogH(rc *impl.RCM_Cmd) (val interface{}, err error) {
 		ns[n] = nsm.IntVal(n)
 	}
 	hosts, alllog := c.Real.MergeLog(ns, keys)
-	val = util.Map{"hosts": hosts, "logs": alllog}
+	res := util.Map{"hosts": hosts, "logs": alllog}
+	if by := rc.StrVal("by"); len(by) > 0 {
+		res["groups"] = c.Real.GroupLog(ns, keys, by)
+	}
+	val = res
+	return
+}
+
+//RealQueryH will query the history series from local realtime log.
+func (c *Channel) RealQueryH(rc *impl.RCM_Cmd) (val interface{}, err error) {
+	var from, to, step int64
+	var ns []string
+	err = rc.ValidF(`
+		host,O|S,L:0;
+		from,O|I,R:0;
+		to,O|I,R:0;
+		step,O|I,R:0;
+		`, &ns, &from, &to, &step)
+	if err != nil {
+		return
+	}
+	keys := map[string]string{}
+	keysm := rc.MapVal("keys")
+	for key := range keysm {
+		keys[key] = keysm.StrVal(key)
+	}
+	val = util.Map{"series": c.Real.Query(ns, keys, from, to, step)}
+	return
+}
+
+//RealQuery will query the history series of hosts on slavers by master, see RealTime.Query for detail.
+func (c *Channel) RealQuery(name, ns []string, keys map[string]string, from, to, step int64) (all util.Map, err error) {
+	all, err = c.RM.Exec_m("/usr/real_query", util.Map{
+		"name": strings.Join(name, ","),
+		"host": strings.Join(ns, ","),
+		"keys": keys,
+		"from": from,
+		"to":   to,
+		"step": step,
+	})
 	return
 }
 
 func (c *Channel) RealLog(name []string, ns map[string]int64, keys map[string]string, clear int) (all util.Map, err error) {
+	all, err = c.RealLogBy(name, ns, keys, clear, "")
+	return
+}
+
+//RealLogBy will merge the realtime log of hosts on slavers by master, the log is grouped by label when by is not empty.
+func (c *Channel) RealLogBy(name []string, ns map[string]int64, keys map[string]string, clear int, by string) (all util.Map, err error) {
 	all, err = c.RM.Exec_m("/usr/real_log", util.Map{
 		"name":  strings.Join(name, ","),
 		"ns":    ns,
 		"keys":  keys,
 		"clear": clear,
+		"by":    by,
 	})
 	return
 }
@@ -50,6 +50,7 @@ func TestRc(t *testing.T) {
 	// impl.ShowLog = true
 	server := NewServer()
 	server.HbDelay = 3000
+	server.Local.Real.History = NewRealHistory()
 	server.Local.SP.RegisterDefaulDialer()
 	server.SP.RegisterDefaulDialer()
 	go server.Run(":9372", nil)
@@ -110,6 +111,47 @@ func TestRc(t *testing.T) {
 			t.Error("error")
 			return
 		}
+		all, err = client.RealLogBy([]string{"master"}, map[string]int64{
+			"x1": 1000,
+		}, map[string]string{
+			"a": "avg",
+		}, 0, "region")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if groups := all.MapValP("/master/groups"); groups == nil {
+			t.Error(all)
+			return
+		}
+		all, err = client.RealQuery([]string{"master"}, []string{"x1"}, map[string]string{
+			"a": "avg",
+		}, 0, 0, 1000)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if series := all.MapValP("/master/series"); len(series) != 1 {
+			t.Error(all)
+			return
+		}
+		reader, writer := io.Pipe()
+		stream, err := client.DialSession("master", RealStreamURI([]string{"x1"}, map[string]string{"a": "sum"}, "", 1000, 100), writer)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = ReadRealStream(reader, func(res util.Map) error {
+			if res.MapVal("logs").IntVal("a") != 1 {
+				return fmt.Errorf("%v", res)
+			}
+			return io.EOF
+		})
+		stream.Close()
+		if err != io.EOF {
+			t.Error(err)
+			return
+		}
 		client.RealLog([]string{"master", "not"}, map[string]int64{
 			"x1": 1000,
 		}, map[string]string{
@@ -377,12 +419,24 @@ func TestRc(t *testing.T) {
 		t.Error(res)
 		return
 	}
+	//facts should be cached when slaver login
+	server.Master.slck.RLock()
+	cached := server.Master.facts["master"]
+	server.Master.slck.RUnlock()
+	if facts, _ := ParseHostFacts(cached); facts == nil || facts.CPU < 1 {
+		t.Error(cached)
+		return
+	}
 	res, err = client.Status("master")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	fmt.Println(util.S2Json(res))
+	if facts, _ := ParseHostFacts(res.MapValP("/master/facts")); facts == nil || facts.CPU < 1 || facts.Start < 1 {
+		t.Error(res)
+		return
+	}
 	{
 		//test session is empty
 		for _, c := range server.Master.L.CmdCs() {
@@ -67,6 +67,8 @@ type SidSession struct {
 	MaxDelay time.Duration
 	closed   int32
 	OnClose  func(session Session)
+	exit     *ExitStatus
+	exitLck  sync.RWMutex
 }
 
 func NewSidSession(sid uint16, out io.Writer, raw io.WriteCloser) *SidSession {
@@ -160,6 +162,21 @@ func (s *SidSession) Close() (err error) {
 	}
 	return
 }
+
+//ExitStatus return the exit status of remote command, it return nil when it is not command session or not exited.
+func (s *SidSession) ExitStatus() *ExitStatus {
+	s.exitLck.RLock()
+	defer s.exitLck.RUnlock()
+	return s.exit
+}
+
+//SetExitStatus will set the exit status of remote command.
+func (s *SidSession) SetExitStatus(exit *ExitStatus) {
+	s.exitLck.Lock()
+	s.exit = exit
+	s.exitLck.Unlock()
+}
+
 func (s *SidSession) OnlyClose() (err error) {
 	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
 		err = s.Raw.Close()
@@ -194,6 +211,7 @@ type SessionPool struct {
 	lck             sync.RWMutex
 	wg              sync.WaitGroup
 	Dialers         []Dialer
+	Policy          *DialPolicy //the local dial policy, all uri is allowed when it is nil.
 	OnSessionClosed func(session Session)
 }
 
@@ -207,7 +225,7 @@ func NewSessionPool() *SessionPool {
 }
 
 func (s *SessionPool) RegisterDefaulDialer() (err error) {
-	for _, dialer := range []Dialer{NewCmdDialer(), NewEchoDialer(), NewWebDialer(), NewTCPDialer()} {
+	for _, dialer := range []Dialer{NewCmdDialer(), NewEchoDialer(), NewWebDialer(), NewFileDialer(), NewTailDialer(), NewProcDialer(), NewRealDialer(), NewTCPDialer()} {
 		err = s.AddDialer(dialer)
 		if err != nil {
 			return
@@ -226,8 +244,32 @@ func (s *SessionPool) AddDialer(dialer Dialer) error {
 	return nil
 }
 
+//InsertDialer will add the dialer before TCPDialer which is matched all uri, it is appended when TCPDialer is not found.
+func (s *SessionPool) InsertDialer(dialer Dialer) error {
+	err := dialer.Bootstrap()
+	if err != nil {
+		log.E("SessionPool bootstrap dialer fail with %v", err)
+		return err
+	}
+	for idx, having := range s.Dialers {
+		if _, ok := having.(*TCPDialer); ok {
+			s.Dialers = append(s.Dialers[:idx], append([]Dialer{dialer}, s.Dialers[idx:]...)...)
+			return nil
+		}
+	}
+	s.Dialers = append(s.Dialers, dialer)
+	return nil
+}
+
 func (s *SessionPool) Dial(sid uint16, uri string, out io.Writer) (session Session, err error) {
 	var raw io.ReadWriteCloser
+	if s.Policy != nil {
+		uri, err = s.Policy.Pin(uri)
+		if err != nil {
+			log.W("SessionPool dial to %v fail with %v", uri, err)
+			return
+		}
+	}
 	err = fmt.Errorf("not matched dialer for %v", uri)
 	for _, dialer := range s.Dialers {
 		if dialer.Matched(uri) {
@@ -263,6 +305,11 @@ func (s *SessionPool) copy(session Session, raw io.ReadWriteCloser) {
 		}
 	}
 	log.D("SessionPool the session(%v) reader is close by %v", session.ID(), err)
+	if exiter, ok := raw.(Exiter); ok {
+		if sids, ok := session.(*SidSession); ok {
+			sids.SetExitStatus(exiter.ExitStatus())
+		}
+	}
 	session.Close()
 	raw.Close()
 	s.Remove(session.ID())
@@ -306,6 +353,22 @@ func (s *SessionPool) Remove(sid uint16) (session Session) {
 	return
 }
 
+//Signal will send signal to the raw of session, the raw must be Signaler.
+func (s *SessionPool) Signal(sid uint16, sig string) (err error) {
+	session, ok := s.Find(sid).(*SidSession)
+	if !ok || session == nil {
+		err = fmt.Errorf("session(%v) is not found", sid)
+		return
+	}
+	signaler, ok := session.Raw.(Signaler)
+	if !ok {
+		err = fmt.Errorf("session(%v) is not supported signal", sid)
+		return
+	}
+	err = signaler.Signal(sig)
+	return
+}
+
 func (s *SessionPool) Write(p []byte) (n int, err error) {
 	if len(p) < 3 {
 		err = fmt.Errorf("frame must be greater 3 bytes")
@@ -0,0 +1,116 @@
+package fsck
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/Centny/gwf/util"
+)
+
+//ExitStatus is the exit status of remote command.
+type ExitStatus struct {
+	Code   int    `json:"code"`
+	Signal string `json:"signal,omitempty"`
+}
+
+func (e *ExitStatus) String() string {
+	if len(e.Signal) > 0 {
+		return fmt.Sprintf("signal %v", e.Signal)
+	}
+	return fmt.Sprintf("exit %v", e.Code)
+}
+
+//Map return the exit status as util.Map to send by RC.
+func (e *ExitStatus) Map() util.Map {
+	return util.Map{
+		"code":   e.Code,
+		"signal": e.Signal,
+	}
+}
+
+//NewExitStatus will create the exit status by process state and wait error.
+func NewExitStatus(state *os.ProcessState, err error) (exit *ExitStatus) {
+	exit = &ExitStatus{}
+	if state == nil {
+		if err != nil {
+			exit.Code = -1
+		}
+		return
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		exit.Code = status.ExitStatus()
+		if status.Signaled() {
+			exit.Signal = SignalName(status.Signal())
+		}
+	} else if !state.Success() {
+		exit.Code = 1
+	}
+	return
+}
+
+//ParseExitStatus will parse the exit status from util.Map, it return nil when m is nil.
+func ParseExitStatus(m util.Map) (exit *ExitStatus) {
+	if m == nil {
+		return
+	}
+	exit = &ExitStatus{
+		Code:   int(m.IntVal("code")),
+		Signal: m.StrVal("signal"),
+	}
+	return
+}
+
+//Exiter is the interface to get the exit status after raw is closed.
+type Exiter interface {
+	ExitStatus() *ExitStatus
+}
+
+//Signaler is the interface to send signal to raw.
+type Signaler interface {
+	Signal(sig string) error
+}
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+//ParseSignal will parse the signal by name, like INT/SIGINT/int.
+func ParseSignal(name string) (sig syscall.Signal, err error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	sig, ok := signals[name]
+	if !ok {
+		err = fmt.Errorf("signal(%v) is not supported", name)
+	}
+	return
+}
+
+//SignalName return the name of signal, like INT.
+func SignalName(sig syscall.Signal) string {
+	for name, val := range signals {
+		if val == sig {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", int(sig))
+}
+
+//SignalCmd will send signal by name to the running command.
+func SignalCmd(cmd *exec.Cmd, name string) (err error) {
+	sig, err := ParseSignal(name)
+	if err != nil {
+		return
+	}
+	if cmd.Process == nil {
+		err = fmt.Errorf("process is not started")
+		return
+	}
+	err = cmd.Process.Signal(sig)
+	return
+}
@@ -0,0 +1,90 @@
+package fsck
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestCmdExitStatus(t *testing.T) {
+	dailer := NewCmdDialer()
+	raw, err := dailer.Dial(10, "tcp://cmd?exec=echo%20abc%3Bexit%203")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := ioutil.ReadAll(raw)
+	exit := raw.(Exiter).ExitStatus()
+	if string(data) != "abc\n" || exit == nil || exit.Code != 3 || len(exit.Signal) > 0 {
+		t.Error("error")
+		return
+	}
+	//
+	raw, err = dailer.Dial(10, "tcp://cmd?exec=sleep 10")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	err = raw.(Signaler).Signal("TERM")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ioutil.ReadAll(raw)
+	exit = raw.(Exiter).ExitStatus()
+	if exit == nil || exit.Signal != "TERM" {
+		t.Error("error")
+		return
+	}
+	if raw.(Signaler).Signal("xx") == nil {
+		t.Error("error")
+		return
+	}
+	//
+	exit = ParseExitStatus(exit.Map())
+	if exit.Signal != "TERM" || exit.String() != "signal TERM" {
+		t.Error("error")
+		return
+	}
+	if ParseExitStatus(nil) != nil {
+		t.Error("error")
+		return
+	}
+}
+
+func TestSessionSignal(t *testing.T) {
+	pool := NewSessionPool()
+	pool.Dialers = append(pool.Dialers, NewCmdDialer(), NewEchoDialer())
+	closed := make(chan Session, 1)
+	pool.OnSessionClosed = func(session Session) {
+		closed <- session
+	}
+	session, err := pool.Dial(1, "tcp://cmd?exec=sleep 10", ioutil.Discard)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	err = pool.Signal(session.ID(), "SIGKILL")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	closedSession := <-closed
+	exit := closedSession.(Exiter).ExitStatus()
+	if exit == nil || exit.Signal != "KILL" {
+		t.Error("error")
+		return
+	}
+	if pool.Signal(100, "INT") == nil {
+		t.Error("error")
+		return
+	}
+	echo, _ := pool.Dial(2, "echo", ioutil.Discard)
+	if pool.Signal(echo.ID(), "INT") == nil {
+		t.Error("error")
+		return
+	}
+	pool.Close()
+}
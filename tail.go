@@ -0,0 +1,479 @@
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//TailDialer is the dialer to tail file by tail:// uri, the path can be glob pattern,
+//the file is followed by name and reopened when it is rotated or truncated.
+//
+//	tail:///var/log/app.log                 follow from the end of file
+//	tail:///var/log/app.log?lines=10        follow from the last 10 lines
+//	tail:///var/log/app.log?offset=100      follow from byte offset
+//	tail:///var/log/*.log?prefix=1          follow all matched file, the line is prefixed by file name, default is 1 when path is glob pattern
+//	tail:///var/log/app.log?follow=0        send current content and close
+type TailDialer struct {
+	Interval time.Duration //the interval to check the file changes.
+	MaxLine  int           //the max bytes of one line, the line is split when it is exceeded.
+}
+
+//NewTailDialer will return new tail dialer.
+func NewTailDialer() *TailDialer {
+	return &TailDialer{
+		Interval: 500 * time.Millisecond,
+		MaxLine:  64 * 1024,
+	}
+}
+
+func (t *TailDialer) Bootstrap() error {
+	return nil
+}
+
+func (t *TailDialer) Matched(uri string) bool {
+	return strings.HasPrefix(uri, "tail://")
+}
+
+func (t *TailDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err error) {
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if len(remote.Path) < 1 {
+		err = fmt.Errorf("the tail path is required")
+		return
+	}
+	pattern := filepath.FromSlash(remote.Path)
+	_, err = filepath.Glob(pattern)
+	if err != nil {
+		return
+	}
+	var offset, lines int64 = -1, 0
+	var prefix, follow int = 0, 1
+	if strings.ContainsAny(remote.Path, "*?[") {
+		prefix = 1
+	}
+	err = util.ValidAttrF(`offset,O|I,R:-1;lines,O|I,R:0;prefix,O|I,O:0~1;follow,O|I,O:0~1`,
+		remote.Query().Get, true, &offset, &lines, &prefix, &follow)
+	if err != nil {
+		return
+	}
+	local, raw := net.Pipe()
+	go func() {
+		err := t.serve(local, pattern, offset, lines, prefix == 1, follow == 1)
+		log.D("TailDialer tail %v is done with %v", uri, err)
+		local.Close()
+	}()
+	return
+}
+
+func (t *TailDialer) serve(conn net.Conn, pattern string, offset, lines int64, prefix, follow bool) (err error) {
+	closed := make(chan int)
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}()
+	files := map[string]*tailFile{}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for first := true; ; first = false {
+		var matched []string
+		matched, err = filepath.Glob(pattern)
+		if err != nil {
+			return
+		}
+		if first && len(matched) < 1 && !follow {
+			err = fmt.Errorf("%v is not exists", pattern)
+			return
+		}
+		for _, name := range matched {
+			if _, ok := files[name]; ok {
+				continue
+			}
+			file := &tailFile{Name: name, MaxLine: t.MaxLine}
+			if prefix {
+				file.Prefix = []byte("[" + name + "] ")
+			}
+			if first {
+				err = file.Open(offset, lines)
+			} else {
+				err = file.Open(0, 0)
+			}
+			if err != nil {
+				log.D("TailDialer open %v fail with %v", name, err)
+				continue
+			}
+			files[name] = file
+		}
+		names := []string{}
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			err = files[name].Poll(conn)
+			if err != nil {
+				return
+			}
+		}
+		if !follow {
+			for _, name := range names {
+				err = files[name].Flush(conn)
+				if err != nil {
+					return
+				}
+			}
+			return
+		}
+		select {
+		case <-closed:
+			err = io.EOF
+			return
+		case <-time.After(t.Interval):
+		}
+	}
+}
+
+func (t *TailDialer) String() string {
+	return "TailDialer"
+}
+
+type tailFile struct {
+	Name    string
+	Prefix  []byte
+	MaxLine int
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+//Open will open the file and seek to offset, the last lines is used when offset is less than zero,
+//it seek to the end when both of offset and lines is not set.
+func (t *tailFile) Open(offset, lines int64) (err error) {
+	t.file, err = os.Open(t.Name)
+	if err != nil {
+		return
+	}
+	t.info, err = t.file.Stat()
+	if err != nil {
+		t.file.Close()
+		t.file = nil
+		return
+	}
+	size := t.info.Size()
+	switch {
+	case offset >= 0:
+		t.offset = offset
+		if t.offset > size {
+			t.offset = size
+		}
+	case lines > 0:
+		t.offset, err = tailLinesOffset(t.file, size, lines)
+	default:
+		t.offset = size
+	}
+	if err == nil {
+		_, err = t.file.Seek(t.offset, io.SeekStart)
+	}
+	return
+}
+
+//Poll will reopen the file when it is rotated or truncated and write the new lines to w, it return error only when write fail.
+func (t *tailFile) Poll(w io.Writer) (err error) {
+	info, serr := os.Stat(t.Name)
+	if t.file == nil {
+		if serr != nil || t.Open(0, 0) != nil {
+			return
+		}
+	} else if serr == nil && !os.SameFile(info, t.info) {
+		//rotated, read the remaining data of old file and reopen.
+		err = t.read(w)
+		if err == nil {
+			err = t.Flush(w)
+		}
+		if err != nil {
+			return
+		}
+		t.Close()
+		log.D("TailDialer file %v is rotated, reopen it", t.Name)
+		if t.Open(0, 0) != nil {
+			return
+		}
+	} else if serr == nil && info.Size() < t.offset {
+		log.D("TailDialer file %v is truncated, seek to start", t.Name)
+		t.offset, t.partial = 0, nil
+		t.file.Seek(0, io.SeekStart)
+	}
+	err = t.read(w)
+	return
+}
+
+func (t *tailFile) read(w io.Writer) (err error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := t.file.Read(buf)
+		if n > 0 {
+			t.offset += int64(n)
+			err = t.write(w, buf[:n])
+			if err != nil {
+				return
+			}
+		}
+		if rerr != nil {
+			return
+		}
+	}
+}
+
+func (t *tailFile) write(w io.Writer, data []byte) (err error) {
+	out := bytes.NewBuffer(nil)
+	data = append(t.partial, data...)
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '\n')
+		if t.MaxLine > 0 && (idx < 0 || idx > t.MaxLine) && len(data) > t.MaxLine {
+			idx = t.MaxLine - 1
+		}
+		if idx < 0 {
+			break
+		}
+		out.Write(t.Prefix)
+		out.Write(data[:idx+1])
+		if data[idx] != '\n' {
+			out.WriteByte('\n')
+		}
+		data = data[idx+1:]
+	}
+	t.partial = append([]byte{}, data...)
+	if out.Len() > 0 {
+		_, err = w.Write(out.Bytes())
+	}
+	return
+}
+
+//Flush will write the remaining partial line to w.
+func (t *tailFile) Flush(w io.Writer) (err error) {
+	if len(t.partial) > 0 {
+		err = t.write(w, []byte("\n"))
+	}
+	return
+}
+
+func (t *tailFile) Close() error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+//tailLinesOffset return the offset of last lines in file.
+func tailLinesOffset(file *os.File, size, lines int64) (offset int64, err error) {
+	buf := make([]byte, 4096)
+	var count int64
+	offset = size
+	for offset > 0 {
+		n := int64(len(buf))
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+		_, err = file.ReadAt(buf[:n], offset)
+		if err != nil {
+			return
+		}
+		for i := n - 1; i >= 0; i-- {
+			if buf[i] != '\n' || offset+i == size-1 {
+				continue
+			}
+			count++
+			if count == lines {
+				offset += i + 1
+				return
+			}
+		}
+	}
+	return
+}
+
+var tailSourceRegex = regexp.MustCompile(`^<([^>]*)>(.*)$`)
+
+//TailSource is the tail uri subscribed by TailCollector.
+type TailSource struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+	URI     string `json:"uri"`
+	Running bool   `json:"running"`
+	session Session
+	stopped bool
+}
+
+func (t *TailSource) String() string {
+	return fmt.Sprintf("<%v>%v", t.Channel, t.URI)
+}
+
+type tailSourceWriter struct {
+	Name string
+	Out  func(name string, p []byte) (n int, err error)
+	done chan int
+	once sync.Once
+}
+
+func (t *tailSourceWriter) Write(p []byte) (n int, err error) {
+	return t.Out(t.Name, p)
+}
+
+func (t *tailSourceWriter) Close() error {
+	t.once.Do(func() {
+		close(t.done)
+	})
+	return nil
+}
+
+//TailCollector will subscribe the tail uri on many slavers and write the lines to Out by source name,
+//the source is redialed after Delay when it is closed, the lines/offset is not used on redial to avoid duplicate lines.
+type TailCollector struct {
+	Dialer  ForwardDialerF
+	Out     func(name string, p []byte) (n int, err error)
+	Delay   time.Duration
+	sources map[string]*TailSource
+	lck     sync.RWMutex
+}
+
+//NewTailCollector will return new tail collector.
+func NewTailCollector(dialer ForwardDialerF, out func(name string, p []byte) (n int, err error)) *TailCollector {
+	return &TailCollector{
+		Dialer:  dialer,
+		Out:     out,
+		Delay:   5 * time.Second,
+		sources: map[string]*TailSource{},
+	}
+}
+
+//Add will subscribe the tail uri by name, the uri is like <slaver>tail:///var/log/app.log?lines=10
+func (t *TailCollector) Add(name, uri string) (source *TailSource, err error) {
+	parts := tailSourceRegex.FindStringSubmatch(uri)
+	if len(parts) < 3 || len(parts[1]) < 1 || !strings.HasPrefix(parts[2], "tail://") {
+		err = fmt.Errorf("invalid tail uri(%v), it must be like <slaver>tail:///path", uri)
+		return
+	}
+	_, err = url.Parse(parts[2])
+	if err != nil {
+		return
+	}
+	t.lck.Lock()
+	defer t.lck.Unlock()
+	if _, ok := t.sources[name]; ok {
+		err = fmt.Errorf("tail %v already exists", name)
+		return
+	}
+	source = &TailSource{Name: name, Channel: parts[1], URI: parts[2]}
+	t.sources[name] = source
+	go t.run(source)
+	return
+}
+
+func (t *TailCollector) run(source *TailSource) {
+	uri := source.URI
+	for {
+		t.lck.Lock()
+		if source.stopped {
+			t.lck.Unlock()
+			return
+		}
+		t.lck.Unlock()
+		writer := &tailSourceWriter{Name: source.Name, Out: t.Out, done: make(chan int)}
+		session, err := t.Dialer(source.Channel, uri, writer)
+		t.lck.Lock()
+		if err == nil && source.stopped {
+			session.Close()
+		}
+		if err == nil {
+			source.session, source.Running = session, true
+		}
+		t.lck.Unlock()
+		if err == nil {
+			log.D("TailCollector subscribe %v success", source)
+			<-writer.done
+			log.D("TailCollector tail %v is closed", source)
+			//redial from the end of file
+			remote, _ := url.Parse(uri)
+			query := remote.Query()
+			query.Del("lines")
+			query.Del("offset")
+			remote.RawQuery = query.Encode()
+			uri = remote.String()
+		} else {
+			log.W("TailCollector subscribe %v fail with %v", source, err)
+		}
+		t.lck.Lock()
+		source.session, source.Running = nil, false
+		stopped := source.stopped
+		t.lck.Unlock()
+		if stopped {
+			return
+		}
+		time.Sleep(t.Delay)
+	}
+}
+
+//Remove will stop the tail by name.
+func (t *TailCollector) Remove(name string) (err error) {
+	t.lck.Lock()
+	source := t.sources[name]
+	if source == nil {
+		t.lck.Unlock()
+		err = fmt.Errorf("tail %v is not exists", name)
+		return
+	}
+	delete(t.sources, name)
+	source.stopped = true
+	session := source.session
+	t.lck.Unlock()
+	if session != nil {
+		session.Close()
+	}
+	return
+}
+
+//List will return all tail source.
+func (t *TailCollector) List() (sources []*TailSource) {
+	t.lck.RLock()
+	defer t.lck.RUnlock()
+	names := []string{}
+	for name := range t.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := *t.sources[name]
+		sources = append(sources, &source)
+	}
+	return
+}
+
+//Close will stop all tail.
+func (t *TailCollector) Close() error {
+	for _, source := range t.List() {
+		t.Remove(source.Name)
+	}
+	return nil
+}
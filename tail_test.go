@@ -0,0 +1,270 @@
+package fsck
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readTailLines(reader *bufio.Reader, n int) (lines []string, err error) {
+	for i := 0; i < n; i++ {
+		var line string
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	return
+}
+
+func TestTailDialer(t *testing.T) {
+	os.RemoveAll("/tmp/fsck_tail")
+	os.MkdirAll("/tmp/fsck_tail", os.ModePerm)
+	defer os.RemoveAll("/tmp/fsck_tail")
+	ioutil.WriteFile("/tmp/fsck_tail/a.log", []byte("a1\na2\na3\n"), os.ModePerm)
+	tail := NewTailDialer()
+	tail.Interval = 50 * time.Millisecond
+	tail.Bootstrap()
+	if !tail.Matched("tail:///tmp/x") || tail.Matched("file:///tmp/x") {
+		t.Error("error")
+		return
+	}
+	//not follow
+	for uri, expect := range map[string]string{
+		"tail:///tmp/fsck_tail/a.log?follow=0":           "",
+		"tail:///tmp/fsck_tail/a.log?follow=0&lines=2":   "a2\na3\n",
+		"tail:///tmp/fsck_tail/a.log?follow=0&lines=10":  "a1\na2\na3\n",
+		"tail:///tmp/fsck_tail/a.log?follow=0&offset=3":  "a2\na3\n",
+		"tail:///tmp/fsck_tail/a.log?follow=0&offset=99": "",
+		"tail:///tmp/fsck_tail/*.log?follow=0&lines=1":   "[/tmp/fsck_tail/a.log] a3\n",
+	} {
+		raw, err := tail.Dial(10, uri)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ := ioutil.ReadAll(raw)
+		raw.Close()
+		if string(data) != expect {
+			t.Errorf("%v: %v", uri, string(data))
+			return
+		}
+	}
+	//follow
+	raw, err := tail.Dial(10, "tail:///tmp/fsck_tail/*.log?lines=1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reader := bufio.NewReader(raw)
+	lines, err := readTailLines(reader, 1)
+	if err != nil || lines[0] != "[/tmp/fsck_tail/a.log] a3" {
+		t.Errorf("%v,%v", lines, err)
+		return
+	}
+	file, _ := os.OpenFile("/tmp/fsck_tail/a.log", os.O_APPEND|os.O_WRONLY, os.ModePerm)
+	file.WriteString("a4\na")
+	file.Sync()
+	time.Sleep(100 * time.Millisecond)
+	file.WriteString("5\n")
+	file.Close()
+	lines, err = readTailLines(reader, 2)
+	if err != nil || lines[0] != "[/tmp/fsck_tail/a.log] a4" || lines[1] != "[/tmp/fsck_tail/a.log] a5" {
+		t.Errorf("%v,%v", lines, err)
+		return
+	}
+	//new file
+	ioutil.WriteFile("/tmp/fsck_tail/b.log", []byte("b1\n"), os.ModePerm)
+	lines, err = readTailLines(reader, 1)
+	if err != nil || lines[0] != "[/tmp/fsck_tail/b.log] b1" {
+		t.Errorf("%v,%v", lines, err)
+		return
+	}
+	//rotate
+	os.Rename("/tmp/fsck_tail/a.log", "/tmp/fsck_tail/a.log.1")
+	time.Sleep(100 * time.Millisecond)
+	ioutil.WriteFile("/tmp/fsck_tail/a.log", []byte("n1\n"), os.ModePerm)
+	lines, err = readTailLines(reader, 1)
+	if err != nil || lines[0] != "[/tmp/fsck_tail/a.log] n1" {
+		t.Errorf("%v,%v", lines, err)
+		return
+	}
+	//truncate
+	time.Sleep(100 * time.Millisecond)
+	ioutil.WriteFile("/tmp/fsck_tail/a.log", []byte("t\n"), os.ModePerm)
+	lines, err = readTailLines(reader, 1)
+	if err != nil || lines[0] != "[/tmp/fsck_tail/a.log] t" {
+		t.Errorf("%v,%v", lines, err)
+		return
+	}
+	raw.Close()
+	//long line
+	tail.MaxLine = 4
+	ioutil.WriteFile("/tmp/fsck_tail/c.txt", []byte("1234567\n"), os.ModePerm)
+	raw, _ = tail.Dial(10, "tail:///tmp/fsck_tail/c.txt?follow=0&offset=0")
+	data, _ := ioutil.ReadAll(raw)
+	if string(data) != "1234\n567\n" {
+		t.Error(string(data))
+		return
+	}
+	//error
+	for _, uri := range []string{
+		"tail://",
+		"tail:///tmp/fsck_tail/[",
+		"tail:///tmp/fsck_tail/a.log?lines=x",
+		"%x",
+	} {
+		_, err = tail.Dial(10, uri)
+		if err == nil {
+			t.Error(uri)
+			return
+		}
+	}
+	raw, _ = tail.Dial(10, "tail:///tmp/fsck_tail/none.log?follow=0")
+	data, _ = ioutil.ReadAll(raw)
+	if len(data) > 0 {
+		t.Error(string(data))
+		return
+	}
+	fmt.Printf("%v\n", tail)
+}
+
+type tailTestSession struct {
+	net.Conn
+}
+
+func (t *tailTestSession) ID() uint16 {
+	return 0
+}
+
+func (t *tailTestSession) RawWrite(p []byte) (n int, err error) {
+	return t.Write(p)
+}
+
+func (t *tailTestSession) OnlyClose() error {
+	return t.Close()
+}
+
+func TestTailCollector(t *testing.T) {
+	os.RemoveAll("/tmp/fsck_tail")
+	os.MkdirAll("/tmp/fsck_tail", os.ModePerm)
+	defer os.RemoveAll("/tmp/fsck_tail")
+	ioutil.WriteFile("/tmp/fsck_tail/a.log", []byte("a1\na2\n"), os.ModePerm)
+	tail := NewTailDialer()
+	tail.Interval = 50 * time.Millisecond
+	var sessions []net.Conn
+	lck := sync.Mutex{}
+	dialer := func(channel, uri string, raw io.WriteCloser) (session Session, err error) {
+		if channel != "test" {
+			err = fmt.Errorf("channel %v not found", channel)
+			return
+		}
+		conn, err := tail.Dial(0, uri)
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(raw, conn)
+			raw.Close()
+		}()
+		lck.Lock()
+		sessions = append(sessions, conn.(net.Conn))
+		lck.Unlock()
+		session = &tailTestSession{Conn: conn.(net.Conn)}
+		return
+	}
+	out := map[string]*bytes.Buffer{}
+	collector := NewTailCollector(dialer, func(name string, p []byte) (n int, err error) {
+		lck.Lock()
+		defer lck.Unlock()
+		if out[name] == nil {
+			out[name] = bytes.NewBuffer(nil)
+		}
+		return out[name].Write(p)
+	})
+	collector.Delay = 50 * time.Millisecond
+	wait := func(name, expect string) bool {
+		for i := 0; i < 100; i++ {
+			lck.Lock()
+			val := ""
+			if out[name] != nil {
+				val = out[name].String()
+			}
+			lck.Unlock()
+			if val == expect {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	_, err := collector.Add("a", "<test>tail:///tmp/fsck_tail/a.log?lines=1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !wait("a", "a2\n") {
+		t.Error(out["a"])
+		return
+	}
+	//redial from end after closed
+	time.Sleep(100 * time.Millisecond)
+	lck.Lock()
+	sessions[0].Close()
+	lck.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	file, _ := os.OpenFile("/tmp/fsck_tail/a.log", os.O_APPEND|os.O_WRONLY, os.ModePerm)
+	file.WriteString("a3\n")
+	file.Close()
+	if !wait("a", "a2\na3\n") {
+		t.Error(out["a"])
+		return
+	}
+	if sources := collector.List(); len(sources) != 1 || !sources[0].Running || sources[0].String() != "<test>tail:///tmp/fsck_tail/a.log?lines=1" {
+		t.Error(sources)
+		return
+	}
+	//dial fail
+	collector.Add("b", "<none>tail:///tmp/fsck_tail/a.log")
+	time.Sleep(100 * time.Millisecond)
+	//error
+	for _, uri := range []string{
+		"tail:///tmp/fsck_tail/a.log",
+		"<test>tcp://localhost",
+		"<>tail:///tmp/fsck_tail/a.log",
+		"<test>tail://%x",
+	} {
+		if _, err = collector.Add("c", uri); err == nil {
+			t.Error(uri)
+			return
+		}
+	}
+	if _, err = collector.Add("a", "<test>tail:///tmp/fsck_tail/a.log"); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = collector.Remove("none"); err == nil {
+		t.Error(err)
+		return
+	}
+	err = collector.Remove("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	collector.Close()
+	time.Sleep(100 * time.Millisecond)
+	if len(collector.List()) > 0 {
+		t.Error("not removed")
+		return
+	}
+}
@@ -0,0 +1,471 @@
+package fsck
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Centny/gwf/log"
+)
+
+const (
+	//TapOut is the direction from client to remote.
+	TapOut = 0
+	//TapIn is the direction from remote to client.
+	TapIn = 1
+)
+
+//TapMagic is the file header of framed tap format.
+var TapMagic = []byte("SCTAP\x01")
+
+//TapMaxFrame is the max bytes of one frame record or pcapng block, the larger data is split when writing and rejected when reading.
+var TapMaxFrame = 16 * 1024 * 1024
+
+var tapSequence uint64
+
+//TapRecord is the captured data of one direction.
+type TapRecord struct {
+	Dir  int
+	Time time.Time
+	Data []byte
+}
+
+//TapWriter is the writer to record tap data.
+type TapWriter interface {
+	WriteRecord(dir int, ts time.Time, data []byte) error
+	Close() error
+}
+
+//TapConn is the net.Conn wrapper to record both directions of forward connection,
+//it is configured by the local uri query of tcp mapping.
+//
+//	tap=1                    enable tap
+//	tap_dir=/tmp/sctrl-tap   the directory to save tap file
+//	tap_format=frame         the tap file format in frame/pcapng
+type TapConn struct {
+	net.Conn
+	Writer TapWriter
+	lck    sync.Mutex
+}
+
+//NewTapConn will create the tap conn by mapping option, it return the raw conn when tap is not enabled.
+func NewTapConn(m *Mapping, conn net.Conn) (tconn net.Conn, err error) {
+	var tap, dir, format string
+	err = m.LocalValidF(`tap,O|S,O:0~1;tap_dir,O|S,L:0;tap_format,O|S,O:frame~pcapng`, &tap, &dir, &format)
+	if err != nil || tap != "1" {
+		tconn = conn
+		return
+	}
+	if len(dir) < 1 {
+		dir = filepath.Join(os.TempDir(), "sctrl-tap")
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		tconn = conn
+		return
+	}
+	name := fmt.Sprintf("%v-%v-%v", m.Name, time.Now().Format("20060102150405"), atomic.AddUint64(&tapSequence, 1))
+	var writer TapWriter
+	if format == "pcapng" {
+		writer, err = NewPcapngTapWriter(filepath.Join(dir, name+".pcapng"), conn.RemoteAddr(), conn.LocalAddr())
+	} else {
+		writer, err = NewFrameTapWriter(filepath.Join(dir, name+".tap"))
+	}
+	if err != nil {
+		tconn = conn
+		return
+	}
+	log.D("Forward(%v) tap connection from %v to %v", m.Name, conn.RemoteAddr(), filepath.Join(dir, name))
+	tconn = &TapConn{Conn: conn, Writer: writer}
+	return
+}
+
+func (t *TapConn) record(dir int, data []byte) {
+	if len(data) < 1 {
+		return
+	}
+	var err error
+	t.lck.Lock()
+	if t.Writer != nil { //the tap is closed
+		err = t.Writer.WriteRecord(dir, time.Now(), data)
+	}
+	t.lck.Unlock()
+	if err != nil {
+		log.W("TapConn write record fail with %v", err)
+	}
+}
+
+func (t *TapConn) Read(p []byte) (n int, err error) {
+	n, err = t.Conn.Read(p)
+	t.record(TapOut, p[:n])
+	return
+}
+
+func (t *TapConn) Write(p []byte) (n int, err error) {
+	n, err = t.Conn.Write(p)
+	t.record(TapIn, p[:n])
+	return
+}
+
+//Close will close the conn and tap writer.
+func (t *TapConn) Close() (err error) {
+	err = t.Conn.Close()
+	t.lck.Lock()
+	if t.Writer != nil {
+		t.Writer.Close()
+		t.Writer = nil
+	}
+	t.lck.Unlock()
+	return
+}
+
+//FrameTapWriter is the tap writer by framed format: magic and [dir byte][unix nano int64][length uint32][data]...
+type FrameTapWriter struct {
+	file *os.File
+	out  *bufio.Writer
+}
+
+//NewFrameTapWriter will create the framed tap writer.
+func NewFrameTapWriter(path string) (writer *FrameTapWriter, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	writer = &FrameTapWriter{file: file, out: bufio.NewWriter(file)}
+	_, err = writer.out.Write(TapMagic)
+	return
+}
+
+//WriteRecord will write one record.
+func (f *FrameTapWriter) WriteRecord(dir int, ts time.Time, data []byte) (err error) {
+	header := make([]byte, 13)
+	header[0] = byte(dir)
+	binary.BigEndian.PutUint64(header[1:], uint64(ts.UnixNano()))
+	for len(data) > 0 && err == nil {
+		size := len(data)
+		if size > TapMaxFrame {
+			size = TapMaxFrame
+		}
+		binary.BigEndian.PutUint32(header[9:], uint32(size))
+		_, err = f.out.Write(header)
+		if err == nil {
+			_, err = f.out.Write(data[:size])
+		}
+		data = data[size:]
+	}
+	if err == nil {
+		err = f.out.Flush()
+	}
+	return
+}
+
+//Close will close the file.
+func (f *FrameTapWriter) Close() (err error) {
+	f.out.Flush()
+	err = f.file.Close()
+	return
+}
+
+const (
+	pcapngSHB         = 0x0A0D0D0A
+	pcapngIDB         = 0x00000001
+	pcapngEPB         = 0x00000006
+	pcapngByteOrder   = 0x1A2B3C4D
+	pcapngLinkTypeRaw = 101
+	tcpFlagFIN        = 0x01
+	tcpFlagSYN        = 0x02
+	tcpFlagPSH        = 0x08
+	tcpFlagACK        = 0x10
+	tapMaxSegment     = 65000
+)
+
+//PcapngTapWriter is the tap writer by pcapng format with synthetic IPv4/TCP header.
+type PcapngTapWriter struct {
+	file    *os.File
+	out     *bufio.Writer
+	addr    [2]net.IP
+	port    [2]uint16
+	seq     [2]uint32
+	ipid    uint16
+	started bool
+}
+
+//NewPcapngTapWriter will create the pcapng tap writer, the client/server address is used on synthetic header.
+func NewPcapngTapWriter(path string, client, server net.Addr) (writer *PcapngTapWriter, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	writer = &PcapngTapWriter{file: file, out: bufio.NewWriter(file), seq: [2]uint32{1000, 2000}}
+	writer.addr[TapOut], writer.port[TapOut] = tapAddr(client, net.IPv4(127, 0, 0, 1))
+	writer.addr[TapIn], writer.port[TapIn] = tapAddr(server, net.IPv4(127, 0, 0, 2))
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngByteOrder)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint16(shb[6:], 0)
+	binary.LittleEndian.PutUint64(shb[8:], 0xFFFFFFFFFFFFFFFF)
+	err = writer.writeBlock(pcapngSHB, shb)
+	if err == nil {
+		idb := make([]byte, 8)
+		binary.LittleEndian.PutUint16(idb[0:], pcapngLinkTypeRaw)
+		binary.LittleEndian.PutUint32(idb[4:], 0)
+		err = writer.writeBlock(pcapngIDB, idb)
+	}
+	if err != nil {
+		file.Close()
+	}
+	return
+}
+
+func tapAddr(addr net.Addr, def net.IP) (ip net.IP, port uint16) {
+	ip = def
+	if addr == nil {
+		return
+	}
+	host, sport, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return
+	}
+	if ipv4 := net.ParseIP(host).To4(); ipv4 != nil {
+		ip = ipv4
+	}
+	iport, _ := strconv.Atoi(sport)
+	port = uint16(iport)
+	return
+}
+
+func (p *PcapngTapWriter) writeBlock(btype uint32, body []byte) (err error) {
+	pad := (4 - len(body)%4) % 4
+	total := uint32(12 + len(body) + pad)
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header[0:], btype)
+	binary.LittleEndian.PutUint32(header[4:], total)
+	p.out.Write(header)
+	p.out.Write(body)
+	p.out.Write(make([]byte, pad))
+	binary.LittleEndian.PutUint32(header[0:], total)
+	_, err = p.out.Write(header[:4])
+	return
+}
+
+func (p *PcapngTapWriter) writePacket(dir int, ts time.Time, flags byte, data []byte) (err error) {
+	src, dst := dir, 1-dir
+	packet := make([]byte, 40+len(data))
+	//ipv4 header
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	p.ipid++
+	binary.BigEndian.PutUint16(packet[4:], p.ipid)
+	packet[8] = 64
+	packet[9] = 6
+	copy(packet[12:16], p.addr[src].To4())
+	copy(packet[16:20], p.addr[dst].To4())
+	binary.BigEndian.PutUint16(packet[10:], ipChecksum(packet[:20]))
+	//tcp header
+	tcp := packet[20:]
+	binary.BigEndian.PutUint16(tcp[0:], p.port[src])
+	binary.BigEndian.PutUint16(tcp[2:], p.port[dst])
+	binary.BigEndian.PutUint32(tcp[4:], p.seq[src])
+	if flags&tcpFlagACK > 0 {
+		binary.BigEndian.PutUint32(tcp[8:], p.seq[dst])
+	}
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], data)
+	p.seq[src] += uint32(len(data))
+	if flags&(tcpFlagSYN|tcpFlagFIN) > 0 {
+		p.seq[src]++
+	}
+	//enhanced packet block
+	epb := make([]byte, 20+len(packet))
+	micro := uint64(ts.UnixNano() / 1000)
+	binary.LittleEndian.PutUint32(epb[0:], 0)
+	binary.LittleEndian.PutUint32(epb[4:], uint32(micro>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(micro))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(packet)))
+	copy(epb[20:], packet)
+	err = p.writeBlock(pcapngEPB, epb)
+	return
+}
+
+//WriteRecord will write one record as tcp segments, the synthetic handshake is written before first record.
+func (p *PcapngTapWriter) WriteRecord(dir int, ts time.Time, data []byte) (err error) {
+	if !p.started {
+		p.started = true
+		p.writePacket(TapOut, ts, tcpFlagSYN, nil)
+		p.writePacket(TapIn, ts, tcpFlagSYN|tcpFlagACK, nil)
+		p.writePacket(TapOut, ts, tcpFlagACK, nil)
+	}
+	for len(data) > 0 && err == nil {
+		size := len(data)
+		if size > tapMaxSegment {
+			size = tapMaxSegment
+		}
+		err = p.writePacket(dir, ts, tcpFlagPSH|tcpFlagACK, data[:size])
+		data = data[size:]
+	}
+	if err == nil {
+		err = p.out.Flush()
+	}
+	return
+}
+
+//Close will write the synthetic FIN and close the file.
+func (p *PcapngTapWriter) Close() (err error) {
+	if p.started {
+		p.writePacket(TapOut, time.Now(), tcpFlagFIN|tcpFlagACK, nil)
+		p.writePacket(TapIn, time.Now(), tcpFlagFIN|tcpFlagACK, nil)
+	}
+	p.out.Flush()
+	err = p.file.Close()
+	return
+}
+
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(header[i])<<8 | uint32(header[i+1])
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
+
+//ReadTap will read the tap records from framed or pcapng format.
+func ReadTap(reader io.Reader, call func(record *TapRecord) error) (err error) {
+	in := bufio.NewReader(reader)
+	magic, err := in.Peek(len(TapMagic))
+	if err != nil {
+		return
+	}
+	if bytes.Equal(magic, TapMagic) {
+		in.Discard(len(TapMagic))
+		err = readFrameTap(in, call)
+	} else if binary.LittleEndian.Uint32(magic) == pcapngSHB {
+		err = readPcapngTap(in, call)
+	} else {
+		err = fmt.Errorf("unknown tap format")
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+func readFrameTap(in io.Reader, call func(record *TapRecord) error) (err error) {
+	header := make([]byte, 13)
+	for {
+		_, err = io.ReadFull(in, header)
+		if err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(header[9:])
+		if size > uint32(TapMaxFrame) {
+			err = fmt.Errorf("invalid tap frame length %v", size)
+			return
+		}
+		record := &TapRecord{
+			Dir:  int(header[0]),
+			Time: time.Unix(0, int64(binary.BigEndian.Uint64(header[1:]))),
+			Data: make([]byte, size),
+		}
+		_, err = io.ReadFull(in, record.Data)
+		if err == nil {
+			err = call(record)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readPcapngTap(in io.Reader, call func(record *TapRecord) error) (err error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	var client []byte
+	header := make([]byte, 8)
+	for {
+		_, err = io.ReadFull(in, header)
+		if err != nil {
+			return
+		}
+		btype, total := order.Uint32(header[0:]), order.Uint32(header[4:])
+		if btype == pcapngSHB && binary.BigEndian.Uint32(header[4:]) == 0x0A0D0D0A {
+			order = binary.BigEndian
+			total = order.Uint32(header[4:])
+		}
+		if total < 12 || total > uint32(TapMaxFrame) {
+			err = fmt.Errorf("invalid pcapng block length %v", total)
+			return
+		}
+		body := make([]byte, total-8)
+		_, err = io.ReadFull(in, body)
+		if err != nil {
+			return
+		}
+		if btype == pcapngSHB && order.Uint32(body) != pcapngByteOrder {
+			if binary.BigEndian.Uint32(body) == pcapngByteOrder {
+				order = binary.BigEndian
+			} else {
+				order = binary.LittleEndian
+			}
+		}
+		if btype != pcapngEPB || len(body) < 20 {
+			continue
+		}
+		micro := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+		caplen := int(order.Uint32(body[12:]))
+		if 20+caplen > len(body) {
+			continue
+		}
+		packet := body[20 : 20+caplen]
+		if len(packet) < 20 || packet[0]>>4 != 4 || packet[9] != 6 {
+			continue
+		}
+		ihl := int(packet[0]&0x0F) * 4
+		if ihl < 20 {
+			err = fmt.Errorf("invalid ip header length %v", ihl)
+			return
+		}
+		if len(packet) < ihl+20 {
+			continue
+		}
+		tcp := packet[ihl:]
+		offset := int(tcp[12]>>4) * 4
+		if offset < 20 || offset > len(tcp) {
+			err = fmt.Errorf("invalid tcp data offset %v", offset)
+			return
+		}
+		src := append(append([]byte{}, packet[12:16]...), tcp[0:2]...)
+		if client == nil {
+			client = src
+		}
+		data := tcp[offset:]
+		if len(data) < 1 {
+			continue
+		}
+		record := &TapRecord{
+			Dir:  TapOut,
+			Time: time.Unix(0, int64(micro)*1000),
+			Data: data,
+		}
+		if !bytes.Equal(src, client) {
+			record.Dir = TapIn
+		}
+		err = call(record)
+		if err != nil {
+			return
+		}
+	}
+}
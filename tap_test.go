@@ -0,0 +1,144 @@
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTapFormat(t *testing.T, format string) {
+	os.RemoveAll("/tmp/test_tap")
+	m, _ := NewMapping("x", fmt.Sprintf("tcp://:0?tap=1&tap_dir=/tmp/test_tap&tap_format=%v<m>tcp://localhost:80", format))
+	client, server := net.Pipe()
+	conn, err := NewTapConn(m, server)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := conn.(*TapConn); !ok {
+		t.Error("error")
+		return
+	}
+	go func() {
+		client.Write([]byte("hello"))
+		buf := make([]byte, 5)
+		io.ReadFull(client, buf)
+		client.Close()
+	}()
+	buf := make([]byte, 5)
+	io.ReadFull(conn, buf)
+	conn.Write([]byte("world"))
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	conn.(*TapConn).record(TapIn, []byte("closed")) //not panic after closed
+	files, _ := filepath.Glob("/tmp/test_tap/x-*")
+	if len(files) != 1 {
+		t.Error("error")
+		return
+	}
+	in, err := os.Open(files[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer in.Close()
+	records := []*TapRecord{}
+	err = ReadTap(in, func(record *TapRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(records) != 2 || records[0].Dir != TapOut || !bytes.Equal(records[0].Data, []byte("hello")) ||
+		records[1].Dir != TapIn || !bytes.Equal(records[1].Data, []byte("world")) {
+		t.Error("error")
+		return
+	}
+}
+
+func TestTap(t *testing.T) {
+	testTapFormat(t, "frame")
+	testTapFormat(t, "pcapng")
+	//
+	//not tap
+	m, _ := NewMapping("x", "tcp://:0<m>tcp://localhost:80")
+	client, _ := net.Pipe()
+	conn, _ := NewTapConn(m, client)
+	if conn != client {
+		t.Error("error")
+		return
+	}
+	//
+	//unknown format
+	err := ReadTap(bytes.NewBufferString("xxxxxxxxx"), nil)
+	if err == nil {
+		t.Error("error")
+		return
+	}
+}
+
+func TestTapMalformed(t *testing.T) {
+	//frame length is too large
+	frame := append(append([]byte{}, TapMagic...), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF)
+	if err := ReadTap(bytes.NewBuffer(frame), func(record *TapRecord) error { return nil }); err == nil {
+		t.Error("error")
+		return
+	}
+	//large record is split when writing
+	os.RemoveAll("/tmp/test_tap")
+	os.MkdirAll("/tmp/test_tap", 0700)
+	oldMax := TapMaxFrame
+	TapMaxFrame = 4
+	defer func() { TapMaxFrame = oldMax }()
+	writer, err := NewFrameTapWriter("/tmp/test_tap/x.tap")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	writer.WriteRecord(TapOut, time.Now(), []byte("0123456789"))
+	writer.Close()
+	in, _ := os.Open("/tmp/test_tap/x.tap")
+	readed := bytes.NewBuffer(nil)
+	err = ReadTap(in, func(record *TapRecord) error {
+		readed.Write(record.Data)
+		return nil
+	})
+	in.Close()
+	if err != nil || readed.String() != "0123456789" {
+		t.Error(err)
+		return
+	}
+	TapMaxFrame = oldMax
+	//pcapng with tcp data offset out of the packet
+	pwriter, err := NewPcapngTapWriter("/tmp/test_tap/x.pcapng", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2000})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pwriter.WriteRecord(TapOut, time.Now(), []byte("abc"))
+	pwriter.Close()
+	data, _ := ioutil.ReadFile("/tmp/test_tap/x.pcapng")
+	//the last packet is FIN without data, the data offset is the 12 byte of tcp header which is followed by 4 bytes block length
+	data[len(data)-4-20+12] = 0xF0
+	err = ReadTap(bytes.NewBuffer(data), func(record *TapRecord) error { return nil })
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	//pcapng block length is too large
+	data, _ = ioutil.ReadFile("/tmp/test_tap/x.pcapng")
+	data = append(data, 6, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF)
+	err = ReadTap(bytes.NewBuffer(data), func(record *TapRecord) error { return nil })
+	if err == nil {
+		t.Error("error")
+		return
+	}
+}
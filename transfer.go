@@ -0,0 +1,607 @@
+package fsck
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//FileInfo is the file info of transfer.
+type FileInfo struct {
+	Path   string `json:"path"`
+	Exists bool   `json:"exists"`
+	Dir    bool   `json:"dir,omitempty"`
+	Size   int64  `json:"size"`
+	Mode   uint32 `json:"mode"`
+	Mtime  int64  `json:"mtime"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+//FileReply is the reply of file transfer, it is sent as one json line.
+type FileReply struct {
+	Error string      `json:"error,omitempty"`
+	Info  *FileInfo   `json:"info,omitempty"`
+	Files []*FileInfo `json:"files,omitempty"`
+}
+
+//FileSHA256 return the sha256 of first size bytes of file, all data is used when size is less than zero.
+func FileSHA256(filename string, size int64) (sum string, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if size > -1 {
+		reader = io.LimitReader(file, size)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	sum = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+//NewFileInfo will create the file info by os.FileInfo.
+func NewFileInfo(name string, info os.FileInfo) *FileInfo {
+	return &FileInfo{
+		Path:   name,
+		Exists: true,
+		Dir:    info.IsDir(),
+		Size:   info.Size(),
+		Mode:   uint32(info.Mode().Perm()),
+		Mtime:  info.ModTime().Unix(),
+	}
+}
+
+//FileDialer is the dialer to transfer file by file:// uri, the operation is configured by uri query.
+//
+//	file:///path?op=stat&prefix=N           stat file, the sha256 is calculated by first N bytes when prefix is set
+//	file:///path?op=list                    list all files in directory recursively
+//	file:///path?op=mkdir&mode=&mtime=      create directory
+//	file:///path?op=put&size=&offset=&mode=&mtime=
+//	                                        put file from offset, the reply is sent after size bytes is received
+//	file:///path?op=get&offset=             get file from offset, the data is sent after reply
+type FileDialer struct {
+	ChunkSize int
+}
+
+func NewFileDialer() *FileDialer {
+	return &FileDialer{
+		ChunkSize: 32 * 1024,
+	}
+}
+
+func (f *FileDialer) Bootstrap() error {
+	return nil
+}
+
+func (f *FileDialer) Matched(uri string) bool {
+	return strings.HasPrefix(uri, "file://")
+}
+
+func (f *FileDialer) Dial(cid uint16, uri string) (raw io.ReadWriteCloser, err error) {
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if len(remote.Path) < 1 {
+		err = fmt.Errorf("the file path is required")
+		return
+	}
+	local, raw := net.Pipe()
+	go func() {
+		err := f.serve(local, remote)
+		if err != nil {
+			log.D("FileDialer serve %v fail with %v", uri, err)
+		}
+		local.Close()
+	}()
+	return
+}
+
+func (f *FileDialer) serve(conn net.Conn, remote *url.URL) (err error) {
+	var op, mode string
+	var size, offset, mtime, prefix int64 = -1, 0, 0, -1
+	err = util.ValidAttrF(`op,R|S,O:stat~list~mkdir~put~get;size,O|I,R:-1;offset,O|I,R:-1;mode,O|S,L:0;mtime,O|I,R:-1;prefix,O|I,R:-1`,
+		remote.Query().Get, true, &op, &size, &offset, &mode, &mtime, &prefix)
+	if err != nil {
+		writeFileReply(conn, &FileReply{Error: err.Error()})
+		return
+	}
+	var perm uint32
+	if len(mode) > 0 {
+		_, err = fmt.Sscanf(mode, "%o", &perm)
+		if err != nil {
+			writeFileReply(conn, &FileReply{Error: err.Error()})
+			return
+		}
+	}
+	filename := filepath.FromSlash(remote.Path)
+	reply := &FileReply{}
+	switch op {
+	case "stat":
+		reply.Info, err = f.stat(filename, prefix)
+	case "list":
+		reply.Files, err = f.list(filename)
+	case "mkdir":
+		reply.Info, err = f.mkdir(filename, os.FileMode(perm), mtime)
+	case "put":
+		reply.Info, err = f.put(conn, filename, size, offset, os.FileMode(perm), mtime)
+	case "get":
+		return f.get(conn, filename, offset)
+	default:
+		err = fmt.Errorf("op(%v) is not supported", op)
+	}
+	if err != nil {
+		reply = &FileReply{Error: err.Error()}
+	}
+	err = writeFileReply(conn, reply)
+	return
+}
+
+func (f *FileDialer) stat(filename string, prefix int64) (info *FileInfo, err error) {
+	osInfo, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		info, err = &FileInfo{Path: filename}, nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	info = NewFileInfo(filename, osInfo)
+	if !info.Dir {
+		info.SHA256, err = FileSHA256(filename, prefix)
+	}
+	return
+}
+
+func (f *FileDialer) list(dir string) (files []*FileInfo, err error) {
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		files = append(files, NewFileInfo(filepath.ToSlash(rel), info))
+		return nil
+	})
+	return
+}
+
+func (f *FileDialer) mkdir(filename string, perm os.FileMode, mtime int64) (info *FileInfo, err error) {
+	if perm == 0 {
+		perm = 0755
+	}
+	err = os.MkdirAll(filename, perm)
+	if err == nil {
+		err = setFileAttr(filename, perm, mtime)
+	}
+	if err == nil {
+		info, err = f.stat(filename, -1)
+	}
+	return
+}
+
+func (f *FileDialer) put(conn net.Conn, filename string, size, offset int64, perm os.FileMode, mtime int64) (info *FileInfo, err error) {
+	if size < 0 {
+		err = fmt.Errorf("the size is required")
+		return
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return
+	}
+	flag := os.O_CREATE | os.O_WRONLY
+	if offset < 1 {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(filename, flag, perm)
+	if err != nil {
+		return
+	}
+	if offset > 0 {
+		var osInfo os.FileInfo
+		osInfo, err = file.Stat()
+		if err == nil && osInfo.Size() < offset {
+			err = fmt.Errorf("the offset(%v) is greater than file size(%v)", offset, osInfo.Size())
+		}
+		if err == nil {
+			err = file.Truncate(offset)
+		}
+		if err == nil {
+			_, err = file.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return
+		}
+	}
+	_, err = io.CopyBuffer(file, io.LimitReader(conn, size-offset), make([]byte, f.ChunkSize))
+	file.Close()
+	if err != nil {
+		return
+	}
+	err = setFileAttr(filename, perm, mtime)
+	if err == nil {
+		info, err = f.stat(filename, -1)
+	}
+	if err == nil && info.Size != size {
+		err = fmt.Errorf("expect receive %v bytes, but %v", size, info.Size)
+	}
+	return
+}
+
+func (f *FileDialer) get(conn net.Conn, filename string, offset int64) (err error) {
+	info, err := f.stat(filename, -1)
+	if err == nil && (!info.Exists || info.Dir) {
+		err = fmt.Errorf("%v is not exists or is directory", filename)
+	}
+	if err == nil && offset > info.Size {
+		err = fmt.Errorf("the offset(%v) is greater than file size(%v)", offset, info.Size)
+	}
+	if err != nil {
+		writeFileReply(conn, &FileReply{Error: err.Error()})
+		return
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		writeFileReply(conn, &FileReply{Error: err.Error()})
+		return
+	}
+	defer file.Close()
+	err = writeFileReply(conn, &FileReply{Info: info})
+	if err != nil {
+		return
+	}
+	if offset > 0 {
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			return
+		}
+	}
+	_, err = io.CopyBuffer(conn, io.LimitReader(file, info.Size-offset), make([]byte, f.ChunkSize))
+	return
+}
+
+func (f *FileDialer) String() string {
+	return "FileDialer"
+}
+
+func setFileAttr(filename string, perm os.FileMode, mtime int64) (err error) {
+	err = os.Chmod(filename, perm)
+	if err == nil && mtime > 0 {
+		modified := time.Unix(mtime, 0)
+		err = os.Chtimes(filename, modified, modified)
+	}
+	return
+}
+
+func writeFileReply(w io.Writer, reply *FileReply) (err error) {
+	data, _ := json.Marshal(reply)
+	_, err = w.Write(append(data, '\n'))
+	return
+}
+
+func readFileReply(r *bufio.Reader) (reply *FileReply, err error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	reply = &FileReply{}
+	err = json.Unmarshal(line, reply)
+	if err == nil && len(reply.Error) > 0 {
+		err = fmt.Errorf("%v", reply.Error)
+	}
+	return
+}
+
+//FileTransfer is the client to put/get file by FileDialer.
+type FileTransfer struct {
+	Dial func(uri string) (raw io.ReadWriteCloser, err error)
+	Out  io.Writer
+}
+
+//NewFileTransfer will return new file transfer by dial func.
+func NewFileTransfer(dial func(uri string) (raw io.ReadWriteCloser, err error)) *FileTransfer {
+	return &FileTransfer{
+		Dial: dial,
+		Out:  os.Stdout,
+	}
+}
+
+func (f *FileTransfer) call(remote, op string, args url.Values) (conn io.ReadWriteCloser, reader *bufio.Reader, err error) {
+	if args == nil {
+		args = url.Values{}
+	}
+	args.Set("op", op)
+	uri := &url.URL{Scheme: "file", Path: remote, RawQuery: args.Encode()}
+	conn, err = f.Dial(uri.String())
+	if err == nil {
+		reader = bufio.NewReader(conn)
+	}
+	return
+}
+
+//Stat will stat the remote file, the sha256 is calculated by first prefix bytes when prefix is not less than zero.
+func (f *FileTransfer) Stat(remote string, prefix int64) (info *FileInfo, err error) {
+	args := url.Values{}
+	if prefix > -1 {
+		args.Set("prefix", fmt.Sprintf("%v", prefix))
+	}
+	conn, reader, err := f.call(remote, "stat", args)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reply, err := readFileReply(reader)
+	if err == nil {
+		info = reply.Info
+	}
+	return
+}
+
+//List will list the remote directory recursively.
+func (f *FileTransfer) List(remote string) (files []*FileInfo, err error) {
+	conn, reader, err := f.call(remote, "list", nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reply, err := readFileReply(reader)
+	if err == nil {
+		files = reply.Files
+	}
+	return
+}
+
+//Mkdir will create the remote directory with mode and mtime.
+func (f *FileTransfer) Mkdir(remote string, mode uint32, mtime int64) (err error) {
+	args := url.Values{}
+	args.Set("mode", fmt.Sprintf("%o", mode))
+	args.Set("mtime", fmt.Sprintf("%v", mtime))
+	conn, reader, err := f.call(remote, "mkdir", args)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, err = readFileReply(reader)
+	return
+}
+
+//Put will put the local file or directory to remote, the local name is appended when remote is end with /.
+func (f *FileTransfer) Put(local, remote string) (err error) {
+	info, err := os.Stat(local)
+	if err != nil {
+		return
+	}
+	if strings.HasSuffix(remote, "/") {
+		remote = path.Join(remote, filepath.Base(local))
+	}
+	if !info.IsDir() {
+		return f.PutFile(local, remote)
+	}
+	dirs := []*FileInfo{}
+	err = filepath.Walk(local, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(local, name)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remote, filepath.ToSlash(rel))
+		if info.IsDir() {
+			dirs = append(dirs, NewFileInfo(target, info))
+			return f.Mkdir(target, uint32(info.Mode().Perm()), 0)
+		}
+		return f.PutFile(name, target)
+	})
+	for i := len(dirs) - 1; i > -1 && err == nil; i-- {
+		err = f.Mkdir(dirs[i].Path, dirs[i].Mode, dirs[i].Mtime)
+	}
+	return
+}
+
+//PutFile will put the local file to remote, it will resume from remote size when remote file is the prefix of local file.
+func (f *FileTransfer) PutFile(local, remote string) (err error) {
+	osInfo, err := os.Stat(local)
+	if err != nil {
+		return
+	}
+	info := NewFileInfo(local, osInfo)
+	info.SHA256, err = FileSHA256(local, -1)
+	if err != nil {
+		return
+	}
+	var offset int64
+	rinfo, err := f.Stat(remote, -1)
+	if err != nil {
+		return
+	}
+	if rinfo.Exists && !rinfo.Dir && rinfo.Size > 0 && rinfo.Size <= info.Size {
+		var prefix string
+		prefix, err = FileSHA256(local, rinfo.Size)
+		if err != nil {
+			return
+		}
+		if prefix == rinfo.SHA256 {
+			offset = rinfo.Size
+		}
+	}
+	file, err := os.Open(local)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+	args := url.Values{}
+	args.Set("size", fmt.Sprintf("%v", info.Size))
+	args.Set("offset", fmt.Sprintf("%v", offset))
+	args.Set("mode", fmt.Sprintf("%o", info.Mode))
+	args.Set("mtime", fmt.Sprintf("%v", info.Mtime))
+	conn, reader, err := f.call(remote, "put", args)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	begin := time.Now()
+	_, err = io.Copy(conn, io.LimitReader(file, info.Size-offset))
+	if err != nil {
+		return
+	}
+	reply, err := readFileReply(reader)
+	if err != nil {
+		return
+	}
+	if reply.Info == nil || reply.Info.SHA256 != info.SHA256 {
+		err = fmt.Errorf("put %v to %v fail with sha256 not matched", local, remote)
+		return
+	}
+	f.printf("put %v -> %v %v bytes(resume from %v) used %v\n", local, remote, info.Size, offset, time.Since(begin))
+	return
+}
+
+//Get will get the remote file or directory to local, the remote name is appended when local is end with / or is directory.
+func (f *FileTransfer) Get(remote, local string) (err error) {
+	rinfo, err := f.Stat(remote, 0)
+	if err != nil {
+		return
+	}
+	if !rinfo.Exists {
+		err = fmt.Errorf("%v is not exists", remote)
+		return
+	}
+	if linfo, serr := os.Stat(local); strings.HasSuffix(local, string(filepath.Separator)) || (serr == nil && linfo.IsDir()) {
+		local = filepath.Join(local, path.Base(remote))
+	}
+	if !rinfo.Dir {
+		return f.GetFile(remote, local)
+	}
+	files, err := f.List(remote)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(local, os.FileMode(rinfo.Mode))
+	if err != nil {
+		return
+	}
+	dirs := []*FileInfo{}
+	for _, file := range files {
+		target := filepath.Join(local, filepath.FromSlash(file.Path))
+		if file.Dir {
+			err = os.MkdirAll(target, os.FileMode(file.Mode))
+			dirs = append(dirs, &FileInfo{Path: target, Mode: file.Mode, Mtime: file.Mtime})
+		} else {
+			err = f.GetFile(path.Join(remote, file.Path), target)
+		}
+		if err != nil {
+			return
+		}
+	}
+	dirs = append([]*FileInfo{{Path: local, Mode: rinfo.Mode, Mtime: rinfo.Mtime}}, dirs...)
+	for i := len(dirs) - 1; i > -1 && err == nil; i-- {
+		err = setFileAttr(dirs[i].Path, os.FileMode(dirs[i].Mode), dirs[i].Mtime)
+	}
+	return
+}
+
+//GetFile will get the remote file to local, it will resume from local size when local file is the prefix of remote file.
+func (f *FileTransfer) GetFile(remote, local string) (err error) {
+	var offset int64
+	if linfo, serr := os.Stat(local); serr == nil && !linfo.IsDir() && linfo.Size() > 0 {
+		var rinfo *FileInfo
+		rinfo, err = f.Stat(remote, linfo.Size())
+		if err != nil {
+			return
+		}
+		var prefix string
+		prefix, err = FileSHA256(local, -1)
+		if err != nil {
+			return
+		}
+		if rinfo.Size >= linfo.Size() && prefix == rinfo.SHA256 {
+			offset = linfo.Size()
+		}
+	}
+	args := url.Values{}
+	args.Set("offset", fmt.Sprintf("%v", offset))
+	conn, reader, err := f.call(remote, "get", args)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reply, err := readFileReply(reader)
+	if err != nil {
+		return
+	}
+	info := reply.Info
+	err = os.MkdirAll(filepath.Dir(local), 0755)
+	if err != nil {
+		return
+	}
+	flag := os.O_CREATE | os.O_WRONLY
+	if offset < 1 {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(local, flag, os.FileMode(info.Mode))
+	if err != nil {
+		return
+	}
+	begin := time.Now()
+	if offset > 0 {
+		err = file.Truncate(offset)
+		if err == nil {
+			_, err = file.Seek(offset, io.SeekStart)
+		}
+	}
+	if err == nil {
+		_, err = io.CopyN(file, reader, info.Size-offset)
+	}
+	file.Close()
+	if err != nil {
+		return
+	}
+	sum, err := FileSHA256(local, -1)
+	if err != nil {
+		return
+	}
+	if sum != info.SHA256 {
+		err = fmt.Errorf("get %v to %v fail with sha256 not matched", remote, local)
+		return
+	}
+	err = setFileAttr(local, os.FileMode(info.Mode), info.Mtime)
+	if err == nil {
+		f.printf("get %v -> %v %v bytes(resume from %v) used %v\n", remote, local, info.Size, offset, time.Since(begin))
+	}
+	return
+}
+
+func (f *FileTransfer) printf(format string, args ...interface{}) {
+	if f.Out != nil {
+		fmt.Fprintf(f.Out, format, args...)
+	}
+}
@@ -0,0 +1,206 @@
+package fsck
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/routing/httptest"
+	"golang.org/x/net/websocket"
+)
+
+func TestFileTransfer(t *testing.T) {
+	os.RemoveAll("/tmp/fsck_transfer")
+	defer os.RemoveAll("/tmp/fsck_transfer")
+	os.MkdirAll("/tmp/fsck_transfer/src/sub/empty", 0755)
+	ioutil.WriteFile("/tmp/fsck_transfer/src/a.txt", []byte(strings.Repeat("abc", 100000)), 0600)
+	ioutil.WriteFile("/tmp/fsck_transfer/src/sub/b.txt", []byte("b"), 0644)
+	mtime := time.Unix(1500000000, 0)
+	os.Chtimes("/tmp/fsck_transfer/src/a.txt", mtime, mtime)
+	os.Chtimes("/tmp/fsck_transfer/src/sub", mtime, mtime)
+	dialer := NewFileDialer()
+	transfer := NewFileTransfer(func(uri string) (io.ReadWriteCloser, error) {
+		return dialer.Dial(0, uri)
+	})
+	transfer.Out = ioutil.Discard
+	//
+	//put directory
+	err := transfer.Put("/tmp/fsck_transfer/src", "/tmp/fsck_transfer/put")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkTransferDir(t, "/tmp/fsck_transfer/src", "/tmp/fsck_transfer/put")
+	//
+	//get directory
+	err = transfer.Get("/tmp/fsck_transfer/src", "/tmp/fsck_transfer/get")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkTransferDir(t, "/tmp/fsck_transfer/src", "/tmp/fsck_transfer/get")
+	//
+	//put/get file to dir
+	err = transfer.Put("/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/put/sub/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkTransferFile(t, "/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/put/sub/a.txt")
+	err = transfer.Get("/tmp/fsck_transfer/src/sub/b.txt", "/tmp/fsck_transfer/get")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkTransferFile(t, "/tmp/fsck_transfer/src/sub/b.txt", "/tmp/fsck_transfer/get/b.txt")
+	//
+	//resume
+	data, _ := ioutil.ReadFile("/tmp/fsck_transfer/src/a.txt")
+	ioutil.WriteFile("/tmp/fsck_transfer/put/a.txt", data[:1000], 0600)
+	ioutil.WriteFile("/tmp/fsck_transfer/get/a.txt", data[:2000], 0600)
+	transfer.Out = os.Stdout
+	err = transfer.PutFile("/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/put/a.txt")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkTransferFile(t, "/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/put/a.txt")
+	err = transfer.GetFile("/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/get/a.txt")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkTransferFile(t, "/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/get/a.txt")
+	//
+	//not prefix
+	ioutil.WriteFile("/tmp/fsck_transfer/get/a.txt", []byte("xxx"), 0600)
+	err = transfer.GetFile("/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/get/a.txt")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkTransferFile(t, "/tmp/fsck_transfer/src/a.txt", "/tmp/fsck_transfer/get/a.txt")
+	//
+	//error
+	if err = transfer.Get("/tmp/fsck_transfer/none", "/tmp/fsck_transfer/get"); err == nil {
+		t.Error("error")
+		return
+	}
+	if err = transfer.GetFile("/tmp/fsck_transfer/src", "/tmp/fsck_transfer/get/x"); err == nil {
+		t.Error("error")
+		return
+	}
+	if err = transfer.Put("/tmp/fsck_transfer/none", "/tmp/fsck_transfer/put"); err == nil {
+		t.Error("error")
+		return
+	}
+	for _, uri := range []string{"file://", "file:///tmp?op=xx", "file:///tmp/fsck_transfer/x?op=put", "file:///tmp/fsck_transfer/x?op=put&size=1&mode=xx",
+		"file:///tmp/fsck_transfer/put/a.txt?op=put&size=1&offset=100000000", "file:///tmp/fsck_transfer/src/a.txt?op=get&offset=100000000"} {
+		raw, err := dialer.Dial(0, uri)
+		if err != nil {
+			continue
+		}
+		reply, _ := ioutil.ReadAll(raw)
+		if !strings.Contains(string(reply), "error") {
+			t.Errorf("%v:%s", uri, reply)
+			return
+		}
+	}
+}
+
+func checkTransferDir(t *testing.T, src, dst string) {
+	filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(src, name)
+		target := filepath.Join(dst, rel)
+		tinfo, err := os.Stat(target)
+		if err != nil {
+			t.Error(err)
+			return err
+		}
+		if info.Mode() != tinfo.Mode() || info.ModTime().Unix() != tinfo.ModTime().Unix() {
+			t.Errorf("%v:%v,%v-%v,%v", rel, info.Mode(), info.ModTime(), tinfo.Mode(), tinfo.ModTime())
+		}
+		if !info.IsDir() {
+			checkTransferFile(t, name, target)
+		}
+		return nil
+	})
+}
+
+func checkTransferFile(t *testing.T, src, dst string) {
+	a, _ := FileSHA256(src, -1)
+	b, _ := FileSHA256(dst, -1)
+	if a != b {
+		t.Errorf("%v,%v not matched", src, dst)
+	}
+}
+
+func TestFileTransferWeb(t *testing.T) {
+	os.RemoveAll("/tmp/fsck_transfer")
+	defer os.RemoveAll("/tmp/fsck_transfer")
+	os.MkdirAll("/tmp/fsck_transfer", 0755)
+	ioutil.WriteFile("/tmp/fsck_transfer/a.txt", []byte("abc"), 0600)
+	dialed := 0
+	forward := NewForward(func(channel, uri string, out io.WriteCloser) (session Session, err error) {
+		dialed++
+		raw, err := NewFileDialer().Dial(0, uri)
+		if err == nil {
+			go func() {
+				io.Copy(out, raw)
+				out.Close()
+			}()
+			session = &connSession{Conn: raw.(net.Conn)}
+		}
+		return
+	})
+	forward.DialToken = "abc"
+	ts := httptest.NewMuxServer()
+	ts.Mux.HFunc("^/dial(\\?.*)?$", forward.ProcDialH)
+	dial := func(token, origin string) func(uri string) (io.ReadWriteCloser, error) {
+		return func(uri string) (io.ReadWriteCloser, error) {
+			query := url.Values{}
+			query.Set("channel", "x")
+			query.Set("uri", uri)
+			query.Set("token", token)
+			return websocket.Dial(strings.Replace(ts.URL, "http://", "ws://", 1)+"/dial?"+query.Encode(), "", origin)
+		}
+	}
+	transfer := NewFileTransfer(dial("abc", ts.URL))
+	transfer.Out = ioutil.Discard
+	info, err := transfer.Stat("/tmp/fsck_transfer/a.txt", -1)
+	if err != nil || info.Size != 3 {
+		t.Error(err)
+		return
+	}
+	//dial error is replied after upgrade
+	if _, err = transfer.Stat("", -1); err == nil {
+		t.Error("error")
+		return
+	}
+	//denied before dial
+	dialed = 0
+	for _, denied := range []struct {
+		token, origin, uri string
+	}{
+		{"", ts.URL, "file:///tmp/fsck_transfer/a.txt?op=stat"},
+		{"xx", ts.URL, "file:///tmp/fsck_transfer/a.txt?op=stat"},
+		{"abc", "http://evil.com", "file:///tmp/fsck_transfer/a.txt?op=stat"},
+		{"abc", ts.URL, "tcp://cmd?exec=ls"},
+	} {
+		if _, err = dial(denied.token, denied.origin)(denied.uri); err == nil {
+			t.Errorf("%v not denied", denied)
+			return
+		}
+	}
+	forward.DialToken = ""
+	if _, err = dial("", ts.URL)("file:///tmp/fsck_transfer/a.txt?op=stat"); err == nil || dialed > 0 {
+		t.Error("error")
+		return
+	}
+}
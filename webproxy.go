@@ -0,0 +1,189 @@
+package fsck
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+//WebRewrite is the path/header rewrite option for web forward, it is parsed from the local uri query of mapping.
+//
+//	strip=/prefix            strip the path prefix before forwarding, strip=1 will strip the /ws/<name> prefix
+//	prefix=/app              add the path prefix before forwarding
+//	host=xx                  the upstream Host header, host=remote will use the remote uri host
+//	reqh=Key:Val             set request header, empty value will delete it, it can be multi
+//	resh=Key:Val             set response header, empty value will delete it, it can be multi
+//	location=1               rewrite the upstream Location header to local
+//	cookie_domain=xx         rewrite the cookie domain, cookie_domain=- will remove the domain
+//	cookie_path=1            rewrite the cookie path to local
+type WebRewrite struct {
+	Strip        string
+	Prefix       string
+	Host         string
+	ReqH         http.Header
+	ResH         http.Header
+	Location     bool
+	CookieDomain string
+	CookiePath   bool
+	remote       string
+}
+
+//NewWebRewrite will parse the rewrite option from mapping, the base is the path prefix of matched mapping.
+func NewWebRewrite(m *Mapping, base string) (w *WebRewrite) {
+	query := m.Local.Query()
+	w = &WebRewrite{
+		Strip:        query.Get("strip"),
+		Prefix:       strings.TrimSuffix(query.Get("prefix"), "/"),
+		Host:         query.Get("host"),
+		ReqH:         parseHeaderOption(query["reqh"]),
+		ResH:         parseHeaderOption(query["resh"]),
+		Location:     query.Get("location") == "1",
+		CookieDomain: query.Get("cookie_domain"),
+		CookiePath:   query.Get("cookie_path") == "1",
+		remote:       m.Remote.Host,
+	}
+	if w.Strip == "1" {
+		w.Strip = base
+	}
+	w.Strip = strings.TrimSuffix(w.Strip, "/")
+	if w.Host == "remote" {
+		w.Host = m.Remote.Host
+	}
+	return
+}
+
+func parseHeaderOption(vals []string) (header http.Header) {
+	header = http.Header{}
+	for _, val := range vals {
+		parts := strings.SplitN(val, ":", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(key) < 1 {
+			continue
+		}
+		if len(parts) < 2 {
+			header[http.CanonicalHeaderKey(key)] = nil
+			continue
+		}
+		header.Add(key, strings.TrimSpace(parts[1]))
+	}
+	return
+}
+
+func applyHeaderOption(header, opt http.Header) {
+	for key, vals := range opt {
+		if len(vals) < 1 || (len(vals) == 1 && len(vals[0]) < 1) {
+			header.Del(key)
+		} else {
+			header[key] = vals
+		}
+	}
+}
+
+//UpstreamPath return the upstream path by local path.
+func (w *WebRewrite) UpstreamPath(local string) string {
+	if len(w.Strip) > 0 && (local == w.Strip || strings.HasPrefix(local, w.Strip+"/")) {
+		local = strings.TrimPrefix(local, w.Strip)
+	}
+	if !strings.HasPrefix(local, "/") {
+		local = "/" + local
+	}
+	return w.Prefix + local
+}
+
+//LocalPath return the local path by upstream path.
+func (w *WebRewrite) LocalPath(upstream string) string {
+	if len(w.Prefix) > 0 && (upstream == w.Prefix || strings.HasPrefix(upstream, w.Prefix+"/")) {
+		upstream = strings.TrimPrefix(upstream, w.Prefix)
+	}
+	if !strings.HasPrefix(upstream, "/") {
+		upstream = "/" + upstream
+	}
+	return w.Strip + upstream
+}
+
+//Request will rewrite the request before forwarding.
+func (w *WebRewrite) Request(req *http.Request) {
+	if len(w.Strip) > 0 {
+		req.Header.Set("X-Forwarded-Prefix", w.Strip)
+	}
+	if len(w.Strip) > 0 || len(w.Prefix) > 0 {
+		req.URL.Path = w.UpstreamPath(req.URL.Path)
+		if len(req.URL.RawPath) > 0 {
+			req.URL.RawPath = w.UpstreamPath(req.URL.RawPath)
+		}
+	}
+	if len(w.Host) > 0 {
+		req.Host = w.Host
+	}
+	applyHeaderOption(req.Header, w.ReqH)
+}
+
+//Response will rewrite the response before sending back, the local is the request received from client.
+func (w *WebRewrite) Response(res *http.Response, local *http.Request) (err error) {
+	if w.Location {
+		w.rewriteLocation(res, local)
+	}
+	if len(w.CookieDomain) > 0 || w.CookiePath {
+		w.rewriteCookie(res)
+	}
+	applyHeaderOption(res.Header, w.ResH)
+	return
+}
+
+func (w *WebRewrite) rewriteLocation(res *http.Response, local *http.Request) {
+	location := res.Header.Get("Location")
+	if len(location) < 1 {
+		return
+	}
+	target, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+	if target.IsAbs() {
+		if target.Host != w.remote && (res.Request == nil || target.Host != res.Request.Host) {
+			return
+		}
+		target.Host = local.Host
+		if local.TLS == nil {
+			target.Scheme = "http"
+		} else {
+			target.Scheme = "https"
+		}
+	} else if len(target.Host) > 0 || !strings.HasPrefix(target.Path, "/") {
+		return
+	}
+	target.Path = w.LocalPath(target.Path)
+	target.RawPath = ""
+	res.Header.Set("Location", target.String())
+}
+
+//rewriteCookie will rewrite the domain/path of Set-Cookie, the line which can't be parsed is kept as original.
+func (w *WebRewrite) rewriteCookie(res *http.Response) {
+	lines := res.Header["Set-Cookie"]
+	if len(lines) < 1 {
+		return
+	}
+	res.Header.Del("Set-Cookie")
+	for _, line := range lines {
+		cookies := (&http.Response{Header: http.Header{"Set-Cookie": {line}}}).Cookies()
+		if len(cookies) != 1 {
+			res.Header.Add("Set-Cookie", line)
+			continue
+		}
+		cookie := cookies[0]
+		if w.CookieDomain == "-" {
+			cookie.Domain = ""
+		} else if len(w.CookieDomain) > 0 {
+			cookie.Domain = w.CookieDomain
+		}
+		if w.CookiePath {
+			if len(cookie.Path) > 0 {
+				cookie.Path = path.Clean(w.LocalPath(cookie.Path))
+			} else if len(w.Strip) > 0 {
+				cookie.Path = w.Strip
+			}
+		}
+		res.Header.Add("Set-Cookie", cookie.String())
+	}
+}
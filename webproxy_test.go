@@ -0,0 +1,81 @@
+package fsck
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWebRewrite(t *testing.T) {
+	m, err := NewMapping("x", "web://x?strip=1&prefix=/app/&host=remote&reqh=X-A:1&reqh=Cookie&resh=X-B:2&resh=Server&location=1&cookie_domain=-&cookie_path=1<m>http://127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rewrite := NewWebRewrite(m, "/ws/x")
+	if rewrite.UpstreamPath("/ws/x/abc") != "/app/abc" || rewrite.UpstreamPath("/ws/x") != "/app/" || rewrite.UpstreamPath("/ws/xy") != "/app/ws/xy" {
+		t.Error("error")
+		return
+	}
+	if rewrite.LocalPath("/app/abc") != "/ws/x/abc" || rewrite.LocalPath("/other") != "/ws/x/other" {
+		t.Error("error")
+		return
+	}
+	//
+	//request
+	req, _ := http.NewRequest("GET", "http://test.loc/ws/x/abc?a=1", nil)
+	req.Header.Set("Cookie", "a=1")
+	local, _ := http.NewRequest("GET", "http://test.loc/ws/x/abc?a=1", nil)
+	rewrite.Request(req)
+	if req.URL.Path != "/app/abc" || req.Host != "127.0.0.1:80" || req.Header.Get("X-A") != "1" ||
+		len(req.Header.Get("Cookie")) > 0 || req.Header.Get("X-Forwarded-Prefix") != "/ws/x" {
+		t.Error("error")
+		return
+	}
+	//
+	//response
+	res := &http.Response{Header: http.Header{}, Request: req}
+	res.Header.Set("Location", "http://127.0.0.1:80/app/login?b=2")
+	res.Header.Set("Server", "xx")
+	res.Header.Add("Set-Cookie", "s=1; Path=/app; Domain=127.0.0.1")
+	res.Header.Add("Set-Cookie", "t=2")
+	res.Header.Add("Set-Cookie", "invalid")
+	rewrite.Response(res, local)
+	if res.Header.Get("Location") != "http://test.loc/ws/x/login?b=2" {
+		t.Error(res.Header.Get("Location"))
+		return
+	}
+	if len(res.Header.Get("Server")) > 0 || res.Header.Get("X-B") != "2" {
+		t.Error("error")
+		return
+	}
+	cookies := res.Header["Set-Cookie"]
+	if len(cookies) != 3 || cookies[0] != "s=1; Path=/ws/x" || !strings.Contains(cookies[1], "Path=/ws/x") || cookies[2] != "invalid" {
+		t.Error(cookies)
+		return
+	}
+	//
+	//other location
+	res.Header.Set("Location", "http://other.loc/app/login")
+	rewrite.Response(res, local)
+	if res.Header.Get("Location") != "http://other.loc/app/login" {
+		t.Error("error")
+		return
+	}
+	res.Header.Set("Location", "/app/index")
+	rewrite.Response(res, local)
+	if res.Header.Get("Location") != "/ws/x/index" {
+		t.Error("error")
+		return
+	}
+	//
+	//not option
+	m, _ = NewMapping("x", "web://x<m>http://127.0.0.1:80")
+	rewrite = NewWebRewrite(m, "/ws/x")
+	req, _ = http.NewRequest("GET", "http://test.loc/ws/x/abc", nil)
+	rewrite.Request(req)
+	if req.URL.Path != "/ws/x/abc" || req.Host != "test.loc" {
+		t.Error("error")
+		return
+	}
+}
@@ -57,6 +57,22 @@ var WEBUI_HTML = `
 </head>
 
 <body>
+    {{if .alerts}}
+    <table class="boder_1px_t" style="margin-bottom:10px;">
+        <tr class="boder_1px">
+            <th class="boder_1px" style="color:red;">Alert</th>
+            <th class="boder_1px">Instance</th>
+            <th class="boder_1px">Value</th>
+        </tr>
+        {{range $i, $a := .alerts}}
+        <tr class="boder_1px">
+            <td class="boder_1px" style="color:red;">{{$a.Rule}}</td>
+            <td class="boder_1px">{{$a.Instance}}</td>
+            <td class="boder_1px">{{$a.Value}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
     <form action="/ui/addForward" method="POST">
         <table>
             <td>
@@ -88,6 +104,13 @@ var WEBUI_HTML = `
                         {{range $i, $f := $channel.MS}}
 						<tr class="noneborder" style="height:20px">
                             <td class="noneborder">{{printf "%v@%v" $f.Name $f | html}}</td>
+                            <td class="noneborder" style="width:120px;text-align:center;">
+								{{if $f.Health}}
+								<span style="color:{{if $f.Health.Up}}green{{else}}red{{end}};" title="{{$f.Health.Last}}">{{printf "%v" $f.Health | html}}</span>
+								{{else}}
+								&nbsp;
+								{{end}}
+							</td>
                             <td class="noneborder" style="width:60px;text-align:center;">
 								<a style="margin-left:10px;" href="/ui/removeForward?local={{$f.Local}}">Remove</a>
 							</td>
@@ -98,6 +121,13 @@ var WEBUI_HTML = `
 								&nbsp;
 								{{end}}
 							</td>
+							<td class="noneborder" style="width:60px;text-align:center;">
+								{{if eq ($f.Local.Query.Get "har") "1" }}
+								<a href="/ui/har?name={{$f.Name}}">HAR</a>
+								{{else}}
+								&nbsp;
+								{{end}}
+							</td>
                         </tr>
                         {{end}}
                     </table>
@@ -106,6 +136,9 @@ var WEBUI_HTML = `
             {{end}}
         </table>
     </p>
+    {{if .ca}}
+    <a href="/ui/ca.pem">Download CA</a>
+    {{end}}
     <table class="boder_1px" style="position:absolute;right:30px;top:5px;">
         {{range $i, $r := $.recents}} {{$f := index $r "forward"}}
         <tr class="noneborder" style="height:20px;text-align:left;">
@@ -154,6 +187,8 @@ type WebUI struct {
 	TEMP     *template.Template
 	sequence uint64
 	Ctrl     ForwardCtrl
+	CA       *CA
+	Alert    *AlertManager
 }
 
 func NewWebUI(ctrl ForwardCtrl) (webui *WebUI) {
@@ -203,6 +238,8 @@ func (w *WebUI) Hand(mux *routing.SessionMux, redirect bool) {
 	mux.HFunc("^"+pre+"/removeForward(\\?.*)?$", w.RemoveForwardH)
 	mux.HFunc("^"+pre+"/addForward(\\?.*)?$", w.AddForwardH)
 	mux.HFunc("^"+pre+"/removeRecent(\\?.*)?$", w.RemoveRecentH)
+	mux.HFunc("^"+pre+"/ca.pem(\\?.*)?$", w.ExportCAH)
+	mux.HFunc("^"+pre+"/har(\\?.*)?$", w.ExportHarH)
 	mux.HFunc("^"+pre+".*$", w.IndexH)
 	if redirect {
 		mux.HFunc("^/(\\?.*)?$", func(hs *routing.HTTPSession) routing.HResult {
@@ -269,6 +306,36 @@ func (w *WebUI) RemoveRecentH(hs *routing.HTTPSession) routing.HResult {
 	return routing.HRES_RETURN
 }
 
+func (w *WebUI) ExportCAH(hs *routing.HTTPSession) routing.HResult {
+	if w.CA == nil {
+		hs.W.WriteHeader(404)
+		return hs.Printf("%v", "CA is not enabled")
+	}
+	hs.W.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	hs.W.Header().Set("Content-Disposition", "attachment; filename=sctrl-ca.pem")
+	w.CA.ExportCA(hs.W)
+	return routing.HRES_RETURN
+}
+
+func (w *WebUI) ExportHarH(hs *routing.HTTPSession) routing.HResult {
+	var name string
+	var err = hs.ValidF(`
+		name,R|S,L:0;
+		`, &name)
+	if err != nil {
+		return hs.Printf("%v", err)
+	}
+	har, err := w.Ctrl.LoadForward().HAR(name)
+	if err != nil {
+		hs.W.WriteHeader(404)
+		return hs.Printf("%v", err)
+	}
+	hs.W.Header().Set("Content-Type", "application/json")
+	hs.W.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v.har", name))
+	har.WriteTo(hs.W)
+	return routing.HRES_RETURN
+}
+
 func (w *WebUI) IndexH(hs *routing.HTTPSession) routing.HResult {
 	ns, forwards, err := w.Ctrl.AllForwards()
 	if err != nil {
@@ -307,6 +374,11 @@ func (w *WebUI) IndexH(hs *routing.HTTPSession) routing.HResult {
 		"forwards":  forwards,
 		"recents":   recents,
 		"webSuffix": forward.WebSuffix,
+		"ca":        w.CA != nil,
+		"alerts":    []*Alert{},
+	}
+	if w.Alert != nil {
+		vals["alerts"] = w.Alert.Alerts()
 	}
 	if hs.RVal("data") == "1" {
 		hs.JRes(vals)